@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// keyPrefix marks strings that are API keys issued by this service
+const keyPrefix = "bk_"
+
+// GenerateKey returns a new random API key together with the short prefix
+// shown in listings and the hash that is persisted.
+func GenerateKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 24)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	key = keyPrefix + hex.EncodeToString(buf)
+	return key, key[:len(keyPrefix)+8], HashKey(key), nil
+}
+
+// HashKey returns the hex encoded SHA-256 digest of key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
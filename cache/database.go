@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"bytes"
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"connection_to_pg/models"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// The cached operations, which label metrics.CacheRequests
+const (
+	// OpBook is First(&book, id)
+	OpBook = "book"
+	// OpISBN is First(&book, "isbn13 = ?", isbn13)
+	OpISBN = "isbn"
+	// OpList is Find(&books, conds...)
+	OpList = "list"
+)
+
+// generationKey holds the generation of the cached books. Every entry's
+// key contains the generation it was read in, so replacing the generation
+// invalidates them all at once, in a shared store too, and a read that
+// raced a write can only store its stale result under the old one.
+const generationKey = "books:generation"
+
+// loadTimeout bounds a query shared by concurrent misses, which runs on
+// its own rather than on the context of whichever caller started it
+const loadTimeout = 30 * time.Second
+
+var bookType = reflect.TypeOf(models.Book{})
+
+// Database answers book lookups from a Store and passes everything else to
+// the database it wraps. Writes of books through it invalidate the cache
+// once they are done; writes it doesn't see, such as those in transactions
+// or by other instances, invalidate it through Watch. Concurrent misses of
+// the same lookup share one query.
+type Database struct {
+	db.Database
+	cache *bookCache
+	ctx   context.Context
+}
+
+// bookCache is the state a Database shares with its WithContext copies
+type bookCache struct {
+	store   Store
+	ttls    map[string]time.Duration
+	flights singleflight.Group
+}
+
+// New returns next cached in store, each operation for the TTL config sets
+// for it
+func New(next db.Database, store Store, config models.CacheConfig) *Database {
+	return &Database{
+		Database: next,
+		cache: &bookCache{store: store, ttls: map[string]time.Duration{
+			OpBook: config.BookTTL,
+			OpISBN: config.ISBNTTL,
+			OpList: config.ListTTL,
+		}},
+		ctx: context.Background(),
+	}
+}
+
+func (d *Database) WithContext(ctx context.Context) db.Database {
+	return &Database{Database: d.Database.WithContext(ctx), cache: d.cache, ctx: ctx}
+}
+
+func (d *Database) First(dest interface{}, conds ...interface{}) *gorm.DB {
+	if _, ok := dest.(*models.Book); ok {
+		switch {
+		case len(conds) == 1 && isID(conds[0]):
+			return d.lookup(OpBook, dest, conds, func(database db.Database, dest interface{}) *gorm.DB { return database.First(dest, conds...) })
+		case len(conds) == 2 && conds[0] == "isbn13 = ?":
+			return d.lookup(OpISBN, dest, conds, func(database db.Database, dest interface{}) *gorm.DB { return database.First(dest, conds...) })
+		}
+	}
+	return d.Database.First(dest, conds...)
+}
+
+func (d *Database) Find(dest interface{}, conds ...interface{}) *gorm.DB {
+	if _, ok := dest.(*[]models.Book); ok {
+		return d.lookup(OpList, dest, conds, func(database db.Database, dest interface{}) *gorm.DB { return database.Find(dest, conds...) })
+	}
+	return d.Database.Find(dest, conds...)
+}
+
+func (d *Database) Create(value interface{}) *gorm.DB {
+	return d.written(value, d.Database.Create(value))
+}
+
+func (d *Database) Save(value interface{}) *gorm.DB {
+	return d.written(value, d.Database.Save(value))
+}
+
+func (d *Database) Delete(value interface{}) *gorm.DB {
+	return d.written(value, d.Database.Delete(value))
+}
+
+// Invalidate drops every cached lookup
+func (d *Database) Invalidate(ctx context.Context) error {
+	return d.cache.store.Set(ctx, generationKey, []byte(uuid.NewString()), 0)
+}
+
+// Watch invalidates the cache for every change to books notifier announces
+// until ctx is cancelled. A resync invalidates it too, as changes may have
+// been missed.
+func (d *Database) Watch(ctx context.Context, notifier db.Notifier) {
+	changes, unsubscribe := notifier.Subscribe(db.BooksChannel)
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			if err := d.Invalidate(ctx); err != nil {
+				logging.FromContext(ctx).Warn("error invalidating book cache", "error", err)
+			}
+		}
+	}
+}
+
+// written invalidates the cache after a successful write of books
+func (d *Database) written(value interface{}, result *gorm.DB) *gorm.DB {
+	if result.Error == nil && holdsBooks(value) {
+		if err := d.Invalidate(d.ctx); err != nil {
+			logging.FromContext(d.ctx).Warn("error invalidating book cache", "error", err)
+		}
+	}
+	return result
+}
+
+// lookup answers op from the cache, running query into a fresh value of
+// dest's type and caching the result on a miss. Lookups the cache can't
+// serve, because op isn't cached or the store fails, go to the database.
+//
+// Misses are read from the primary: a lagging replica could return a book
+// as it was before the change that just invalidated the cache, which would
+// then be served for the whole TTL. The query they share outlives a caller
+// that goes away, so the others still get its result, while each caller
+// stops waiting when its own context is done.
+func (d *Database) lookup(op string, dest interface{}, conds []interface{}, query func(database db.Database, dest interface{}) *gorm.DB) *gorm.DB {
+	ttl := d.cache.ttls[op]
+	if ttl <= 0 {
+		return query(d.Database, dest)
+	}
+	logger := logging.FromContext(d.ctx)
+
+	generation, err := d.generation()
+	if err != nil {
+		metrics.CacheRequests.WithLabelValues(op, "error").Inc()
+		logger.Warn("error reading book cache", "error", err)
+		return query(d.Database, dest)
+	}
+	key := entryKey(generation, op, conds)
+	value, ok, err := d.cache.store.Get(d.ctx, key)
+	if err != nil {
+		metrics.CacheRequests.WithLabelValues(op, "error").Inc()
+		logger.Warn("error reading book cache", "key", key, "error", err)
+		return query(d.Database, dest)
+	}
+	if ok {
+		if err := decode(value, dest); err == nil {
+			metrics.CacheRequests.WithLabelValues(op, "hit").Inc()
+			return &gorm.DB{RowsAffected: rows(dest)}
+		}
+		// Entries written by another version of the service may not
+		// decode; they are replaced below
+		logger.Debug("error decoding cached book", "key", key, "error", err)
+	}
+	metrics.CacheRequests.WithLabelValues(op, "miss").Inc()
+
+	flight := d.cache.flights.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(d.ctx), loadTimeout)
+		defer cancel()
+		fresh := reflect.New(reflect.TypeOf(dest).Elem())
+		primary := d.Database.WithContext(db.WithPrimary(ctx))
+		if err := query(primary, fresh.Interface()).Error; err != nil {
+			return nil, err
+		}
+		value, err := encode(fresh.Interface())
+		if err != nil {
+			return nil, err
+		}
+		if err := d.cache.store.Set(ctx, key, value, ttl); err != nil {
+			logger.Warn("error writing book cache", "key", key, "error", err)
+		}
+		return value, nil
+	})
+	var shared singleflight.Result
+	select {
+	case <-d.ctx.Done():
+		return &gorm.DB{Error: d.ctx.Err()}
+	case shared = <-flight:
+	}
+	if shared.Err != nil {
+		return &gorm.DB{Error: shared.Err}
+	}
+	if err := decode(shared.Val.([]byte), dest); err != nil {
+		return &gorm.DB{Error: err}
+	}
+	return &gorm.DB{RowsAffected: rows(dest)}
+}
+
+// generation returns the current generation, starting a new one when the
+// store has none. An old generation is never reused, as its entries may
+// predate later changes.
+func (d *Database) generation() (string, error) {
+	value, ok, err := d.cache.store.Get(d.ctx, generationKey)
+	if err != nil || ok {
+		return string(value), err
+	}
+	generation := uuid.NewString()
+	return generation, d.cache.store.Set(d.ctx, generationKey, []byte(generation), 0)
+}
+
+// entryKey returns the key of a lookup. The conditions are hashed, as list
+// filters can be long.
+func entryKey(generation, op string, conds []interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", conds)))
+	return "books:" + generation + ":" + op + ":" + hex.EncodeToString(sum[:16])
+}
+
+// isID reports whether cond is a primary key value
+func isID(cond interface{}) bool {
+	switch cond.(type) {
+	case int, int64, uint, uint64:
+		return true
+	}
+	return false
+}
+
+// holdsBooks reports whether value is a book or books
+func holdsBooks(value interface{}) bool {
+	t := reflect.TypeOf(value)
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	return t == bookType
+}
+
+// encode serialises a looked up value. Gob, unlike the JSON encoding of
+// the API, keeps every field.
+func encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode replaces the value dest points to with the one encoded in data.
+// A decoded empty list is empty rather than nil, as a query returns it.
+func decode(data []byte, dest interface{}) error {
+	target := reflect.ValueOf(dest).Elem()
+	fresh := reflect.New(target.Type())
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(fresh.Interface()); err != nil {
+		return err
+	}
+	if fresh.Elem().Kind() == reflect.Slice && fresh.Elem().IsNil() {
+		fresh.Elem().Set(reflect.MakeSlice(target.Type(), 0, 0))
+	}
+	target.Set(fresh.Elem())
+	return nil
+}
+
+// rows returns the number of rows a looked up value holds
+func rows(dest interface{}) int64 {
+	if v := reflect.ValueOf(dest).Elem(); v.Kind() == reflect.Slice {
+		return int64(v.Len())
+	}
+	return 1
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/metrics"
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+var testConfig = models.CacheConfig{BookTTL: time.Minute, ISBNTTL: time.Minute, ListTTL: time.Minute}
+
+// expectBook has mockDB return the book with id, once
+func expectBook(mockDB *mocks.MockDB, id int, name string) *mock.Call {
+	return mockDB.On("First", mock.AnythingOfType("*models.Book"), id).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Book) = models.Book{ID: id, Name: name, RatingSum: 9}
+	}).Return(&gorm.DB{}).Once()
+}
+
+func TestDatabase_CachesBookLookups(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	cached := New(mockDB, NewLRU(100), testConfig)
+	expectBook(mockDB, 1, "Dune")
+	hits := testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(OpBook, "hit"))
+
+	for range 3 {
+		var book models.Book
+		require.NoError(t, cached.WithContext(context.Background()).First(&book, 1).Error)
+		assert.Equal(t, models.Book{ID: 1, Name: "Dune", RatingSum: 9}, book)
+	}
+
+	mockDB.AssertNumberOfCalls(t, "First", 1)
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(OpBook, "hit")))
+}
+
+func TestDatabase_DoesNotCacheErrorsOrDisabledOperations(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	cached := New(mockDB, NewLRU(100), models.CacheConfig{BookTTL: time.Minute})
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 2).Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), "isbn13 = ?").Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), mock.Anything).Return(&gorm.DB{})
+
+	for range 2 {
+		var book models.Book
+		assert.ErrorIs(t, cached.First(&book, 2).Error, gorm.ErrRecordNotFound)
+		cached.First(&book, "isbn13 = ?", "9780441172719")
+		var books []models.Book
+		cached.Find(&books)
+	}
+
+	mockDB.AssertNumberOfCalls(t, "First", 4)
+	mockDB.AssertNumberOfCalls(t, "Find", 2)
+}
+
+func TestDatabase_CachesEmptyLists(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	cached := New(mockDB, NewLRU(100), testConfig)
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), []interface{}{"author = ?", "Nobody"}).Return(&gorm.DB{}).Once()
+
+	for range 2 {
+		books := []models.Book{}
+		require.NoError(t, cached.Find(&books, "author = ?", "Nobody").Error)
+		assert.NotNil(t, books)
+		assert.Empty(t, books)
+	}
+}
+
+func TestDatabase_WritesInvalidate(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	cached := New(mockDB, NewLRU(100), testConfig)
+	expectBook(mockDB, 1, "Dune")
+	expectBook(mockDB, 1, "Dune Messiah")
+	mockDB.On("Save", mock.AnythingOfType("*models.Book")).Return(&gorm.DB{})
+	mockDB.On("Create", mock.AnythingOfType("*models.Review")).Return(nil)
+
+	var book models.Book
+	cached.First(&book, 1)
+	// Writes of other records leave the books cached
+	cached.Create(&models.Review{BookID: 1})
+	cached.First(&book, 1)
+	assert.Equal(t, "Dune", book.Name)
+
+	cached.Save(&book)
+	cached.First(&book, 1)
+	assert.Equal(t, "Dune Messiah", book.Name)
+	mockDB.AssertNumberOfCalls(t, "First", 2)
+}
+
+func TestDatabase_SharesConcurrentMisses(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	cached := New(mockDB, NewLRU(100), testConfig)
+	expectBook(mockDB, 1, "Dune").WaitUntil(time.After(50 * time.Millisecond))
+
+	var wg sync.WaitGroup
+	names := make([]string, 10)
+	for i := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var book models.Book
+			if cached.First(&book, 1).Error == nil {
+				names[i] = book.Name
+			}
+		}()
+	}
+	wg.Wait()
+
+	mockDB.AssertNumberOfCalls(t, "First", 1)
+	for _, name := range names {
+		assert.Equal(t, "Dune", name)
+	}
+}
+
+// contextDB fails lookups whose context is done by the time they return,
+// as the database would, and signals loading when one starts
+type contextDB struct {
+	*mocks.MockDB
+	ctx     context.Context
+	loading chan struct{}
+}
+
+func (d contextDB) WithContext(ctx context.Context) db.Database {
+	d.ctx = ctx
+	return d
+}
+
+func (d contextDB) First(dest interface{}, conds ...interface{}) *gorm.DB {
+	d.loading <- struct{}{}
+	result := d.MockDB.First(dest, conds...)
+	if d.ctx != nil && d.ctx.Err() != nil {
+		return &gorm.DB{Error: d.ctx.Err()}
+	}
+	return result
+}
+
+func TestDatabase_SharedMissOutlivesCaller(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	next := contextDB{MockDB: mockDB, loading: make(chan struct{}, 1)}
+	cached := New(next, NewLRU(100), testConfig)
+	release := make(chan time.Time)
+	expectBook(mockDB, 1, "Dune").WaitUntil(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		var book models.Book
+		first <- cached.WithContext(ctx).First(&book, 1).Error
+	}()
+	<-next.loading
+	second := make(chan string)
+	go func() {
+		var book models.Book
+		cached.First(&book, 1)
+		second <- book.Name
+	}()
+
+	// The first caller gives up without waiting for the query
+	cancel()
+	select {
+	case err := <-first:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Error("the first caller kept waiting for the query")
+	}
+
+	close(release)
+	assert.Equal(t, "Dune", <-second)
+	mockDB.AssertNumberOfCalls(t, "First", 1)
+}
+
+func TestDatabase_WatchInvalidatesOnNotifications(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	cached := New(mockDB, NewLRU(100), testConfig)
+	expectBook(mockDB, 1, "Dune")
+	expectBook(mockDB, 1, "Dune Messiah")
+
+	notifier := db.NewLocalNotifier()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cached.Watch(ctx, notifier)
+
+	var book models.Book
+	cached.First(&book, 1)
+	// The subscription is made once Watch runs; keep announcing the change
+	// until it lands
+	require.Eventually(t, func() bool {
+		notifier.Notify(nil, db.BooksChannel, `{"table":"books","op":"update","id":1}`)
+		cached.First(&book, 1)
+		return book.Name == "Dune Messiah"
+	}, time.Second, 10*time.Millisecond)
+}
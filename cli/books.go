@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/models"
+	"fmt"
+	"strconv"
+)
+
+func runBooks(e *env, args []string) error {
+	if len(args) == 0 {
+		return usagef("books: expected a subcommand: list, get, create or delete")
+	}
+
+	switch args[0] {
+	case "list":
+		return runBooksList(e, args[1:])
+	case "get":
+		return runBooksGet(e, args[1:])
+	case "create":
+		return runBooksCreate(e, args[1:])
+	case "delete":
+		return runBooksDelete(e, args[1:])
+	default:
+		return usagef("books: unknown subcommand %q", args[0])
+	}
+}
+
+func runBooksList(e *env, args []string) error {
+	fs := newFlagSet(e, "books list")
+	output := outputFlag(fs)
+	author := fs.String("author", "", "only list books by this author")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		var books []models.Book
+		var err error
+		if *author != "" {
+			err = database.Where("author = ?", *author).Find(&books).Error
+		} else {
+			err = database.Find(&books).Error
+		}
+		if err != nil {
+			return fmt.Errorf("list books: %w", err)
+		}
+		return printBooks(e, *output, books)
+	})
+}
+
+func runBooksGet(e *env, args []string) error {
+	fs := newFlagSet(e, "books get")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	id, err := idArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		book, err := findBook(database, id)
+		if err != nil {
+			return err
+		}
+		if *output == "json" {
+			return writeJSON(e.stdout, book)
+		}
+		return printBooks(e, *output, []models.Book{book})
+	})
+}
+
+func runBooksCreate(e *env, args []string) error {
+	fs := newFlagSet(e, "books create")
+	output := outputFlag(fs)
+	name := fs.String("name", "", "book name (required)")
+	author := fs.String("author", "", "book author")
+	description := fs.String("description", "", "book description")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usagef("books create: -name is required")
+	}
+
+	return withDatabase(func(database db.Database) error {
+		book := models.Book{Name: *name, Author: *author, Description: *description}
+		if err := database.Create(&book).Error; err != nil {
+			return fmt.Errorf("create book: %w", err)
+		}
+		if *output == "json" {
+			return writeJSON(e.stdout, book)
+		}
+		return printBooks(e, *output, []models.Book{book})
+	})
+}
+
+func runBooksDelete(e *env, args []string) error {
+	fs := newFlagSet(e, "books delete")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := idArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		book, err := findBook(database, id)
+		if err != nil {
+			return err
+		}
+		if err := database.Delete(&book).Error; err != nil {
+			return fmt.Errorf("delete book %d: %w", id, err)
+		}
+		fmt.Fprintf(e.stdout, "book %d deleted\n", id)
+		return nil
+	})
+}
+
+func findBook(database db.Database, id int) (models.Book, error) {
+	var book models.Book
+	if err := database.First(&book, id).Error; err != nil {
+		return book, notFound(err, "book", id)
+	}
+	return book, nil
+}
+
+func printBooks(e *env, output string, books []models.Book) error {
+	if output == "json" {
+		if books == nil {
+			books = []models.Book{}
+		}
+		return writeJSON(e.stdout, books)
+	}
+
+	rows := make([][]string, 0, len(books))
+	for _, b := range books {
+		rows = append(rows, []string{strconv.Itoa(b.ID), truncate(b.Name, 40), truncate(b.Author, 30), truncate(b.Description, 50)})
+	}
+	return writeTable(e.stdout, []string{"ID", "NAME", "AUTHOR", "DESCRIPTION"}, rows)
+}
+
+// idArg parses the single positional ID argument of a command
+func idArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, usagef("expected exactly one ID argument")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id <= 0 {
+		return 0, usagef("invalid ID %q", args[0])
+	}
+	return id, nil
+}
@@ -0,0 +1,223 @@
+// Package cli implements the admin command-line interface of the books
+// service. Every command talks directly to the configured database.
+package cli
+
+import (
+	"connection_to_pg/config"
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// Exit codes returned by Run
+const (
+	ExitOK       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitNotFound = 3
+	ExitConfig   = 4
+)
+
+var (
+	errNotFound      = errors.New("not found")
+	errInvalidConfig = errors.New("invalid configuration")
+)
+
+// usageError is returned when the command line itself is wrong
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// env carries the streams a command reads from and writes to
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	summary string
+	run     func(e *env, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":        {"Start the HTTP server (default)", runServe},
+	"migrate":      {"Apply database schema migrations", runMigrate},
+	"check-config": {"Validate the configuration and optionally ping the database", runCheckConfig},
+	"books":        {"Manage books: list, get, create, delete", runBooks},
+	"import":       {"Import books from a JSON or NDJSON file", runImport},
+	"export":       {"Export all books as JSON or NDJSON", runExport},
+	"users":        {"Manage users: list, create, delete", runUsers},
+	"keys":         {"Manage API keys: list, create, revoke", runKeys},
+}
+
+// openDatabase connects to the configured backend and returns the database
+// together with a function that closes it. Tests replace it with a mock.
+var openDatabase = func() (db.Database, func() error, error) {
+	if err := db.OpenDatabase(); err != nil {
+		return nil, nil, err
+	}
+	return db.GetDB(), db.CloseDatabase, nil
+}
+
+// migrateDatabase brings the schema of the open database up to date. Only
+// serve and migrate call it, so the other commands can point at any
+// database without changing it. Tests replace it.
+var migrateDatabase = db.Migrate
+
+// Main runs the CLI with the process arguments and streams
+func Main() int {
+	return Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+}
+
+// Run executes the command named by args and returns the process exit code.
+// Without arguments the HTTP server is started.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	e := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage(stdout)
+		return ExitOK
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+		printUsage(stderr)
+		return ExitUsage
+	}
+
+	if err := logging.Setup(stderr, config.GetLoggingConfig()); err != nil {
+		return exitCode(stderr, fmt.Errorf("%w: %v", errInvalidConfig, err))
+	}
+
+	return exitCode(stderr, cmd.run(e, args[1:]))
+}
+
+func exitCode(stderr io.Writer, err error) int {
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	fmt.Fprintln(stderr, "error:", logging.RedactDSN(err.Error()))
+
+	var uerr *usageError
+	switch {
+	case errors.As(err, &uerr):
+		return ExitUsage
+	case errors.Is(err, errNotFound):
+		return ExitNotFound
+	case errors.Is(err, errInvalidConfig):
+		return ExitConfig
+	default:
+		return ExitError
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: connection_to_pg <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].summary)
+	}
+	tw.Flush()
+}
+
+// newFlagSet returns a flag set that reports errors instead of exiting
+func newFlagSet(e *env, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usagef("%s: %v", fs.Name(), err)
+	}
+	return nil
+}
+
+// withDatabase opens the database, runs fn and closes the database again
+func withDatabase(fn func(database db.Database) error) error {
+	database, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	return fn(database)
+}
+
+// outputFlag registers the -o flag shared by commands that print records
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("o", "table", "output format: table or json")
+}
+
+func checkOutput(format string) error {
+	if format != "table" && format != "json" {
+		return usagef("unknown output format %q, expected table or json", format)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeTable prints a header followed by one tab separated row per record
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	writeRow(tw, header)
+	for _, row := range rows {
+		writeRow(tw, row)
+	}
+	return tw.Flush()
+}
+
+func writeRow(w io.Writer, cells []string) {
+	for i, cell := range cells {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, cell)
+	}
+	fmt.Fprintln(w)
+}
+
+// truncate shortens s to at most n runes for table output
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
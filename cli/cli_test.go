@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"connection_to_pg/db"
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// useMockDB makes every command talk to mockDB instead of PostgreSQL. It
+// returns the number of times the schema was migrated.
+func useMockDB(t *testing.T, mockDB *mocks.MockDB) *int {
+	original, originalMigrate := openDatabase, migrateDatabase
+	openDatabase = func() (db.Database, func() error, error) {
+		return mockDB, func() error { return nil }, nil
+	}
+	migrations := new(int)
+	migrateDatabase = func() error {
+		*migrations++
+		return nil
+	}
+	t.Cleanup(func() { openDatabase, migrateDatabase = original, originalMigrate })
+	return migrations
+}
+
+func run(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := Run(args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	code, _, stderr := run("frobnicate")
+
+	assert.Equal(t, ExitUsage, code)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+}
+
+func TestRun_Help(t *testing.T) {
+	code, stdout, _ := run("help")
+
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "check-config")
+	assert.Contains(t, stdout, "books")
+}
+
+func TestBooksList_JSON(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	useMockDB(t, mockDB)
+
+	expectedBooks := []models.Book{
+		{ID: 1, Name: "Book One", Author: "Author One"},
+		{ID: 2, Name: "Book Two", Author: "Author Two"},
+	}
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = expectedBooks
+	}).Return(&gorm.DB{})
+
+	code, stdout, _ := run("books", "list", "-o", "json")
+
+	assert.Equal(t, ExitOK, code)
+	var books []models.Book
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &books))
+	assert.Equal(t, expectedBooks, books)
+	mockDB.AssertExpectations(t)
+}
+
+func TestBooksList_Table(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	useMockDB(t, mockDB)
+
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = []models.Book{{ID: 7, Name: "Dune", Author: "Frank Herbert"}}
+	}).Return(&gorm.DB{})
+
+	code, stdout, _ := run("books", "list")
+
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "ID")
+	assert.Contains(t, stdout, "Frank Herbert")
+}
+
+func TestBooksGet_NotFound(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	useMockDB(t, mockDB)
+
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 42).
+		Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+
+	code, _, stderr := run("books", "get", "42")
+
+	assert.Equal(t, ExitNotFound, code)
+	assert.Contains(t, stderr, "book 42: not found")
+	mockDB.AssertExpectations(t)
+}
+
+func TestBooksGet_InvalidID(t *testing.T) {
+	code, _, stderr := run("books", "get", "abc")
+
+	assert.Equal(t, ExitUsage, code)
+	assert.Contains(t, stderr, `invalid ID "abc"`)
+}
+
+func TestBooksCreate_MissingName(t *testing.T) {
+	code, _, _ := run("books", "create", "-author", "Nobody")
+
+	assert.Equal(t, ExitUsage, code)
+}
+
+func TestBooksDelete_Success(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	useMockDB(t, mockDB)
+
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 3).Return(&gorm.DB{})
+	mockDB.On("Delete", mock.AnythingOfType("*models.Book")).Return(&gorm.DB{})
+
+	code, stdout, _ := run("books", "delete", "3")
+
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "book 3 deleted\n", stdout)
+	mockDB.AssertExpectations(t)
+}
+
+func TestMigrate(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	migrations := useMockDB(t, mockDB)
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), mock.Anything).Return(&gorm.DB{})
+
+	code, _, _ := run("books", "list")
+	assert.Equal(t, ExitOK, code)
+	assert.Zero(t, *migrations, "read-only commands leave the schema alone")
+
+	code, stdout, _ := run("migrate")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "migrations applied\n", stdout)
+	assert.Equal(t, 1, *migrations)
+}
+
+func TestCheckConfig_Invalid(t *testing.T) {
+	t.Setenv("DB_PORT", "not-a-port")
+	t.Setenv("DB_PASSWORD", "hunter2")
+
+	code, stdout, stderr := run("check-config")
+
+	assert.Equal(t, ExitConfig, code)
+	assert.NotContains(t, stdout, "hunter2")
+	assert.Contains(t, stderr, "DB_PORT")
+}
+
+func TestImport_DryRunNDJSON(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	useMockDB(t, mockDB)
+
+	input := "{\"name\":\"One\",\"author\":\"A\"}\n\n{\"name\":\"Two\",\"author\":\"B\"}\n"
+	var stdout, stderr bytes.Buffer
+	code := Run([]string{"import", "-format", "ndjson", "-dry-run", "-"}, strings.NewReader(input), &stdout, &stderr)
+
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "would import 2 rows: 2 created, 0 updated, 0 failed\n", stdout.String())
+	// A dry run must not write anything
+	mockDB.AssertNotCalled(t, "Create", mock.Anything)
+}
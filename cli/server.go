@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"connection_to_pg/cache"
+	"connection_to_pg/config"
+	"connection_to_pg/db"
+	"connection_to_pg/events"
+	"connection_to_pg/handlers"
+	"connection_to_pg/logging"
+	"connection_to_pg/routes"
+	"connection_to_pg/storage"
+	"connection_to_pg/tracing"
+	"connection_to_pg/webhooks"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func runServe(e *env, args []string) error {
+	fs := newFlagSet(e, "serve")
+	serverConfig := config.GetServerConfig()
+	addr := fs.String("addr", serverConfig.Addr, "address to listen on")
+	grpcAddr := fs.String("grpc-addr", serverConfig.GRPCAddr, "address the gRPC server listens on, none when empty")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	shutdown, err := tracing.Setup(context.Background(), config.GetTracingConfig())
+	if err != nil {
+		return fmt.Errorf("set up tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			slog.Error("error flushing traces", "error", err)
+		}
+	}()
+
+	storageConfig := config.GetStorageConfig()
+	blobs, err := storage.NewLocalFS(storageConfig.Dir)
+	if err != nil {
+		return err
+	}
+
+	eventsConfig := config.GetEventsConfig()
+	cacheConfig := config.GetCacheConfig()
+
+	return withDatabase(func(database db.Database) error {
+		if err := migrateDatabase(); err != nil {
+			return fmt.Errorf("migrate database schema: %w", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Feed new outbox events to the live event streams
+		broker := events.NewBroker(database, eventsConfig.PollInterval, eventsConfig.Buffer)
+		go broker.Run(ctx)
+
+		// Receive the changes announced by every instance, and have the
+		// broker pick their events up without waiting for its next poll
+		notifier := db.Notifications()
+		go func() {
+			if err := notifier.Listen(ctx); err != nil {
+				slog.Error("error listening for database changes", "error", err)
+			}
+		}()
+		changes, unsubscribe := notifier.Subscribe(db.BooksChannel)
+		defer unsubscribe()
+		go func() {
+			for range changes {
+				broker.Wake()
+			}
+		}()
+
+		// Answer repeated book reads from memory, dropping them whenever
+		// any instance changes a book
+		handlerDB := database
+		if cacheConfig.Size > 0 {
+			cached := cache.New(database, cache.NewLRU(cacheConfig.Size), cacheConfig)
+			go cached.Watch(ctx, notifier)
+			handlerDB = cached
+		}
+
+		// Create a handler with the database dependency
+		handler := &handlers.Handler{
+			DB:            handlerDB,
+			Blobs:         blobs,
+			MaxCoverBytes: storageConfig.MaxCoverBytes,
+			Fines:         config.GetFinePolicy(),
+			Events:        broker,
+			Heartbeat:     eventsConfig.Heartbeat,
+			GraphQLLimits: config.GetGraphQLConfig(),
+		}
+
+		// Deliver outbox events to webhook subscriptions in the background
+		go webhooks.NewDispatcher(database, config.GetWebhookConfig()).Run(ctx)
+
+		// Serve the books over gRPC on a port of their own
+		if *grpcAddr != "" {
+			listener, err := net.Listen("tcp", *grpcAddr)
+			if err != nil {
+				return fmt.Errorf("listen for gRPC: %w", err)
+			}
+			grpcServer := routes.SetupGRPC(handler)
+			defer grpcServer.Stop()
+			go func() {
+				slog.Info("gRPC server is running", "addr", *grpcAddr)
+				if err := grpcServer.Serve(listener); err != nil {
+					slog.Error("error serving gRPC", "error", err)
+				}
+			}()
+		}
+
+		// Setup router with the handler instance
+		r := routes.SetupRoutes(handler)
+
+		slog.Info("server is running", "addr", *addr)
+		return http.ListenAndServe(*addr, r)
+	})
+}
+
+func runMigrate(e *env, args []string) error {
+	fs := newFlagSet(e, "migrate")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	return withDatabase(func(db.Database) error {
+		if err := migrateDatabase(); err != nil {
+			return fmt.Errorf("migrate database schema: %w", err)
+		}
+		fmt.Fprintln(e.stdout, "migrations applied")
+		return nil
+	})
+}
+
+func runCheckConfig(e *env, args []string) error {
+	fs := newFlagSet(e, "check-config")
+	ping := fs.Bool("ping", false, "also connect to the database")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	dbConfig := config.GetDatabaseConfig()
+	serverConfig := config.GetServerConfig()
+	tracingConfig := config.GetTracingConfig()
+	loggingConfig := config.GetLoggingConfig()
+	storageConfig := config.GetStorageConfig()
+	fines := config.GetFinePolicy()
+	webhookConfig := config.GetWebhookConfig()
+	eventsConfig := config.GetEventsConfig()
+	graphqlConfig := config.GetGraphQLConfig()
+	cacheConfig := config.GetCacheConfig()
+	password := ""
+	if dbConfig.Password != "" {
+		password = "********"
+	}
+	replicas := make([]string, len(dbConfig.Replicas))
+	for i, dsn := range dbConfig.Replicas {
+		replicas[i] = logging.RedactDSN(dsn)
+	}
+	writeTable(e.stdout, []string{"SETTING", "VALUE"}, [][]string{
+		{"HTTP_ADDR", serverConfig.Addr},
+		{"GRPC_ADDR", serverConfig.GRPCAddr},
+		{"DB_HOST", dbConfig.Host},
+		{"DB_PORT", dbConfig.Port},
+		{"DB_USER", dbConfig.User},
+		{"DB_PASSWORD", password},
+		{"DB_NAME", dbConfig.DBName},
+		{"DB_SSLMODE", dbConfig.SSLMode},
+		{"DB_REPLICAS", strings.Join(replicas, ",")},
+		{"DB_REPLICA_CHECK_INTERVAL", dbConfig.ReplicaCheckInterval.String()},
+		{"DB_READ_YOUR_WRITES", dbConfig.ReadYourWrites.String()},
+		{"DB_CONNECT_ATTEMPTS", strconv.Itoa(dbConfig.ConnectAttempts)},
+		{"DB_CONNECT_BACKOFF", dbConfig.ConnectBackoff.String()},
+		{"DB_MAX_OPEN_CONNS", strconv.Itoa(dbConfig.MaxOpenConns)},
+		{"DB_MAX_IDLE_CONNS", strconv.Itoa(dbConfig.MaxIdleConns)},
+		{"DB_CONN_MAX_LIFETIME", dbConfig.ConnMaxLifetime.String()},
+		{"DB_QUERY_ATTEMPTS", strconv.Itoa(dbConfig.QueryAttempts)},
+		{"LOG_FORMAT", loggingConfig.Format},
+		{"LOG_LEVEL", loggingConfig.Level},
+		{"OTEL_TRACES_EXPORTER", tracingConfig.Exporter},
+		{"OTEL_SERVICE_NAME", tracingConfig.ServiceName},
+		{"STORAGE_DIR", storageConfig.Dir},
+		{"COVER_MAX_BYTES", strconv.FormatInt(storageConfig.MaxCoverBytes, 10)},
+		{"FINE_DAILY_RATE", strconv.FormatInt(fines.DailyRate, 10)},
+		{"FINE_GRACE_DAYS", strconv.Itoa(fines.GraceDays)},
+		{"FINE_MAX", strconv.FormatInt(fines.MaxFine, 10)},
+		{"FINE_BLOCK_THRESHOLD", strconv.FormatInt(fines.BlockThreshold, 10)},
+		{"WEBHOOK_POLL_INTERVAL", webhookConfig.PollInterval.String()},
+		{"WEBHOOK_TIMEOUT", webhookConfig.Timeout.String()},
+		{"WEBHOOK_MAX_ATTEMPTS", strconv.Itoa(webhookConfig.MaxAttempts)},
+		{"WEBHOOK_BATCH_SIZE", strconv.Itoa(webhookConfig.BatchSize)},
+		{"EVENTS_POLL_INTERVAL", eventsConfig.PollInterval.String()},
+		{"EVENTS_BUFFER", strconv.Itoa(eventsConfig.Buffer)},
+		{"EVENTS_HEARTBEAT", eventsConfig.Heartbeat.String()},
+		{"GRAPHQL_MAX_DEPTH", strconv.Itoa(graphqlConfig.MaxDepth)},
+		{"GRAPHQL_MAX_COMPLEXITY", strconv.Itoa(graphqlConfig.MaxComplexity)},
+		{"CACHE_SIZE", strconv.Itoa(cacheConfig.Size)},
+		{"CACHE_BOOK_TTL", cacheConfig.BookTTL.String()},
+		{"CACHE_ISBN_TTL", cacheConfig.ISBNTTL.String()},
+		{"CACHE_LIST_TTL", cacheConfig.ListTTL.String()},
+	})
+
+	if err := config.ValidateDatabaseConfig(dbConfig); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	if !*ping {
+		fmt.Fprintln(e.stdout, "configuration ok")
+		return nil
+	}
+
+	return withDatabase(func(db.Database) error {
+		if err := db.Ping(); err != nil {
+			return fmt.Errorf("ping database: %w", err)
+		}
+		fmt.Fprintln(e.stdout, "configuration ok, database reachable")
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/models"
+	"connection_to_pg/transfer"
+	"fmt"
+	"io"
+	"os"
+)
+
+func runImport(e *env, args []string) error {
+	fs := newFlagSet(e, "import")
+	format := fs.String("format", "", "input format: csv, ndjson or json (default: from file extension)")
+	columns := fs.String("map", "", `column mapping such as "title:name,writer:author"`)
+	keyFlag := fs.String("key", "", `natural key to upsert by, e.g. "name,author"`)
+	dryRun := fs.Bool("dry-run", false, "validate without writing")
+	abort := fs.Bool("abort", false, "stop at the first failing record")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("import: expected a file name or - for stdin")
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = transfer.FormatFromFilename(path)
+	}
+	if *format == "" {
+		*format = transfer.FormatJSON
+	}
+	mapping, err := transfer.ParseMapping(*columns)
+	if err != nil {
+		return usagef("import: %v", err)
+	}
+	key, err := transfer.ParseKey(*keyFlag)
+	if err != nil {
+		return usagef("import: %v", err)
+	}
+
+	var in io.Reader = e.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	decoder, err := transfer.NewDecoder(in, *format, mapping)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	return withDatabase(func(database db.Database) error {
+		report, err := transfer.Import(database, decoder, transfer.ImportOptions{Key: key, DryRun: *dryRun, AbortOnError: *abort})
+		if *output == "json" {
+			writeJSON(e.stdout, report)
+		} else {
+			printImportReport(e, report)
+		}
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		if report.Failed > 0 {
+			return fmt.Errorf("import: %d records failed", report.Failed)
+		}
+		return nil
+	})
+}
+
+func printImportReport(e *env, report transfer.ImportReport) {
+	for _, rowErr := range report.Errors {
+		if rowErr.Field != "" {
+			fmt.Fprintf(e.stderr, "row %d: %s: %s\n", rowErr.Row, rowErr.Field, rowErr.Error)
+		} else {
+			fmt.Fprintf(e.stderr, "row %d: %s\n", rowErr.Row, rowErr.Error)
+		}
+	}
+
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(e.stdout, "%s %d rows: %d created, %d updated, %d failed\n", verb, report.Processed, report.Created, report.Updated, report.Failed)
+	if report.Aborted {
+		fmt.Fprintln(e.stdout, "aborted at the first failing row")
+	}
+}
+
+func runExport(e *env, args []string) error {
+	fs := newFlagSet(e, "export")
+	format := fs.String("format", transfer.FormatJSON, "output format: csv, ndjson or json")
+	out := fs.String("out", "-", "file to write to, - for stdout")
+	author := fs.String("author", "", "only export books by this author")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var w io.Writer = e.stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder, err := transfer.NewEncoder(w, *format)
+	if err != nil {
+		return usagef("export: %v", err)
+	}
+
+	return withDatabase(func(database db.Database) error {
+		query := database.Model(&models.Book{})
+		if *author != "" {
+			query = query.Where("author = ?", *author)
+		}
+		count, err := transfer.Export(query, encoder, transfer.DefaultBatchSize, nil)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		if *out != "-" {
+			fmt.Fprintf(e.stderr, "exported %d books to %s\n", count, *out)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"connection_to_pg/auth"
+	"connection_to_pg/db"
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func runUsers(e *env, args []string) error {
+	if len(args) == 0 {
+		return usagef("users: expected a subcommand: list, create or delete")
+	}
+
+	switch args[0] {
+	case "list":
+		return runUsersList(e, args[1:])
+	case "create":
+		return runUsersCreate(e, args[1:])
+	case "delete":
+		return runUsersDelete(e, args[1:])
+	default:
+		return usagef("users: unknown subcommand %q", args[0])
+	}
+}
+
+func runUsersList(e *env, args []string) error {
+	fs := newFlagSet(e, "users list")
+	output := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		var users []models.User
+		if err := database.Find(&users).Error; err != nil {
+			return fmt.Errorf("list users: %w", err)
+		}
+		return printUsers(e, *output, users)
+	})
+}
+
+func runUsersCreate(e *env, args []string) error {
+	fs := newFlagSet(e, "users create")
+	output := outputFlag(fs)
+	username := fs.String("username", "", "unique user name (required)")
+	email := fs.String("email", "", "contact email")
+	role := fs.String("role", models.RoleUser, "role: user or admin")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *username == "" {
+		return usagef("users create: -username is required")
+	}
+	if *role != models.RoleUser && *role != models.RoleAdmin {
+		return usagef("users create: unknown role %q", *role)
+	}
+
+	return withDatabase(func(database db.Database) error {
+		user := models.User{Username: *username, Email: *email, Role: *role}
+		if err := database.Create(&user).Error; err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+		return printUsers(e, *output, []models.User{user})
+	})
+}
+
+func runUsersDelete(e *env, args []string) error {
+	fs := newFlagSet(e, "users delete")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := idArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		var user models.User
+		if err := database.First(&user, id).Error; err != nil {
+			return notFound(err, "user", id)
+		}
+		if err := database.Delete(&user).Error; err != nil {
+			return fmt.Errorf("delete user %d: %w", id, err)
+		}
+		fmt.Fprintf(e.stdout, "user %d deleted\n", id)
+		return nil
+	})
+}
+
+func printUsers(e *env, output string, users []models.User) error {
+	if output == "json" {
+		if users == nil {
+			users = []models.User{}
+		}
+		return writeJSON(e.stdout, users)
+	}
+
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, []string{strconv.Itoa(u.ID), u.Username, u.Email, u.Role, u.CreatedAt.Format(time.RFC3339)})
+	}
+	return writeTable(e.stdout, []string{"ID", "USERNAME", "EMAIL", "ROLE", "CREATED"}, rows)
+}
+
+func runKeys(e *env, args []string) error {
+	if len(args) == 0 {
+		return usagef("keys: expected a subcommand: list, create or revoke")
+	}
+
+	switch args[0] {
+	case "list":
+		return runKeysList(e, args[1:])
+	case "create":
+		return runKeysCreate(e, args[1:])
+	case "revoke":
+		return runKeysRevoke(e, args[1:])
+	default:
+		return usagef("keys: unknown subcommand %q", args[0])
+	}
+}
+
+func runKeysList(e *env, args []string) error {
+	fs := newFlagSet(e, "keys list")
+	output := outputFlag(fs)
+	userID := fs.Int("user", 0, "only list keys of this user ID")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		var keys []models.APIKey
+		var err error
+		if *userID != 0 {
+			err = database.Where("user_id = ?", *userID).Find(&keys).Error
+		} else {
+			err = database.Find(&keys).Error
+		}
+		if err != nil {
+			return fmt.Errorf("list keys: %w", err)
+		}
+
+		if *output == "json" {
+			if keys == nil {
+				keys = []models.APIKey{}
+			}
+			return writeJSON(e.stdout, keys)
+		}
+		rows := make([][]string, 0, len(keys))
+		for _, k := range keys {
+			status := "active"
+			if k.RevokedAt != nil {
+				status = "revoked " + k.RevokedAt.Format(time.RFC3339)
+			}
+			rows = append(rows, []string{strconv.Itoa(k.ID), strconv.Itoa(k.UserID), k.Name, k.Prefix, k.CreatedAt.Format(time.RFC3339), status})
+		}
+		return writeTable(e.stdout, []string{"ID", "USER", "NAME", "PREFIX", "CREATED", "STATUS"}, rows)
+	})
+}
+
+func runKeysCreate(e *env, args []string) error {
+	fs := newFlagSet(e, "keys create")
+	output := outputFlag(fs)
+	userID := fs.Int("user", 0, "ID of the user owning the key (required)")
+	name := fs.String("name", "", "label for the key")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return usagef("keys create: -user is required")
+	}
+
+	return withDatabase(func(database db.Database) error {
+		var user models.User
+		if err := database.First(&user, *userID).Error; err != nil {
+			return notFound(err, "user", *userID)
+		}
+
+		key, prefix, hash, err := auth.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("generate key: %w", err)
+		}
+		apiKey := models.APIKey{UserID: user.ID, Name: *name, Prefix: prefix, Hash: hash}
+		if err := database.Create(&apiKey).Error; err != nil {
+			return fmt.Errorf("create key: %w", err)
+		}
+
+		// The plain key is only ever shown here
+		if *output == "json" {
+			return writeJSON(e.stdout, map[string]interface{}{"id": apiKey.ID, "user_id": user.ID, "name": apiKey.Name, "key": key})
+		}
+		fmt.Fprintf(e.stdout, "key %d created for user %s\n%s\nstore it now, it cannot be shown again\n", apiKey.ID, user.Username, key)
+		return nil
+	})
+}
+
+func runKeysRevoke(e *env, args []string) error {
+	fs := newFlagSet(e, "keys revoke")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	id, err := idArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(func(database db.Database) error {
+		var key models.APIKey
+		if err := database.First(&key, id).Error; err != nil {
+			return notFound(err, "key", id)
+		}
+		if key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			if err := database.Save(&key).Error; err != nil {
+				return fmt.Errorf("revoke key %d: %w", id, err)
+			}
+		}
+		fmt.Fprintf(e.stdout, "key %d revoked\n", id)
+		return nil
+	})
+}
+
+// notFound maps gorm.ErrRecordNotFound onto errNotFound
+func notFound(err error, kind string, id int) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%s %d: %w", kind, id, errNotFound)
+	}
+	return fmt.Errorf("get %s %d: %w", kind, id, err)
+}
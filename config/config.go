@@ -2,16 +2,194 @@
 package config
 
 // DatabaseConfig holds the PostgreSQL credentials
-import "connection_to_pg/models"
+import (
+	"connection_to_pg/models"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
-// GetDatabaseConfig returns the database configuration
+// GetDatabaseConfig returns the database configuration. Every field can be
+// overridden with the matching DB_* environment variable.
 func GetDatabaseConfig() models.DatabaseConfig {
 	return models.DatabaseConfig{
-		User:     "postgres",
-		Password: "password",
-		DBName:   "gopractice",
-		SSLMode:  "disable",
-		Host:     "localhost",
-		Port:     "5432",
+		User:                 getEnv("DB_USER", "postgres"),
+		Password:             getEnv("DB_PASSWORD", "password"),
+		DBName:               getEnv("DB_NAME", "gopractice"),
+		SSLMode:              getEnv("DB_SSLMODE", "disable"),
+		Host:                 getEnv("DB_HOST", "localhost"),
+		Port:                 getEnv("DB_PORT", "5432"),
+		Replicas:             getEnvList("DB_REPLICAS"),
+		ReplicaCheckInterval: getEnvDuration("DB_REPLICA_CHECK_INTERVAL", 5*time.Second),
+		ReadYourWrites:       getEnvTTL("DB_READ_YOUR_WRITES", 0),
+		ConnectAttempts:      int(getEnvInt("DB_CONNECT_ATTEMPTS", 10)),
+		ConnectBackoff:       getEnvDuration("DB_CONNECT_BACKOFF", 500*time.Millisecond),
+		MaxOpenConns:         int(getEnvInt("DB_MAX_OPEN_CONNS", 25)),
+		MaxIdleConns:         int(getEnvInt("DB_MAX_IDLE_CONNS", 10)),
+		ConnMaxLifetime:      getEnvTTL("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		QueryAttempts:        int(getEnvInt("DB_QUERY_ATTEMPTS", 3)),
 	}
 }
+
+// GetServerConfig returns the HTTP and gRPC server configuration
+func GetServerConfig() models.ServerConfig {
+	return models.ServerConfig{
+		Addr:     getEnv("HTTP_ADDR", "localhost:8080"),
+		GRPCAddr: getEnv("GRPC_ADDR", ""),
+	}
+}
+
+// GetLoggingConfig returns the logging configuration
+func GetLoggingConfig() models.LoggingConfig {
+	return models.LoggingConfig{
+		Format: getEnv("LOG_FORMAT", "text"),
+		Level:  getEnv("LOG_LEVEL", "info"),
+	}
+}
+
+// GetTracingConfig returns the tracing configuration, read from the
+// standard OTEL_* environment variables
+func GetTracingConfig() models.TracingConfig {
+	ratio, err := strconv.ParseFloat(getEnv("OTEL_TRACES_SAMPLER_ARG", "1"), 64)
+	if err != nil {
+		ratio = 1
+	}
+	return models.TracingConfig{
+		Exporter:    getEnv("OTEL_TRACES_EXPORTER", "none"),
+		ServiceName: getEnv("OTEL_SERVICE_NAME", "books-service"),
+		File:        getEnv("OTEL_TRACES_FILE", "traces.jsonl"),
+		SampleRatio: ratio,
+	}
+}
+
+// GetStorageConfig returns the blob storage configuration
+func GetStorageConfig() models.StorageConfig {
+	maxBytes, err := strconv.ParseInt(getEnv("COVER_MAX_BYTES", "5242880"), 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = 5 << 20
+	}
+	return models.StorageConfig{
+		Dir:           getEnv("STORAGE_DIR", "data"),
+		MaxCoverBytes: maxBytes,
+	}
+}
+
+// GetFinePolicy returns the overdue fine policy. Amounts are in cents.
+func GetFinePolicy() models.FinePolicy {
+	return models.FinePolicy{
+		DailyRate:      getEnvInt("FINE_DAILY_RATE", 25),
+		GraceDays:      int(getEnvInt("FINE_GRACE_DAYS", 1)),
+		MaxFine:        getEnvInt("FINE_MAX", 1000),
+		BlockThreshold: getEnvInt("FINE_BLOCK_THRESHOLD", 500),
+	}
+}
+
+// GetWebhookConfig returns the webhook delivery configuration
+func GetWebhookConfig() models.WebhookConfig {
+	return models.WebhookConfig{
+		PollInterval: getEnvDuration("WEBHOOK_POLL_INTERVAL", 2*time.Second),
+		Timeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		MaxAttempts:  int(max(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8), 1)),
+		BatchSize:    int(max(getEnvInt("WEBHOOK_BATCH_SIZE", 100), 1)),
+	}
+}
+
+// GetEventsConfig returns the event stream configuration
+func GetEventsConfig() models.EventsConfig {
+	return models.EventsConfig{
+		PollInterval: getEnvDuration("EVENTS_POLL_INTERVAL", time.Second),
+		Buffer:       int(max(getEnvInt("EVENTS_BUFFER", 64), 1)),
+		Heartbeat:    getEnvDuration("EVENTS_HEARTBEAT", 15*time.Second),
+	}
+}
+
+// GetGraphQLConfig returns the limits on GraphQL queries
+func GetGraphQLConfig() models.GraphQLConfig {
+	return models.GraphQLConfig{
+		MaxDepth:      int(max(getEnvInt("GRAPHQL_MAX_DEPTH", 10), 1)),
+		MaxComplexity: int(max(getEnvInt("GRAPHQL_MAX_COMPLEXITY", 1000), 1)),
+	}
+}
+
+// GetCacheConfig returns the configuration of the book cache
+func GetCacheConfig() models.CacheConfig {
+	return models.CacheConfig{
+		Size:    int(max(getEnvInt("CACHE_SIZE", 10000), 0)),
+		BookTTL: getEnvTTL("CACHE_BOOK_TTL", time.Minute),
+		ISBNTTL: getEnvTTL("CACHE_ISBN_TTL", time.Minute),
+		ListTTL: getEnvTTL("CACHE_LIST_TTL", 0),
+	}
+}
+
+// ValidateDatabaseConfig reports every problem found in cfg
+func ValidateDatabaseConfig(cfg models.DatabaseConfig) error {
+	var errs []error
+	if cfg.Host == "" {
+		errs = append(errs, errors.New("DB_HOST must not be empty"))
+	}
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, errors.New("DB_PORT must be a number between 1 and 65535"))
+	}
+	if cfg.User == "" {
+		errs = append(errs, errors.New("DB_USER must not be empty"))
+	}
+	if cfg.DBName == "" {
+		errs = append(errs, errors.New("DB_NAME must not be empty"))
+	}
+	switch cfg.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, errors.New("DB_SSLMODE must be one of disable, allow, prefer, require, verify-ca, verify-full"))
+	}
+	return errors.Join(errs...)
+}
+
+// getEnvInt reads a non-negative integer, using fallback when the variable
+// is unset or invalid
+func getEnvInt(key string, fallback int64) int64 {
+	value, err := strconv.ParseInt(getEnv(key, ""), 10, 64)
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration reads a positive duration such as "2s", using fallback
+// when the variable is unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvTTL reads a duration like getEnvDuration, but accepts "0" for
+// disabling what the duration applies to
+func getEnvTTL(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList reads a comma-separated list, leaving out empty items
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
@@ -2,10 +2,20 @@ package db
 
 import (
 	"connection_to_pg/config"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
 	"connection_to_pg/models"
+	"connection_to_pg/requestid"
+	"connection_to_pg/tracing"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -18,6 +28,11 @@ type Database interface {
 	First(dest interface{}, conds ...interface{}) *gorm.DB
 	Save(value interface{}) *gorm.DB
 	Delete(value interface{}) *gorm.DB
+	Model(value interface{}) *gorm.DB
+	Transaction(fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error
+	// WithContext scopes the returned database to ctx, so queries are
+	// cancelled with the request and join its trace
+	WithContext(ctx context.Context) Database
 }
 
 // DatabaseImpl is a wrapper around *gorm.DB to implement Database interface
@@ -46,44 +61,193 @@ func (d *DatabaseImpl) Model(value interface{}) *gorm.DB {
 	return d.DB.Model(value)
 }
 func (d *DatabaseImpl) Delete(value interface{}) *gorm.DB {
-	return d.DB.Model(value)
+	return d.DB.Delete(value)
+}
+func (d *DatabaseImpl) Transaction(fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	return d.DB.Transaction(fc, opts...)
+}
+func (d *DatabaseImpl) WithContext(ctx context.Context) Database {
+	return &DatabaseImpl{DB: d.DB.WithContext(ctx)}
 }
 
 var gormDB *gorm.DB
 
+// replicas routes reads when read replicas are configured, and
+// stopReplicas ends their health checks
+var (
+	replicas     *Replicas
+	stopReplicas context.CancelFunc
+)
+
+// OpenDatabase connects to the configured database. It leaves the schema
+// as it finds it; Migrate brings it up to date.
 func OpenDatabase() error {
 	var err error
 	dbConfig := config.GetDatabaseConfig()
 
-	// Construct the PostgreSQL connection string
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+	sqlDB, err := openPool(DSN(dbConfig))
+	if err != nil {
+		return fmt.Errorf("connect to the database: %w", err)
+	}
+	gormDB, err = gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logging.GORMLogger{}, DisableAutomaticPing: true})
+	if err != nil {
+		sqlDB.Close()
+		return fmt.Errorf("connect to the database: %w", err)
+	}
+	configurePool(sqlDB, dbConfig)
+	if err = connect(context.Background(), sqlDB, dbConfig.ConnectAttempts, dbConfig.ConnectBackoff); err != nil {
+		sqlDB.Close()
+		return fmt.Errorf("connect to the database: %w", err)
+	}
+	if err = registerRetries(gormDB, dbConfig.QueryAttempts); err != nil {
+		return fmt.Errorf("retry database statements: %w", err)
+	}
+
+	if err = metrics.InstrumentGORM(gormDB); err != nil {
+		return fmt.Errorf("instrument database: %w", err)
+	}
+	if err = tracing.InstrumentGORM(gormDB); err != nil {
+		return fmt.Errorf("trace database: %w", err)
+	}
+	if err = requestid.InstrumentGORM(gormDB); err != nil {
+		return fmt.Errorf("tag database queries: %w", err)
+	}
+	notifier = NewNotifier(gormDB, DSN(dbConfig))
+	if err = registerChangeNotifications(gormDB); err != nil {
+		return fmt.Errorf("announce database changes: %w", err)
+	}
+
+	if len(dbConfig.Replicas) > 0 {
+		if replicas, err = OpenReplicas(dbConfig); err != nil {
+			return fmt.Errorf("open read replicas: %w", err)
+		}
+		if err = replicas.Register(gormDB); err != nil {
+			return fmt.Errorf("route reads to replicas: %w", err)
+		}
+		var ctx context.Context
+		ctx, stopReplicas = context.WithCancel(context.Background())
+		replicas.Check(ctx)
+		go replicas.Run(ctx, dbConfig.ReplicaCheckInterval)
+		slog.Info("read replicas configured", "replicas", len(dbConfig.Replicas))
+	}
+
+	slog.Info("database connected", "dsn", logging.RedactDSN(DSN(dbConfig)))
+	return nil
+}
+
+// openPool opens a connection pool to dsn. Its statements are described
+// and run unnamed rather than prepared and cached by their text: the
+// request ID comment requestid.InstrumentGORM adds makes the text of every
+// request's statements unique, so a statement cache would only miss and
+// fill up. That costs a round trip more per statement.
+func openPool(dsn string) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	return stdlib.OpenDB(*config), nil
+}
+
+// configurePool sizes pool as dbConfig sets
+func configurePool(pool *sql.DB, dbConfig models.DatabaseConfig) {
+	pool.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	pool.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	pool.SetConnMaxLifetime(dbConfig.ConnMaxLifetime)
+}
+
+// DSN constructs the PostgreSQL connection string
+func DSN(dbConfig models.DatabaseConfig) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.DBName, dbConfig.SSLMode)
+}
 
-	gormDB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Migrate brings the schema up to date with the models. It inspects the
+// schema on the primary, which the migration changes.
+func Migrate() error {
+	primary := gormDB.WithContext(WithPrimary(context.Background()))
+	err := primary.AutoMigrate(&models.Book{}, &models.User{}, &models.APIKey{}, &models.Author{}, &models.BookAuthor{},
+		&models.Category{}, &models.BookCategory{}, &models.Tag{}, &models.BookTag{}, &models.Cover{},
+		&models.Member{}, &models.Copy{}, &models.Loan{}, &models.Hold{}, &models.FineEntry{}, &models.Review{},
+		&models.Shelf{}, &models.ShelfEntry{}, &models.OutboxEvent{}, &models.WebhookSubscription{}, &models.WebhookDelivery{})
 	if err != nil {
-		log.Fatalf("failed to connect to the database: %v", err)
 		return err
 	}
+	if err := primary.Transaction(migrateLoanCopies); err != nil {
+		return err
+	}
+	return primary.Transaction(migrateBookAuthors)
+}
 
-	// Automatically migrate the Book model
-	err = gormDB.AutoMigrate(&models.Book{})
-	if err != nil {
-		log.Fatalf("failed to migrate database schema: %v", err)
+// migrateLoanCopies replaces the cascading foreign key from loans to copies
+// that earlier schemas created with the restricting one of models.Loan.
+// AutoMigrate only adds missing constraints, it doesn't change them.
+func migrateLoanCopies(tx *gorm.DB) error {
+	var cascading int64
+	err := tx.Raw(`SELECT count(*) FROM information_schema.referential_constraints
+		WHERE constraint_schema = current_schema() AND constraint_name = 'fk_loans_copy' AND delete_rule = 'CASCADE'`).
+		Scan(&cascading).Error
+	if err != nil || cascading == 0 {
 		return err
 	}
+	if err := tx.Migrator().DropConstraint(&models.Loan{}, "Copy"); err != nil {
+		return err
+	}
+	return tx.Migrator().CreateConstraint(&models.Loan{}, "Copy")
+}
 
-	return nil
+// migrateBookAuthors credits the authors the free-text author of every book
+// without credits lists, which covers books written before authors were
+// introduced
+func migrateBookAuthors(tx *gorm.DB) error {
+	var books []models.Book
+	return tx.Where("author <> '' AND NOT EXISTS (SELECT 1 FROM book_authors WHERE book_authors.book_id = books.id)").
+		FindInBatches(&books, 500, func(batch *gorm.DB, _ int) error {
+			for _, book := range books {
+				if err := models.CreditAuthors(tx, book.ID, book.Author); err != nil {
+					return fmt.Errorf("credit authors of book %d: %w", book.ID, err)
+				}
+			}
+			return nil
+		}).Error
 }
 
-func CloseDatabase() error {
+// Ping checks that the database connection is alive
+func Ping() error {
 	sqlDB, err := gormDB.DB()
 	if err != nil {
 		return err
 	}
-	return sqlDB.Close()
+	return sqlDB.Ping()
+}
+
+func CloseDatabase() error {
+	var replicasErr error
+	if replicas != nil {
+		stopReplicas()
+		replicasErr = replicas.Close()
+	}
+	sqlDB, err := gormDB.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	return errors.Join(err, replicasErr)
 }
 
 // GetDB returns the wrapped database instance
 func GetDB() Database {
 	return &DatabaseImpl{DB: gormDB} // ✅ Returns the wrapped struct instead of raw *gorm.DB
 }
+
+// IsUniqueViolation reports whether err was caused by a unique constraint
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.Is(err, gorm.ErrDuplicatedKey) || (errors.As(err, &pgErr) && pgErr.Code == "23505")
+}
+
+// IsForeignKeyViolation reports whether err was caused by a foreign key,
+// such as one restricting the deletion of rows still referenced
+func IsForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.Is(err, gorm.ErrForeignKeyViolated) || (errors.As(err, &pgErr) && pgErr.Code == "23503")
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// legacyBooks is a database holding books written before authors were
+// introduced, with the free-text authors given, answering new authors with
+// IDs from 1 and recording their names and the credits made
+type legacyBooks struct {
+	authors []string
+	created []string
+	credits [][]driver.NamedValue
+}
+
+func (c *legacyBooks) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *legacyBooks) Driver() driver.Driver                        { return nil }
+func (c *legacyBooks) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *legacyBooks) Close() error                                 { return nil }
+func (c *legacyBooks) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c *legacyBooks) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if strings.HasPrefix(query, `INSERT INTO "book_authors"`) {
+		c.credits = append(c.credits, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *legacyBooks) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := &legacyRows{}
+	switch {
+	case strings.HasPrefix(query, `SELECT * FROM "books"`):
+		rows.columns = []string{"id", "name", "author"}
+		for i, author := range c.authors {
+			rows.rows = append(rows.rows, []driver.Value{int64(i + 1), "Book", author})
+		}
+	case strings.HasPrefix(query, "SELECT count(*)"):
+		rows.columns, rows.rows = []string{"count"}, [][]driver.Value{{int64(0)}}
+	case strings.HasPrefix(query, `INSERT INTO "authors"`):
+		c.created = append(c.created, args[0].Value.(string))
+		rows.columns, rows.rows = []string{"id"}, [][]driver.Value{{int64(len(c.created))}}
+	}
+	return rows, nil
+}
+
+type legacyRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *legacyRows) Columns() []string { return r.columns }
+func (r *legacyRows) Close() error      { return nil }
+
+func (r *legacyRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestMigrateBookAuthors_SplitsAuthors(t *testing.T) {
+	conn := &legacyBooks{authors: []string{"A. Smith, B. Jones"}}
+	database, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, migrateBookAuthors(database))
+	assert.Equal(t, []string{"A. Smith", "B. Jones"}, conn.created)
+	require.Len(t, conn.credits, 2)
+	// The credits are book_id, author_id, role and position
+	for i, credit := range conn.credits {
+		require.Len(t, credit, 4)
+		assert.Equal(t, int64(1), credit[0].Value)
+		assert.Equal(t, int64(i+1), credit[1].Value)
+		assert.Equal(t, int64(i), credit[3].Value)
+	}
+}
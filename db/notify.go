@@ -0,0 +1,354 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// BooksChannel is the notification channel of changes to books
+const BooksChannel = "books_changed"
+
+// notifyTables maps the tables whose changes are announced to their
+// channel
+var notifyTables = map[string]string{"books": BooksChannel}
+
+// Change is the payload of a change notification. ID is nil when the
+// statement didn't name a single row, such as a bulk update, and
+// subscribers should assume any row changed.
+type Change struct {
+	Table string `json:"table"`
+	Op    string `json:"op"`
+	ID    any    `json:"id,omitempty"`
+}
+
+// Notification is a message received on a channel. Resync is set instead
+// of a payload when notifications may have been lost, after the listener
+// reconnected; subscribers holding derived state should rebuild it.
+type Notification struct {
+	Channel string
+	Payload string
+	Resync  bool
+}
+
+// Change decodes the payload of a change notification
+func (n Notification) Change() (Change, error) {
+	var change Change
+	err := json.Unmarshal([]byte(n.Payload), &change)
+	return change, err
+}
+
+// Notifier announces changes to every instance of the service and delivers
+// the announcements to subscribers in this one
+type Notifier interface {
+	// Notify announces payload on channel as part of tx: when tx is a
+	// transaction, Postgres delivers it only if the transaction commits
+	Notify(tx *gorm.DB, channel, payload string) error
+	// Subscribe returns the notifications received on channel until
+	// cancel is called
+	Subscribe(channel string) (notifications <-chan Notification, cancel func())
+	// Listen receives notifications until ctx is cancelled
+	Listen(ctx context.Context) error
+}
+
+// subscriberBuffer is the number of notifications a subscriber may fall
+// behind before further ones are dropped for it
+const subscriberBuffer = 256
+
+// LocalNotifier delivers notifications within the process. It serves
+// backends without LISTEN/NOTIFY, where other instances can't be reached,
+// and fans out what the Postgres listener receives.
+type LocalNotifier struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Notification]struct{}
+}
+
+// NewLocalNotifier returns a notifier delivering in-process only
+func NewLocalNotifier() *LocalNotifier {
+	return &LocalNotifier{subs: map[string]map[chan Notification]struct{}{}}
+}
+
+// Notify delivers payload right away, whether or not tx commits
+func (n *LocalNotifier) Notify(_ *gorm.DB, channel, payload string) error {
+	n.deliver(Notification{Channel: channel, Payload: payload})
+	return nil
+}
+
+// Subscribe returns the notifications delivered on channel
+func (n *LocalNotifier) Subscribe(channel string) (<-chan Notification, func()) {
+	ch := make(chan Notification, subscriberBuffer)
+	n.mu.Lock()
+	if n.subs[channel] == nil {
+		n.subs[channel] = map[chan Notification]struct{}{}
+	}
+	n.subs[channel][ch] = struct{}{}
+	n.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			delete(n.subs[channel], ch)
+			n.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Listen has nothing to receive; it waits for ctx
+func (n *LocalNotifier) Listen(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// channels returns the channels that have subscribers
+func (n *LocalNotifier) channels() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	channels := make([]string, 0, len(n.subs))
+	for channel, subs := range n.subs {
+		if len(subs) > 0 {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// deliver hands a notification to the subscribers of its channel without
+// blocking; a subscriber whose buffer is full misses it
+func (n *LocalNotifier) deliver(notification Notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.subs[notification.Channel] {
+		select {
+		case ch <- notification:
+		default:
+			slog.Warn("notification subscriber is behind, dropping notification", "channel", notification.Channel)
+		}
+	}
+}
+
+// PGNotifier announces changes with NOTIFY and receives them with LISTEN
+// on a dedicated connection, so every instance sharing the database sees
+// them
+type PGNotifier struct {
+	*LocalNotifier
+	dsn string
+	// wake interrupts the listener when a channel is subscribed to
+	wake chan struct{}
+}
+
+// NewPGNotifier returns a notifier listening on its own connection to dsn
+func NewPGNotifier(dsn string) *PGNotifier {
+	return &PGNotifier{LocalNotifier: NewLocalNotifier(), dsn: dsn, wake: make(chan struct{}, 1)}
+}
+
+// Notify issues NOTIFY through tx
+func (n *PGNotifier) Notify(tx *gorm.DB, channel, payload string) error {
+	return tx.Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+}
+
+// Subscribe returns the notifications received on channel, listening to
+// it from now on
+func (n *PGNotifier) Subscribe(channel string) (<-chan Notification, func()) {
+	ch, cancel := n.LocalNotifier.Subscribe(channel)
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return ch, cancel
+}
+
+// Listener reconnect backoff bounds
+const (
+	minReconnect = 100 * time.Millisecond
+	maxReconnect = 30 * time.Second
+)
+
+// Listen receives notifications until ctx is cancelled. A lost connection
+// is re-established with exponential backoff; subscribers then get a
+// Resync notification because anything sent in between is gone.
+func (n *PGNotifier) Listen(ctx context.Context) error {
+	backoff := minReconnect
+	connected := false
+	for {
+		err := n.listen(ctx, func() {
+			if connected {
+				for _, channel := range n.channels() {
+					n.deliver(Notification{Channel: channel, Resync: true})
+				}
+			}
+			connected = true
+			backoff = minReconnect
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		wait := backoff/2 + rand.N(backoff/2+1)
+		slog.Warn("notification listener disconnected, reconnecting", "error", err, "retry_in", wait)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, maxReconnect)
+	}
+}
+
+// listen runs one listener connection until it fails. ready is called
+// once the connection listens to every subscribed channel.
+func (n *PGNotifier) listen(ctx context.Context, ready func()) error {
+	conn, err := pgx.Connect(ctx, n.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	listening := map[string]bool{}
+	listenAll := func() error {
+		for _, channel := range n.channels() {
+			if listening[channel] {
+				continue
+			}
+			if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return err
+			}
+			listening[channel] = true
+		}
+		return nil
+	}
+	if err := listenAll(); err != nil {
+		return err
+	}
+	ready()
+
+	for {
+		waitCtx, stop := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-n.wake:
+				stop()
+			case <-waitCtx.Done():
+			}
+		}()
+		notification, err := conn.WaitForNotification(waitCtx)
+		woken := waitCtx.Err() != nil && ctx.Err() == nil
+		stop()
+		switch {
+		case err == nil:
+			n.deliver(Notification{Channel: notification.Channel, Payload: notification.Payload})
+		case woken:
+			// A cancelled wait leaves the connection usable
+			if err := listenAll(); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// NewNotifier returns the notifier for database: NOTIFY based on
+// Postgres, in-process otherwise
+func NewNotifier(database *gorm.DB, dsn string) Notifier {
+	if database.Dialector.Name() == "postgres" {
+		return NewPGNotifier(dsn)
+	}
+	return NewLocalNotifier()
+}
+
+// notifier announces the changes made through the package database
+var notifier Notifier = NewLocalNotifier()
+
+// Notifications returns the notifier of the package database, to
+// subscribe to changes made by any instance
+func Notifications() Notifier {
+	return notifier
+}
+
+// NotifyChange announces a change that GORM can't see, such as one made
+// with raw SQL, as part of tx
+func NotifyChange(tx *gorm.DB, table, op string, id any) error {
+	channel, ok := notifyTables[table]
+	if !ok {
+		return errors.New("no notification channel for table " + table)
+	}
+	payload, err := json.Marshal(Change{Table: table, Op: op, ID: id})
+	if err != nil {
+		return err
+	}
+	return notifier.Notify(tx.Session(&gorm.Session{NewDB: true}), channel, string(payload))
+}
+
+// registerChangeNotifications announces every create, update and delete
+// of a notified table in the statement's own transaction
+func registerChangeNotifications(database *gorm.DB) error {
+	cb := database.Callback()
+	return errors.Join(
+		cb.Create().After("gorm:create").Register("notify:after_create", notifyChanges("create")),
+		cb.Update().After("gorm:update").Register("notify:after_update", notifyChanges("update")),
+		cb.Delete().After("gorm:delete").Register("notify:after_delete", notifyChanges("delete")),
+	)
+}
+
+// notifyChanges returns the callback announcing the rows an operation
+// changed
+func notifyChanges(op string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if tx.Error != nil || tx.RowsAffected == 0 || tx.Statement.Schema == nil {
+			return
+		}
+		table := tx.Statement.Schema.Table
+		if _, ok := notifyTables[table]; !ok {
+			return
+		}
+		ids := changedIDs(tx)
+		if len(ids) == 0 {
+			ids = []any{nil}
+		}
+		for _, id := range ids {
+			if err := NotifyChange(tx, table, op, id); err != nil {
+				tx.AddError(err)
+				return
+			}
+		}
+	}
+}
+
+// changedIDs returns the primary keys of the models a statement wrote
+func changedIDs(tx *gorm.DB) []any {
+	field := tx.Statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return nil
+	}
+	var ids []any
+	add := func(rv reflect.Value) {
+		for rv.Kind() == reflect.Pointer {
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		if id, zero := field.ValueOf(tx.Statement.Context, rv); !zero {
+			ids = append(ids, id)
+		}
+	}
+	rv := tx.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(rv.Index(i))
+		}
+	default:
+		add(rv)
+	}
+	return ids
+}
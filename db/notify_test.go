@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalNotifier(t *testing.T) {
+	n := NewLocalNotifier()
+	books, cancelBooks := n.Subscribe(BooksChannel)
+	other, cancelOther := n.Subscribe("other")
+	defer cancelOther()
+
+	require.NoError(t, n.Notify(nil, BooksChannel, `{"table":"books","op":"update","id":7}`))
+
+	notification := <-books
+	assert.Equal(t, BooksChannel, notification.Channel)
+	change, err := notification.Change()
+	require.NoError(t, err)
+	assert.Equal(t, Change{Table: "books", Op: "update", ID: float64(7)}, change)
+	assert.Empty(t, other)
+	assert.ElementsMatch(t, []string{BooksChannel, "other"}, n.channels())
+
+	cancelBooks()
+	cancelBooks()
+	_, open := <-books
+	assert.False(t, open)
+	assert.Equal(t, []string{"other"}, n.channels())
+	require.NoError(t, n.Notify(nil, BooksChannel, "{}"))
+}
+
+func TestLocalNotifier_SlowSubscriber(t *testing.T) {
+	n := NewLocalNotifier()
+	ch, cancel := n.Subscribe(BooksChannel)
+	defer cancel()
+
+	for range subscriberBuffer + 1 {
+		require.NoError(t, n.Notify(nil, BooksChannel, "{}"))
+	}
+	assert.Len(t, ch, subscriberBuffer)
+}
+
+func TestLocalNotifier_Listen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, NewLocalNotifier().Listen(ctx))
+}
+
+func TestPGNotifier_Reconnects(t *testing.T) {
+	n := NewPGNotifier("host=127.0.0.1 port=1 connect_timeout=1")
+	_, cancelSub := n.Subscribe(BooksChannel)
+	defer cancelSub()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- n.Listen(ctx) }()
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestNotifyChange_UnknownTable(t *testing.T) {
+	assert.Error(t, NotifyChange(nil, "authors", "update", 1))
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"connection_to_pg/models"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// replicaPingTimeout bounds a health check of one replica
+const replicaPingTimeout = 2 * time.Second
+
+type primaryKey struct{}
+
+type clientKey struct{}
+
+// WithPrimary returns a copy of ctx whose reads go to the primary, for
+// callers that can't tolerate replication lag
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// WithClient returns a copy of ctx naming the client it serves. With
+// read-your-writes enabled, the reads of a client that just wrote go to
+// the primary.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// replicaPool is the connection pool of a replica, *sql.DB outside tests
+type replicaPool interface {
+	gorm.ConnPool
+	PingContext(ctx context.Context) error
+	Close() error
+}
+
+type replica struct {
+	// name is the host and port of the replica, which labels its logs
+	// and metrics without giving its credentials away
+	name    string
+	pool    replicaPool
+	healthy atomic.Bool
+}
+
+// Replicas routes the reads of a database to its read replicas. Reads go
+// to the healthy replicas in turn; writes, reads in transactions, locking
+// reads and everything else go to the primary, as do all reads when no
+// replica is healthy. Run takes replicas that stop answering out of the
+// rotation until they answer again.
+type Replicas struct {
+	replicas []*replica
+	next     atomic.Uint64
+	// window is how long a client's reads stay on the primary after it
+	// wrote, zero disabling read-your-writes
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	pinned map[string]time.Time
+}
+
+// newReplicas returns replicas over pools, all of them considered healthy
+// until checked
+func newReplicas(names []string, pools []replicaPool, window time.Duration) *Replicas {
+	r := &Replicas{window: window, now: time.Now, pinned: map[string]time.Time{}}
+	for i, pool := range pools {
+		rep := &replica{name: names[i], pool: pool}
+		rep.healthy.Store(true)
+		r.replicas = append(r.replicas, rep)
+	}
+	return r
+}
+
+// OpenReplicas opens a pool for each replica of dbConfig, sized like the
+// pool of the primary. Connections are made on demand, so replicas that are
+// down don't fail the start; Check finds them.
+func OpenReplicas(dbConfig models.DatabaseConfig) (*Replicas, error) {
+	var names []string
+	var pools []replicaPool
+	for _, dsn := range dbConfig.Replicas {
+		config, err := pgconn.ParseConfig(dsn)
+		if err != nil {
+			closePools(pools)
+			return nil, fmt.Errorf("replica %s: %w", logging.RedactDSN(dsn), err)
+		}
+		pool, err := openPool(dsn)
+		if err != nil {
+			closePools(pools)
+			return nil, fmt.Errorf("replica %s: %w", logging.RedactDSN(dsn), err)
+		}
+		configurePool(pool, dbConfig)
+		names = append(names, net.JoinHostPort(config.Host, fmt.Sprint(config.Port)))
+		pools = append(pools, pool)
+	}
+	return newReplicas(names, pools, dbConfig.ReadYourWrites), nil
+}
+
+func closePools(pools []replicaPool) {
+	for _, pool := range pools {
+		pool.Close()
+	}
+}
+
+// Register routes the statements of database through the replicas
+func (r *Replicas) Register(database *gorm.DB) error {
+	cb := database.Callback()
+	return errors.Join(
+		cb.Query().Before("gorm:query").Register("replicas:query", r.routeRead),
+		cb.Row().Before("gorm:row").Register("replicas:row", r.routeRead),
+		cb.Create().After("gorm:create").Register("replicas:after_create", r.recordWrite),
+		cb.Update().After("gorm:update").Register("replicas:after_update", r.recordWrite),
+		cb.Delete().After("gorm:delete").Register("replicas:after_delete", r.recordWrite),
+		cb.Raw().After("gorm:raw").Register("replicas:after_raw", r.recordWrite),
+	)
+}
+
+// routeRead sends a read to a replica when it may go to one
+func (r *Replicas) routeRead(tx *gorm.DB) {
+	if tx.Error != nil || inTransaction(tx) || !reads(tx) {
+		return
+	}
+	if _, locking := tx.Statement.Clauses["FOR"]; locking {
+		return
+	}
+	if pool := r.pick(tx.Statement.Context); pool != nil {
+		tx.Statement.ConnPool = pool
+	}
+}
+
+// inTransaction reports whether the statement of tx runs in a transaction
+func inTransaction(tx *gorm.DB) bool {
+	_, ok := tx.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// reads reports whether the statement of a query or row callback only
+// reads. Raw SQL is only known to read when it is a SELECT, leading
+// comments such as the request ID aside.
+func reads(tx *gorm.DB) bool {
+	raw := strings.TrimSpace(tx.Statement.SQL.String())
+	for strings.HasPrefix(raw, "/*") {
+		end := strings.Index(raw, "*/")
+		if end < 0 {
+			return false
+		}
+		raw = strings.TrimSpace(raw[end+2:])
+	}
+	return raw == "" || strings.HasPrefix(strings.ToUpper(raw), "SELECT")
+}
+
+// recordWrite pins the client of a successful write to the primary
+func (r *Replicas) recordWrite(tx *gorm.DB) {
+	if tx.Error != nil || r.window <= 0 {
+		return
+	}
+	if client, ok := tx.Statement.Context.Value(clientKey{}).(string); ok && client != "" {
+		r.mu.Lock()
+		r.pinned[client] = r.now().Add(r.window)
+		r.mu.Unlock()
+	}
+}
+
+// pick returns the pool the next read of ctx goes to, nil for the primary
+func (r *Replicas) pick(ctx context.Context) gorm.ConnPool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(primaryKey{}) != nil || r.isPinned(ctx) {
+		return nil
+	}
+	// Rotating over the healthy replicas only spreads the reads of one
+	// that is down evenly over the others
+	var healthy []*replica
+	for _, rep := range r.replicas {
+		if rep.healthy.Load() {
+			healthy = append(healthy, rep)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[r.next.Add(1)%uint64(len(healthy))].pool
+}
+
+// isPinned reports whether the client of ctx wrote within the window
+func (r *Replicas) isPinned(ctx context.Context) bool {
+	client, ok := ctx.Value(clientKey{}).(string)
+	if !ok || r.window <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until, ok := r.pinned[client]
+	return ok && r.now().Before(until)
+}
+
+// Check pings every replica, taking those that fail out of the rotation
+// and putting those that answer back, and forgets expired pins
+func (r *Replicas) Check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+			defer cancel()
+			err := rep.pool.PingContext(pingCtx)
+			if healthy := err == nil; rep.healthy.Swap(healthy) != healthy {
+				if healthy {
+					slog.Info("replica is back in rotation", "replica", rep.name)
+				} else {
+					slog.Warn("replica taken out of rotation", "replica", rep.name, "error", err)
+				}
+			}
+			up := 0.0
+			if err == nil {
+				up = 1
+			}
+			metrics.DBReplicaUp.WithLabelValues(rep.name).Set(up)
+		}()
+	}
+	wg.Wait()
+
+	now := r.now()
+	r.mu.Lock()
+	for client, until := range r.pinned {
+		if !now.Before(until) {
+			delete(r.pinned, client)
+		}
+	}
+	r.mu.Unlock()
+}
+
+// Run checks the replicas every interval until ctx is cancelled
+func (r *Replicas) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx)
+		}
+	}
+}
+
+// Close closes the pools of the replicas
+func (r *Replicas) Close() error {
+	var errs []error
+	for _, rep := range r.replicas {
+		errs = append(errs, rep.pool.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"connection_to_pg/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// fakePool stands in for the pool of a replica. Statements are only built
+// in dry run mode, never sent, so only pings are implemented.
+type fakePool struct {
+	gorm.ConnPool
+	pingErr error
+}
+
+func (p *fakePool) PingContext(context.Context) error { return p.pingErr }
+
+func (p *fakePool) Close() error { return nil }
+
+// fakeTx stands in for the connection of a transaction
+type fakeTx struct {
+	gorm.ConnPool
+}
+
+func (fakeTx) Commit() error { return nil }
+
+func (fakeTx) Rollback() error { return nil }
+
+type book struct {
+	ID   int
+	Name string
+}
+
+func newTestReplicas(window time.Duration, pools ...*fakePool) *Replicas {
+	names := make([]string, len(pools))
+	replicaPools := make([]replicaPool, len(pools))
+	for i, pool := range pools {
+		names[i] = "replica" + string(rune('a'+i))
+		replicaPools[i] = pool
+	}
+	return newReplicas(names, replicaPools, window)
+}
+
+func TestReplicas_PickSkipsUnhealthy(t *testing.T) {
+	a, b, c := &fakePool{}, &fakePool{pingErr: errors.New("connection refused")}, &fakePool{}
+	r := newTestReplicas(0, a, b, c)
+	r.Check(context.Background())
+
+	seen := map[gorm.ConnPool]int{}
+	for range 4 {
+		seen[r.pick(context.Background())]++
+	}
+	assert.Equal(t, map[gorm.ConnPool]int{a: 2, c: 2}, seen)
+	assert.Nil(t, r.pick(WithPrimary(context.Background())))
+
+	a.pingErr, c.pingErr = b.pingErr, b.pingErr
+	r.Check(context.Background())
+	assert.Nil(t, r.pick(context.Background()))
+
+	b.pingErr = nil
+	r.Check(context.Background())
+	assert.Equal(t, b, r.pick(context.Background()))
+}
+
+func TestReplicas_Routing(t *testing.T) {
+	database, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	primary := database.Statement.ConnPool
+	pool := &fakePool{}
+	r := newTestReplicas(time.Minute, pool)
+	require.NoError(t, r.Register(database))
+
+	var found book
+	assert.Equal(t, pool, database.First(&found, 7).Statement.ConnPool)
+	assert.Equal(t, pool, database.Raw("SELECT 1").Scan(&found).Statement.ConnPool)
+	assert.Equal(t, primary, database.Raw("UPDATE books SET name = 'x' RETURNING *").Scan(&found).Statement.ConnPool)
+	assert.Equal(t, pool, database.Raw("/* request_id='req-1' */ SELECT 1").Scan(&found).Statement.ConnPool)
+	assert.Equal(t, primary, database.Raw("/* request_id='req-1' */ UPDATE books SET name = 'x' RETURNING *").Scan(&found).Statement.ConnPool)
+	assert.Equal(t, primary, database.Raw("/* unterminated SELECT 1").Scan(&found).Statement.ConnPool)
+	assert.Equal(t, primary, database.Clauses(clause.Locking{Strength: "UPDATE"}).First(&found, 7).Statement.ConnPool)
+	assert.Equal(t, primary, database.Create(&book{Name: "Dune"}).Statement.ConnPool)
+
+	tx := database.Session(&gorm.Session{})
+	tx.Statement.ConnPool = fakeTx{}
+	assert.Equal(t, fakeTx{}, tx.First(&found, 7).Statement.ConnPool)
+}
+
+func TestReplicas_ReadYourWrites(t *testing.T) {
+	database, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	primary := database.Statement.ConnPool
+	pool := &fakePool{}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := newTestReplicas(5*time.Second, pool)
+	r.now = func() time.Time { return now }
+	require.NoError(t, r.Register(database))
+
+	alice := WithClient(context.Background(), "user:1")
+	bob := WithClient(context.Background(), "user:2")
+	var found book
+	database.WithContext(alice).Create(&book{Name: "Dune"})
+	assert.Equal(t, primary, database.WithContext(alice).First(&found, 7).Statement.ConnPool)
+	assert.Equal(t, pool, database.WithContext(bob).First(&found, 7).Statement.ConnPool)
+
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, pool, database.WithContext(alice).First(&found, 7).Statement.ConnPool)
+	r.Check(context.Background())
+	assert.Empty(t, r.pinned)
+}
+
+func TestOpenReplicas_RejectsInvalidDSN(t *testing.T) {
+	_, err := OpenReplicas(models.DatabaseConfig{Replicas: []string{"host=replica1", "postgres://user:secret@[::1"}})
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+
+	r, err := OpenReplicas(models.DatabaseConfig{Replicas: []string{"host=replica1 port=5433 password=secret"}})
+	require.NoError(t, err)
+	assert.Equal(t, "replica1:5433", r.replicas[0].name)
+	assert.NoError(t, r.Close())
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	// connectTimeout bounds one connection attempt at start, and
+	// maxConnectBackoff the wait between two of them
+	connectTimeout    = 5 * time.Second
+	maxConnectBackoff = 30 * time.Second
+	// queryBackoff and maxQueryBackoff are kept short, as a request is
+	// waiting on the statement being retried
+	queryBackoff    = 50 * time.Millisecond
+	maxQueryBackoff = time.Second
+)
+
+// backoff returns the wait before retry attempt, the first retry being 1:
+// initial doubled for every retry before it, capped at limit. The wait is
+// jittered over its upper half, so that instances which failed together
+// don't retry in lockstep.
+func backoff(initial, limit time.Duration, attempt int) time.Duration {
+	wait := initial
+	for i := 1; i < attempt && wait < limit; i++ {
+		wait *= 2
+	}
+	wait = min(wait, limit)
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// sleep waits for d, returning early with the error of ctx when it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// transient reports whether err may go away when the statement is tried
+// again: serialization failures and deadlocks, which include queries on a
+// replica cancelled by a conflict with recovery, and lost or refused
+// connections
+func transient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", "40P01": // serialization_failure, deadlock_detected
+			return true
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+		// Class 08 is connection_exception
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	return pgconn.SafeToRetry(err) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// refused reports whether the server turned the connection down for its
+// credentials, which trying again won't change
+func refused(err error) bool {
+	var pgErr *pgconn.PgError
+	// Class 28 is invalid_authorization_specification
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28")
+}
+
+// pinger is a connection pool that can be checked, *sql.DB outside tests
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// connect waits for pool to accept a connection, trying up to attempts
+// times with a growing backoff from initial in between, so the service can
+// start alongside the database. Refused credentials fail at once.
+func connect(ctx context.Context, pool pinger, attempts int, initial time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		err := pool.PingContext(pingCtx)
+		cancel()
+		if err == nil || attempt >= attempts || refused(err) {
+			return err
+		}
+		wait := backoff(initial, maxConnectBackoff, attempt)
+		slog.Warn("database not reachable yet, retrying", "attempt", attempt, "attempts", attempts, "wait", wait, "error", err)
+		metrics.DBRetries.WithLabelValues("connect").Inc()
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// rolledBack reports whether a transaction that failed with err was
+// rolled back as a whole and can run again: the server aborted it for a
+// serialization failure or a deadlock, or it failed before anything was
+// sent. A connection lost later, such as during the commit, leaves open
+// whether it was applied, so it isn't run again.
+func rolledBack(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	return err != nil && pgconn.SafeToRetry(err)
+}
+
+// retrier retries idempotent statements that fail with a transient error
+type retrier struct {
+	attempts int
+	initial  time.Duration
+	limit    time.Duration
+}
+
+// transactions retries the transactions run through Transaction, with the
+// attempts registerRetries was given
+var transactions = retrier{initial: queryBackoff, limit: maxQueryBackoff}
+
+// registerRetries makes database try reads outside transactions up to
+// attempts times, and Transaction run transactions that many times. Writes
+// outside transactions aren't retried, as a write that failed may have
+// been applied, and neither are statements in transactions, which a
+// transient error aborts as a whole.
+func registerRetries(database *gorm.DB, attempts int) error {
+	transactions.attempts = attempts
+	return retrier{attempts: attempts, initial: queryBackoff, limit: maxQueryBackoff}.register(database)
+}
+
+// Transaction runs fn in a transaction of database, and again in a new one
+// while the transaction is rolled back by a serialization failure or a
+// deadlock and attempts are left. fn must be safe to run again: whatever it
+// sets outside the transaction has to be set afresh on every run.
+func Transaction(ctx context.Context, database Database, fn func(tx *gorm.DB) error) error {
+	return transactions.transaction(ctx, database, fn)
+}
+
+func (r retrier) transaction(ctx context.Context, database Database, fn func(tx *gorm.DB) error) error {
+	for attempt := 1; ; attempt++ {
+		err := database.WithContext(ctx).Transaction(fn)
+		if attempt >= r.attempts || !rolledBack(err) {
+			return err
+		}
+		if sleep(ctx, backoff(r.initial, r.limit, attempt)) != nil {
+			return err
+		}
+		metrics.DBRetries.WithLabelValues("transaction").Inc()
+		logging.FromContext(ctx).Debug("retrying transaction", "attempt", attempt+1, "error", err)
+	}
+}
+
+func (r retrier) register(database *gorm.DB) error {
+	if r.attempts <= 1 {
+		return nil
+	}
+	cb := database.Callback()
+	return errors.Join(
+		cb.Query().Replace("gorm:query", r.wrap("query", cb.Query().Get("gorm:query"))),
+		cb.Row().Replace("gorm:row", r.wrap("row", cb.Row().Get("gorm:row"))),
+	)
+}
+
+// wrap returns statement, run again while it fails with a transient error
+// and attempts are left
+func (r retrier) wrap(operation string, statement func(*gorm.DB)) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if tx.Error != nil || inTransaction(tx) || !reads(tx) {
+			statement(tx)
+			return
+		}
+		ctx := tx.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		// The row callback consumes the setting asking for *sql.Rows
+		// rather than a *sql.Row
+		rows, wantsRows := tx.Get("rows")
+		for attempt := 1; ; attempt++ {
+			statement(tx)
+			err := statementError(tx)
+			if attempt >= r.attempts || !transient(err) {
+				return
+			}
+			if sleep(ctx, backoff(r.initial, r.limit, attempt)) != nil {
+				return
+			}
+			metrics.DBRetries.WithLabelValues(operation).Inc()
+			logging.FromContext(ctx).Debug("retrying statement", "operation", operation, "attempt", attempt+1, "error", err)
+			tx.Error = nil
+			if wantsRows {
+				tx.Statement.Settings.Store("rows", rows)
+			}
+		}
+	}
+}
+
+// statementError returns the error of the statement tx ran, which for a
+// single row is only kept in the row
+func statementError(tx *gorm.DB) error {
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if row, ok := tx.Statement.Dest.(*sql.Row); ok {
+		return row.Err()
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// failingConn fails every statement with the next of errs, the last one
+// repeating, and counts the statements sent
+type failingConn struct {
+	errs    []error
+	queries int
+	rows    int
+	execs   int
+}
+
+func (c *failingConn) next() error {
+	return c.errs[min(c.queries+c.rows+c.execs, len(c.errs)-1)]
+}
+
+func (c *failingConn) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *failingConn) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	err := c.next()
+	c.execs++
+	return nil, err
+}
+
+func (c *failingConn) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	err := c.next()
+	c.queries++
+	return nil, err
+}
+
+func (c *failingConn) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	c.rows++
+	return nil
+}
+
+// failingTx runs the statements of a transaction on a failingConn
+type failingTx struct {
+	*failingConn
+}
+
+func (failingTx) Commit() error { return nil }
+
+func (failingTx) Rollback() error { return nil }
+
+var (
+	errSerialization = &pgconn.PgError{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	errFinal         = errors.New("final")
+)
+
+func openFailing(t *testing.T, conn *failingConn) *gorm.DB {
+	t.Helper()
+	database, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, retrier{attempts: 3, initial: time.Millisecond, limit: time.Millisecond}.register(database))
+	return database
+}
+
+func TestBackoff(t *testing.T) {
+	for range 100 {
+		assert.InDelta(t, 75*time.Millisecond, backoff(100*time.Millisecond, time.Second, 1), float64(25*time.Millisecond))
+		assert.InDelta(t, 300*time.Millisecond, backoff(100*time.Millisecond, time.Second, 3), float64(100*time.Millisecond))
+		assert.InDelta(t, 750*time.Millisecond, backoff(100*time.Millisecond, time.Second, 40), float64(250*time.Millisecond))
+	}
+	assert.Zero(t, backoff(0, time.Second, 2))
+}
+
+func TestTransient(t *testing.T) {
+	tests := map[error]bool{
+		errSerialization:                                  true,
+		&pgconn.PgError{Code: "40P01"}:                    true,
+		&pgconn.PgError{Code: "57P01"}:                    true,
+		&pgconn.PgError{Code: "08006"}:                    true,
+		&pgconn.PgError{Code: "23505"}:                    false,
+		&pgconn.PgError{Code: "28P01"}:                    false,
+		fmt.Errorf("read: %w", syscall.ECONNRESET):        true,
+		io.ErrUnexpectedEOF:                               true,
+		gorm.ErrRecordNotFound:                            false,
+		context.Canceled:                                  false,
+		fmt.Errorf("query: %w", context.DeadlineExceeded): false,
+	}
+	for err, want := range tests {
+		assert.Equal(t, want, transient(err), err.Error())
+	}
+	assert.False(t, transient(nil))
+}
+
+type flakyPinger struct {
+	errs  []error
+	pings int
+}
+
+func (p *flakyPinger) PingContext(context.Context) error {
+	p.pings++
+	if p.pings > len(p.errs) {
+		return nil
+	}
+	return p.errs[p.pings-1]
+}
+
+func TestConnect(t *testing.T) {
+	refusedErr := &pgconn.PgError{Code: "28P01", Message: "password authentication failed"}
+	down := errors.New("dial tcp: connection refused")
+	tests := map[string]struct {
+		errs      []error
+		wantErr   error
+		wantPings int
+	}{
+		"up":             {wantPings: 1},
+		"starting":       {errs: []error{down, down}, wantPings: 3},
+		"down":           {errs: []error{down, down, down, down, down}, wantErr: down, wantPings: 4},
+		"wrong password": {errs: []error{refusedErr}, wantErr: refusedErr, wantPings: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			pool := &flakyPinger{errs: tt.errs}
+			err := connect(context.Background(), pool, 4, time.Millisecond)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantPings, pool.pings)
+		})
+	}
+}
+
+func TestConnect_StopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool := &flakyPinger{errs: []error{errors.New("down")}}
+	assert.ErrorIs(t, connect(ctx, pool, 10, time.Hour), context.Canceled)
+	assert.Equal(t, 1, pool.pings)
+}
+
+func TestRetrier_RetriesReads(t *testing.T) {
+	conn := &failingConn{errs: []error{errSerialization, errFinal}}
+	database := openFailing(t, conn)
+
+	var found book
+	assert.ErrorIs(t, database.First(&found, 7).Error, errFinal)
+	assert.Equal(t, 2, conn.queries)
+
+	conn = &failingConn{errs: []error{errSerialization}}
+	database = openFailing(t, conn)
+	assert.ErrorIs(t, database.Find(&[]book{}).Error, errSerialization)
+	assert.Equal(t, 3, conn.queries, "gives up after the attempts")
+}
+
+func TestRetrier_RetriesRows(t *testing.T) {
+	conn := &failingConn{errs: []error{errSerialization, errFinal}}
+	database := openFailing(t, conn)
+
+	_, err := database.Raw("SELECT 1").Rows()
+	assert.ErrorIs(t, err, errFinal)
+	assert.Equal(t, 2, conn.queries)
+	assert.Zero(t, conn.rows)
+}
+
+func TestRetrier_LeavesOthersAlone(t *testing.T) {
+	tests := map[string]struct {
+		err error
+		run func(database *gorm.DB) error
+	}{
+		"not transient": {errFinal, func(database *gorm.DB) error {
+			return database.First(&book{}, 7).Error
+		}},
+		"write": {errSerialization, func(database *gorm.DB) error {
+			return database.Create(&book{Name: "Dune"}).Error
+		}},
+		"raw write": {errSerialization, func(database *gorm.DB) error {
+			return database.Raw("UPDATE books SET name = 'x' RETURNING *").Scan(&book{}).Error
+		}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			conn := &failingConn{errs: []error{tt.err}}
+			assert.ErrorIs(t, tt.run(openFailing(t, conn)), tt.err)
+			assert.Equal(t, 1, conn.queries+conn.execs)
+		})
+	}
+
+	t.Run("transaction", func(t *testing.T) {
+		conn := &failingConn{errs: []error{errSerialization}}
+		tx := openFailing(t, conn).Session(&gorm.Session{})
+		tx.Statement.ConnPool = failingTx{conn}
+		assert.ErrorIs(t, tx.First(&book{}, 7).Error, errSerialization)
+		assert.Equal(t, 1, conn.queries)
+	})
+}
+
+// flakyTransactions fails the first transactions with errs and runs fn in
+// the rest
+type flakyTransactions struct {
+	Database
+	errs []error
+	runs int
+}
+
+func (d *flakyTransactions) WithContext(context.Context) Database { return d }
+
+func (d *flakyTransactions) Transaction(fn func(tx *gorm.DB) error, _ ...*sql.TxOptions) error {
+	d.runs++
+	if d.runs <= len(d.errs) {
+		return d.errs[d.runs-1]
+	}
+	return fn(nil)
+}
+
+func TestRetrier_RetriesTransactions(t *testing.T) {
+	r := retrier{attempts: 3, initial: time.Millisecond, limit: time.Millisecond}
+	deadlock := &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+	tests := map[string]struct {
+		errs     []error
+		wantErr  error
+		wantRuns int
+	}{
+		"serialization failure": {errs: []error{errSerialization}, wantRuns: 2},
+		"deadlocks":             {errs: []error{deadlock, deadlock, deadlock}, wantErr: deadlock, wantRuns: 3},
+		"lost connection":       {errs: []error{io.ErrUnexpectedEOF}, wantErr: io.ErrUnexpectedEOF, wantRuns: 1},
+		"not transient":         {errs: []error{errFinal}, wantErr: errFinal, wantRuns: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			database := &flakyTransactions{errs: tt.errs}
+			ran := false
+			err := r.transaction(context.Background(), database, func(*gorm.DB) error {
+				ran = true
+				return nil
+			})
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantRuns, database.runs)
+			assert.Equal(t, tt.wantErr == nil, ran)
+		})
+	}
+}
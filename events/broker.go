@@ -0,0 +1,193 @@
+// Package events fans the book events of the outbox out to live
+// subscribers such as the Server-Sent Events feed.
+package events
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/models"
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Subscription receives the events published after it was made. When the
+// subscriber falls more than its buffer behind, the subscription is
+// dropped and C is closed; the subscriber can catch up from the outbox.
+type Subscription struct {
+	C  <-chan models.OutboxEvent
+	ch chan models.OutboxEvent
+
+	mu      sync.Mutex
+	dropped bool
+}
+
+// Dropped reports whether the subscription was closed because the
+// subscriber was too slow
+func (s *Subscription) Dropped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Broker polls the outbox for new events and publishes them to its
+// subscriptions in the order they commit. The outbox is the source of
+// truth, so events written by other instances of the service are seen as
+// well.
+type Broker struct {
+	db       db.Database
+	interval time.Duration
+	buffer   int
+	batch    int
+	// wake makes Run poll before the next tick
+	wake chan struct{}
+
+	// now is time.Now outside tests
+	now func() time.Time
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	cursor Cursor
+	// started is set once the end of the outbox is known
+	started bool
+}
+
+// NewBroker returns a broker that polls database every interval and
+// buffers up to buffer events per subscription
+func NewBroker(database db.Database, interval time.Duration, buffer int) *Broker {
+	return &Broker{
+		db:       database,
+		interval: interval,
+		buffer:   buffer,
+		batch:    500,
+		wake:     make(chan struct{}, 1),
+		now:      time.Now,
+		subs:     map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe starts a subscription to the events published from now on
+func (b *Broker) Subscribe() *Subscription {
+	ch := make(chan models.OutboxEvent, b.buffer)
+	s := &Subscription{C: ch, ch: ch}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Unsubscribe ends a subscription and closes its channel
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish hands event to every subscription without blocking. Events
+// the cursor has seen are ignored, so the same event can be offered more
+// than once, while one with a lower sequence than the last published still
+// goes out when it filled a gap.
+func (b *Broker) Publish(event models.OutboxEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.cursor.Advance(event.ID, b.now()) {
+		return
+	}
+	for s := range b.subs {
+		select {
+		case s.ch <- event:
+		default:
+			// The subscriber is too slow; rather than buffering without
+			// bound or holding everyone else up, cut it loose
+			s.mu.Lock()
+			s.dropped = true
+			s.mu.Unlock()
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Cursor returns a copy of the position of the published events
+func (b *Broker) Cursor() Cursor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cursor.clone()
+}
+
+// Wake makes Run poll now rather than at the next tick, such as when the
+// database announces a change. It never blocks.
+func (b *Broker) Wake() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run polls the outbox until ctx is cancelled. Events already in the
+// outbox when it starts are not published.
+func (b *Broker) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("error polling outbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.wake:
+		}
+	}
+}
+
+// Poll publishes the events committed since the last poll: those filling
+// a gap of the cursor, then those after it
+func (b *Broker) Poll(ctx context.Context) error {
+	database := b.db.WithContext(ctx)
+	b.mu.Lock()
+	started := b.started
+	gaps := b.cursor.Pending(b.now())
+	b.mu.Unlock()
+	if !started {
+		// The last events tell the end of the outbox and which sequences
+		// before it are still to commit
+		var recent []int64
+		if err := database.Model(&models.OutboxEvent{}).Order("id DESC").Limit(maxGaps).Pluck("id", &recent).Error; err != nil {
+			return err
+		}
+		slices.Reverse(recent)
+		b.mu.Lock()
+		b.cursor.seed(recent, b.now())
+		b.started = true
+		b.mu.Unlock()
+		return nil
+	}
+
+	if len(gaps) > 0 {
+		var filled []models.OutboxEvent
+		if err := database.Where("id IN ?", gaps).Order("id").Find(&filled).Error; err != nil {
+			return err
+		}
+		for _, event := range filled {
+			b.Publish(event)
+		}
+	}
+	for {
+		var events []models.OutboxEvent
+		if err := database.Where("id > ?", b.Cursor().Last).Order("id").Limit(b.batch).Find(&events).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			b.Publish(event)
+		}
+		if len(events) < b.batch {
+			return nil
+		}
+	}
+}
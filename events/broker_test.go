@@ -0,0 +1,102 @@
+package events
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	broker := NewBroker(nil, 0, 4)
+	first, second := broker.Subscribe(), broker.Subscribe()
+
+	broker.Publish(models.OutboxEvent{ID: 1})
+	broker.Publish(models.OutboxEvent{ID: 2})
+	broker.Publish(models.OutboxEvent{ID: 2})
+
+	for _, s := range []*Subscription{first, second} {
+		assert.Equal(t, int64(1), (<-s.C).ID)
+		assert.Equal(t, int64(2), (<-s.C).ID)
+		assert.Empty(t, s.C)
+	}
+	assert.Equal(t, int64(2), broker.Cursor().Last)
+
+	broker.Unsubscribe(first)
+	_, open := <-first.C
+	assert.False(t, open)
+	assert.False(t, first.Dropped())
+	broker.Unsubscribe(first)
+}
+
+func TestBroker_PublishLateEvent(t *testing.T) {
+	broker := NewBroker(nil, 0, 4)
+	s := broker.Subscribe()
+
+	broker.Publish(models.OutboxEvent{ID: 1})
+	broker.Publish(models.OutboxEvent{ID: 3})
+	broker.Publish(models.OutboxEvent{ID: 2})
+	broker.Publish(models.OutboxEvent{ID: 2})
+
+	for _, want := range []int64{1, 3, 2} {
+		assert.Equal(t, want, (<-s.C).ID)
+	}
+	assert.Empty(t, s.C)
+	assert.Empty(t, broker.Cursor().Gaps())
+}
+
+func TestBroker_DropsSlowSubscriber(t *testing.T) {
+	broker := NewBroker(nil, 0, 1)
+	slow, fast := broker.Subscribe(), broker.Subscribe()
+
+	broker.Publish(models.OutboxEvent{ID: 1})
+	<-fast.C
+	broker.Publish(models.OutboxEvent{ID: 2})
+
+	assert.True(t, slow.Dropped())
+	assert.Equal(t, int64(1), (<-slow.C).ID)
+	_, open := <-slow.C
+	assert.False(t, open)
+
+	assert.False(t, fast.Dropped())
+	assert.Equal(t, int64(2), (<-fast.C).ID)
+	broker.Unsubscribe(slow)
+}
+
+func TestBroker_Wake(t *testing.T) {
+	broker := NewBroker(nil, 0, 1)
+	broker.Wake()
+	broker.Wake()
+	assert.Len(t, broker.wake, 1)
+}
+
+func TestBroker_PollLateEvent(t *testing.T) {
+	outbox := &mocks.Outbox{}
+	database, err := outbox.Open()
+	require.NoError(t, err)
+	broker := NewBroker(database, 0, 8)
+	s := broker.Subscribe()
+
+	// 2 is taken by a transaction still running when the broker starts
+	outbox.Commit(models.OutboxEvent{ID: 1}, models.OutboxEvent{ID: 3})
+	require.NoError(t, broker.Poll(context.Background()))
+	assert.Empty(t, s.C, "events before the start are not published")
+	assert.Equal(t, []int64{2}, broker.Cursor().Gaps())
+
+	// 5 commits before 4
+	outbox.Commit(models.OutboxEvent{ID: 5})
+	require.NoError(t, broker.Poll(context.Background()))
+	assert.Equal(t, int64(5), (<-s.C).ID)
+
+	outbox.Commit(models.OutboxEvent{ID: 2}, models.OutboxEvent{ID: 4})
+	require.NoError(t, broker.Poll(context.Background()))
+	assert.Equal(t, int64(2), (<-s.C).ID)
+	assert.Equal(t, int64(4), (<-s.C).ID)
+	require.NoError(t, broker.Poll(context.Background()))
+	assert.Empty(t, s.C)
+	assert.Equal(t, Cursor{Last: 5, gaps: map[int64]time.Time{}}, broker.Cursor())
+}
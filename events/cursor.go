@@ -0,0 +1,137 @@
+package events
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// gapTimeout is how long a cursor waits for a sequence it skipped. The
+	// transaction that took it is either still running or rolled back,
+	// which leaves the gap for good.
+	gapTimeout = time.Minute
+	// maxGaps bounds the gaps a cursor waits for; the lowest give up first
+	maxGaps = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in the outbox. Sequences are taken when events are
+// written but only become visible when their transactions commit, which
+// may be out of order: 7 can be read before 6. A cursor that has seen 7
+// keeps 6 as a gap, so that it is still delivered when it commits.
+type Cursor struct {
+	// Last is the highest sequence seen
+	Last int64
+	// gaps holds the sequences below Last not seen yet, with the time they
+	// were found missing
+	gaps map[int64]time.Time
+}
+
+// Advance records the sequence id as seen at now and reports whether it
+// was new to the cursor
+func (c *Cursor) Advance(id int64, now time.Time) bool {
+	c.expire(now)
+	if id <= c.Last {
+		if _, ok := c.gaps[id]; !ok {
+			return false
+		}
+		delete(c.gaps, id)
+		return true
+	}
+	if id > c.Last+1 && c.gaps == nil {
+		c.gaps = map[int64]time.Time{}
+	}
+	for missing := max(c.Last+1, id-maxGaps); missing < id; missing++ {
+		c.gaps[missing] = now
+	}
+	c.Last = id
+	if extra := len(c.gaps) - maxGaps; extra > 0 {
+		for _, missing := range c.Gaps()[:extra] {
+			delete(c.gaps, missing)
+		}
+	}
+	return true
+}
+
+// Pending returns the gaps still waited for at now, in order
+func (c *Cursor) Pending(now time.Time) []int64 {
+	c.expire(now)
+	return c.Gaps()
+}
+
+// Gaps returns the sequences below Last not seen yet, in order
+func (c Cursor) Gaps() []int64 {
+	return slices.Sorted(maps.Keys(c.gaps))
+}
+
+func (c *Cursor) expire(now time.Time) {
+	maps.DeleteFunc(c.gaps, func(_ int64, found time.Time) bool {
+		return now.Sub(found) >= gapTimeout
+	})
+}
+
+// clone returns a copy of c that doesn't share its gaps
+func (c Cursor) clone() Cursor {
+	c.gaps = maps.Clone(c.gaps)
+	return c
+}
+
+// seed moves the cursor to the end of the outbox, given the sequences
+// recent of its last events in order, keeping the missing ones as gaps
+func (c *Cursor) seed(recent []int64, now time.Time) {
+	if len(recent) == 0 {
+		return
+	}
+	c.Last = max(c.Last, recent[0]-1)
+	for _, id := range recent {
+		c.Advance(id, now)
+	}
+}
+
+// String encodes the cursor as Last, followed by its gaps after a colon
+// when there are any, e.g. "42" or "42:39,40"
+func (c Cursor) String() string {
+	var s strings.Builder
+	s.WriteString(strconv.FormatInt(c.Last, 10))
+	for i, gap := range c.Gaps() {
+		if i == 0 {
+			s.WriteByte(':')
+		} else {
+			s.WriteByte(',')
+		}
+		s.WriteString(strconv.FormatInt(gap, 10))
+	}
+	return s.String()
+}
+
+// ParseCursor decodes a cursor encoded by String. Its gaps are waited for
+// from now.
+func ParseCursor(s string, now time.Time) (Cursor, error) {
+	last, gaps, found := strings.Cut(s, ":")
+	var c Cursor
+	var err error
+	if c.Last, err = strconv.ParseInt(last, 10, 64); err != nil || c.Last < 0 {
+		return Cursor{}, errInvalidCursor
+	}
+	if !found {
+		return c, nil
+	}
+	fields := strings.Split(gaps, ",")
+	if len(fields) > maxGaps {
+		return Cursor{}, errInvalidCursor
+	}
+	c.gaps = make(map[int64]time.Time, len(fields))
+	for _, field := range fields {
+		gap, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || gap <= 0 || gap >= c.Last {
+			return Cursor{}, errInvalidCursor
+		}
+		c.gaps[gap] = now
+	}
+	return c, nil
+}
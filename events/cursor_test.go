@@ -0,0 +1,61 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor_Advance(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	var c Cursor
+	assert.True(t, c.Advance(1, now))
+	assert.True(t, c.Advance(4, now))
+	assert.False(t, c.Advance(4, now))
+	assert.Equal(t, []int64{2, 3}, c.Pending(now))
+
+	assert.True(t, c.Advance(3, now), "a gap filled late is new")
+	assert.False(t, c.Advance(3, now))
+	assert.Equal(t, "4:2", c.String())
+
+	later := now.Add(gapTimeout)
+	assert.Empty(t, c.Pending(later), "a gap that never filled is given up")
+	assert.False(t, c.Advance(2, later))
+	assert.Equal(t, "4", c.String())
+
+	c.Advance(4+2*maxGaps, later)
+	gaps := c.Gaps()
+	assert.Len(t, gaps, maxGaps)
+	assert.Equal(t, int64(4+maxGaps), gaps[0])
+}
+
+func TestCursor_Seed(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := Cursor{Last: 2}
+	c.seed([]int64{7, 8, 10}, now)
+	assert.Equal(t, int64(10), c.Last)
+	assert.Equal(t, []int64{9}, c.Gaps())
+
+	c.seed(nil, now)
+	assert.Equal(t, int64(10), c.Last)
+}
+
+func TestParseCursor(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	c, err := ParseCursor("42:39,40", now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), c.Last)
+	assert.Equal(t, []int64{39, 40}, c.Pending(now))
+	assert.Equal(t, "42:39,40", c.String())
+
+	c, err = ParseCursor("7", now)
+	require.NoError(t, err)
+	assert.Equal(t, Cursor{Last: 7}, c)
+
+	for _, invalid := range []string{"", "x", "-1", "42:", "42:43", "42:0", "42:a"} {
+		_, err := ParseCursor(invalid, now)
+		assert.Error(t, err, invalid)
+	}
+}
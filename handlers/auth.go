@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"connection_to_pg/auth"
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// apiKeyFromRequest returns the API key sent as a bearer token or in the
+// X-API-Key header
+func apiKeyFromRequest(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// userOfKey returns the user an unrevoked API key was issued to
+func (h *Handler) userOfKey(ctx context.Context, key string) (models.User, error) {
+	var apiKey models.APIKey
+	err := h.DB.WithContext(ctx).First(&apiKey, "hash = ? AND revoked_at IS NULL", auth.HashKey(key)).Error
+	var user models.User
+	if err == nil {
+		err = h.DB.WithContext(ctx).First(&user, apiKey.UserID).Error
+	}
+	return user, err
+}
+
+// userClient and addrClient name the client of a request for
+// read-your-writes: its user, or the address of anonymous callers
+func userClient(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+func addrClient(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "addr:" + addr
+}
+
+// Authenticate resolves the API key of a request, if one was sent, to the
+// user it was issued to. Requests without a key continue anonymously;
+// endpoints that need a user call requirePrincipal. Unknown and revoked
+// keys are refused with 401. The caller becomes the database client of the
+// request, so it reads its own writes.
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			next.ServeHTTP(w, r.WithContext(db.WithClient(r.Context(), addrClient(r.RemoteAddr))))
+			return
+		}
+
+		user, err := h.userOfKey(r.Context(), key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			renderError(w, r, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error resolving API key", "error", err)
+			renderError(w, r, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		logging.SetPrincipal(r.Context(), user.Username)
+		ctx := auth.NewContext(r.Context(), auth.Principal{UserID: user.ID, Username: user.Username, Role: user.Role})
+		next.ServeHTTP(w, r.WithContext(db.WithClient(ctx, userClient(user.ID))))
+	})
+}
+
+// requirePrincipal returns the authenticated caller and answers 401 when
+// there is none
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		renderError(w, r, http.StatusUnauthorized, "Authentication required, send an API key")
+	}
+	return principal, ok
+}
+
+// requireAdmin returns the authenticated caller when they are an admin,
+// answering 401 or 403 otherwise
+func requireAdmin(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, ok := requirePrincipal(w, r)
+	if ok && principal.Role != models.RoleAdmin {
+		renderError(w, r, http.StatusForbidden, "Only admins can do this")
+		return principal, false
+	}
+	return principal, ok
+}
@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// authorBody is the request payload for creating and updating authors
+type authorBody struct {
+	Name string `json:"name" xml:"name"`
+	Bio  string `json:"bio" xml:"bio"`
+}
+
+// authoredBook is one credit of an author, listed by GET /authors/{id}/books
+type authoredBook struct {
+	ID          int    `json:"id" xml:"id"`
+	Name        string `json:"name" xml:"name"`
+	Description string `json:"description" xml:"description"`
+	Author      string `json:"author" xml:"author"`
+	Role        string `json:"role" xml:"role"`
+}
+
+// bookCredit is one author of a book, listed by GET /books/{id}/authors
+type bookCredit struct {
+	AuthorID int    `json:"author_id" xml:"author_id"`
+	Name     string `json:"name" xml:"name"`
+	Role     string `json:"role" xml:"role"`
+	Position int    `json:"position" xml:"position"`
+}
+
+// creditBody is one entry of the PUT /books/{id}/authors payload
+type creditBody struct {
+	AuthorID int    `json:"author_id" xml:"author_id"`
+	Role     string `json:"role" xml:"role"`
+}
+
+var (
+	errBookNotFound  = errors.New("book not found")
+	errUnknownAuthor = errors.New("unknown author")
+)
+
+func authorLocation(id int) string {
+	return "/authors/" + strconv.Itoa(id)
+}
+
+// pathID parses the {id} URL parameter
+func pathID(r *http.Request) (int, error) {
+	return strconv.Atoi(chi.URLParam(r, "id"))
+}
+
+// findBook loads the book named by the {id} parameter and answers the
+// request itself when that fails
+func (h *Handler) findBook(w http.ResponseWriter, r *http.Request) (models.Book, bool) {
+	var book models.Book
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return book, false
+	}
+	if err := h.dbFor(r).First(&book, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Book not found")
+			return book, false
+		}
+		logging.FromContext(r.Context()).Error("error querying book", "book_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return book, false
+	}
+	return book, true
+}
+
+func (h *Handler) CreateAuthor(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+
+	var body authorBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if models.NormalizeAuthorName(body.Name) == "" {
+		renderError(w, r, http.StatusBadRequest, "Author name is required")
+		return
+	}
+
+	author := models.Author{Name: body.Name, Bio: body.Bio}
+	if err := h.dbFor(r).Create(&author).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			h.renderDuplicateAuthor(w, r, body.Name)
+			return
+		}
+		logging.FromContext(r.Context()).Error("error creating author", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to create author")
+		return
+	}
+
+	w.Header().Set("Location", authorLocation(author.ID))
+	render(w, r, http.StatusCreated, author)
+}
+
+// renderDuplicateAuthor answers 409 and points at the author the name
+// collided with
+func (h *Handler) renderDuplicateAuthor(w http.ResponseWriter, r *http.Request, name string) {
+	var existing models.Author
+	if err := h.dbFor(r).First(&existing, "normalized_name = ?", models.NormalizeAuthorName(name)).Error; err == nil {
+		w.Header().Set("Location", authorLocation(existing.ID))
+	}
+	renderError(w, r, http.StatusConflict, "Author already exists")
+}
+
+func (h *Handler) ListAuthors(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+
+	var conds []interface{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		conds = []interface{}{"name ILIKE ?", "%" + escapeLike(q) + "%"}
+	}
+
+	authors := []models.Author{}
+	if err := h.dbFor(r).Find(&authors, conds...).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying authors table", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve authors")
+		return
+	}
+	sort.Slice(authors, func(i, j int) bool { return authors[i].Name < authors[j].Name })
+
+	render(w, r, http.StatusOK, authors)
+}
+
+// findAuthor loads the author named by the {id} parameter and answers the
+// request itself when that fails
+func (h *Handler) findAuthor(w http.ResponseWriter, r *http.Request) (models.Author, bool) {
+	var author models.Author
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid author ID")
+		return author, false
+	}
+	if err := h.dbFor(r).First(&author, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Author not found")
+			return author, false
+		}
+		logging.FromContext(r.Context()).Error("error querying author", "author_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return author, false
+	}
+	return author, true
+}
+
+func (h *Handler) GetAuthor(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	author, ok := h.findAuthor(w, r)
+	if !ok {
+		return
+	}
+	render(w, r, http.StatusOK, author)
+}
+
+func (h *Handler) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	author, ok := h.findAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	var body authorBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if models.NormalizeAuthorName(body.Name) == "" {
+		renderError(w, r, http.StatusBadRequest, "Author name is required")
+		return
+	}
+
+	author.Name = body.Name
+	author.Bio = body.Bio
+	if err := h.dbFor(r).Save(&author).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			h.renderDuplicateAuthor(w, r, body.Name)
+			return
+		}
+		logging.FromContext(r.Context()).Error("error updating author", "author_id", author.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update author")
+		return
+	}
+
+	render(w, r, http.StatusOK, author)
+}
+
+// DeleteAuthor removes an author and their credits. The free-text author
+// of their books is left as it is.
+func (h *Handler) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	author, ok := h.findAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.dbFor(r).Delete(&author).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error deleting author", "author_id", author.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete author")
+		return
+	}
+
+	renderMessage(w, r, http.StatusOK, "Author deleted successfully")
+}
+
+// GetAuthorBooks lists the books an author is credited on, once per role
+func (h *Handler) GetAuthorBooks(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	author, ok := h.findAuthor(w, r)
+	if !ok {
+		return
+	}
+
+	var credits []models.BookAuthor
+	if err := h.dbFor(r).Find(&credits, "author_id = ?", author.ID).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying author credits", "author_id", author.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve books")
+		return
+	}
+
+	result := []authoredBook{}
+	if len(credits) > 0 {
+		ids := make([]int, len(credits))
+		for i, credit := range credits {
+			ids[i] = credit.BookID
+		}
+		var books []models.Book
+		if err := h.dbFor(r).Find(&books, "id IN ?", ids).Error; err != nil {
+			logging.FromContext(r.Context()).Error("error querying author books", "author_id", author.ID, "error", err)
+			renderError(w, r, http.StatusInternalServerError, "Failed to retrieve books")
+			return
+		}
+		byID := make(map[int]models.Book, len(books))
+		for _, book := range books {
+			byID[book.ID] = book
+		}
+		for _, credit := range credits {
+			book, ok := byID[credit.BookID]
+			if !ok {
+				continue
+			}
+			result = append(result, authoredBook{
+				ID:          book.ID,
+				Name:        book.Name,
+				Description: book.Description,
+				Author:      book.Author,
+				Role:        credit.Role,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ID != result[j].ID {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].Role < result[j].Role
+	})
+
+	render(w, r, http.StatusOK, result)
+}
+
+// GetBookAuthors lists the credits of a book in order
+func (h *Handler) GetBookAuthors(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	credits, err := bookCredits(h.dbFor(r), book.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error querying book credits", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve authors")
+		return
+	}
+	render(w, r, http.StatusOK, credits)
+}
+
+func bookCredits(database Database, bookID int) ([]bookCredit, error) {
+	var credits []models.BookAuthor
+	if err := database.Find(&credits, "book_id = ?", bookID).Error; err != nil {
+		return nil, err
+	}
+	result := []bookCredit{}
+	if len(credits) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int, len(credits))
+	for i, credit := range credits {
+		ids[i] = credit.AuthorID
+	}
+	var authors []models.Author
+	if err := database.Find(&authors, "id IN ?", ids).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(authors))
+	for _, author := range authors {
+		names[author.ID] = author.Name
+	}
+	for _, credit := range credits {
+		result = append(result, bookCredit{
+			AuthorID: credit.AuthorID,
+			Name:     names[credit.AuthorID],
+			Role:     credit.Role,
+			Position: credit.Position,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Position < result[j].Position })
+	return result, nil
+}
+
+// SetBookAuthors replaces the credits of a book with the ordered list in
+// the body. The book's free-text author becomes the names credited in the
+// author role, so existing clients keep seeing a matching value.
+func (h *Handler) SetBookAuthors(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	var body []creditBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	seen := map[creditBody]bool{}
+	for _, credit := range body {
+		if !models.ValidAuthorRole(credit.Role) {
+			renderError(w, r, http.StatusBadRequest, fmt.Sprintf("role must be one of %s", strings.Join(models.AuthorRoles, ", ")))
+			return
+		}
+		if seen[credit] {
+			renderError(w, r, http.StatusBadRequest, fmt.Sprintf("author %d is listed twice as %s", credit.AuthorID, credit.Role))
+			return
+		}
+		seen[credit] = true
+	}
+
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		return replaceCredits(tx, bookID, body)
+	})
+	switch {
+	case errors.Is(err, errBookNotFound):
+		renderError(w, r, http.StatusNotFound, "Book not found")
+		return
+	case errors.Is(err, errUnknownAuthor):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("error replacing book credits", "book_id", bookID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update authors")
+		return
+	}
+
+	credits, err := bookCredits(h.dbFor(r), bookID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve authors")
+		return
+	}
+	render(w, r, http.StatusOK, credits)
+}
+
+func replaceCredits(tx *gorm.DB, bookID int, body []creditBody) error {
+	var book models.Book
+	if err := tx.First(&book, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errBookNotFound
+		}
+		return err
+	}
+
+	ids := make([]int, 0, len(body))
+	for _, credit := range body {
+		ids = append(ids, credit.AuthorID)
+	}
+	var authors []models.Author
+	if err := tx.Find(&authors, "id IN ?", ids).Error; err != nil {
+		return err
+	}
+	names := make(map[int]string, len(authors))
+	for _, author := range authors {
+		names[author.ID] = author.Name
+	}
+
+	if err := tx.Where("book_id = ?", bookID).Delete(&models.BookAuthor{}).Error; err != nil {
+		return err
+	}
+	var primary []string
+	for i, credit := range body {
+		name, ok := names[credit.AuthorID]
+		if !ok {
+			return fmt.Errorf("%w %d", errUnknownAuthor, credit.AuthorID)
+		}
+		row := models.BookAuthor{BookID: bookID, AuthorID: credit.AuthorID, Role: credit.Role, Position: i}
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+		if credit.Role == models.AuthorRoleAuthor {
+			primary = append(primary, name)
+		}
+	}
+
+	// UpdateColumn skips the Book hooks, which would credit the string
+	// again, so the change is recorded in the outbox here
+	book.Author = strings.Join(primary, models.AuthorSeparator)
+	if err := tx.Model(&book).UpdateColumn("author", book.Author).Error; err != nil {
+		return err
+	}
+	return models.RecordBookEvent(tx, models.EventBookUpdated, &book)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func withID(req *http.Request, id string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestCreateAuthor(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.MatchedBy(func(a *models.Author) bool {
+		return a.Name == "J.K. Rowling" && a.Bio == "Novelist"
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Author).ID = 7
+	}).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/authors", strings.NewReader(`{"name":"J.K. Rowling","bio":"Novelist"}`))
+	w := httptest.NewRecorder()
+	handler.CreateAuthor(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/authors/7", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `"name":"J.K. Rowling"`)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreateAuthor_NameRequired(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := httptest.NewRequest(http.MethodPost, "/authors", strings.NewReader(`{"name":" . "}`))
+	w := httptest.NewRecorder()
+	handler.CreateAuthor(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestCreateAuthor_Duplicate(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.Author")).Return(&pgconn.PgError{Code: "23505"})
+	mockDB.On("First", mock.AnythingOfType("*models.Author"), "normalized_name = ?").Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Author).ID = 3
+	}).Return(&gorm.DB{})
+
+	req := httptest.NewRequest(http.MethodPost, "/authors", strings.NewReader(`{"name":"JK Rowling"}`))
+	w := httptest.NewRecorder()
+	handler.CreateAuthor(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "/authors/3", w.Header().Get("Location"))
+}
+
+func TestGetAuthorBooks(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.Author"), 4).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Author) = models.Author{ID: 4, Name: "Frank Herbert"}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.BookAuthor"), []interface{}{"author_id = ?", 4}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.BookAuthor) = []models.BookAuthor{
+			{BookID: 2, AuthorID: 4, Role: models.AuthorRoleEditor},
+			{BookID: 1, AuthorID: 4, Role: models.AuthorRoleAuthor},
+		}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), []interface{}{"id IN ?", []int{2, 1}}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = []models.Book{
+			{ID: 1, Name: "Dune", Author: "Frank Herbert"},
+			{ID: 2, Name: "Anthology"},
+		}
+	}).Return(&gorm.DB{})
+
+	w := httptest.NewRecorder()
+	handler.GetAuthorBooks(w, withID(httptest.NewRequest(http.MethodGet, "/authors/4/books", nil), "4"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[
+		{"id":1,"name":"Dune","description":"","author":"Frank Herbert","role":"author"},
+		{"id":2,"name":"Anthology","description":"","author":"","role":"editor"}
+	]`, w.Body.String())
+}
+
+func TestGetAuthor_NotFound(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.Author"), 9).Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+
+	w := httptest.NewRecorder()
+	handler.GetAuthor(w, withID(httptest.NewRequest(http.MethodGet, "/authors/9", nil), "9"))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSetBookAuthors_Validation(t *testing.T) {
+	cases := map[string]string{
+		`[{"author_id":1,"role":"illustrator"}]`:                            "role must be one of author, editor, translator",
+		`[{"author_id":1,"role":"author"},{"author_id":1,"role":"author"}]`: "author 1 is listed twice as author",
+		`{"author_id":1}`: "Invalid request payload",
+	}
+	for body, message := range cases {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+
+		req := withID(httptest.NewRequest(http.MethodPut, "/books/1/authors", strings.NewReader(body)), "1")
+		w := httptest.NewRecorder()
+		handler.SetBookAuthors(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), message, body)
+		mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+	}
+}
+
+func TestSetBookAuthors_TransactionErrors(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+	}{
+		{errBookNotFound, http.StatusNotFound},
+		{errUnknownAuthor, http.StatusUnprocessableEntity},
+		{assert.AnError, http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+		mockDB.On("Transaction", mock.Anything).Return(tc.err)
+
+		req := withID(httptest.NewRequest(http.MethodPut, "/books/1/authors", strings.NewReader(`[{"author_id":1,"role":"author"}]`)), "1")
+		w := httptest.NewRecorder()
+		handler.SetBookAuthors(w, req)
+
+		assert.Equal(t, tc.status, w.Code, tc.err.Error())
+	}
+}
+
+func TestReplaceCredits_RecordsEvent(t *testing.T) {
+	conn := &scriptedConn{answer: func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "books"`):
+			return []string{"id", "name", "author"}, [][]driver.Value{{int64(7), "Good Omens", "Neil Gaiman"}}
+		case strings.HasPrefix(query, `SELECT * FROM "authors"`):
+			return []string{"id", "name"}, [][]driver.Value{{int64(1), "Neil Gaiman"}, {int64(2), "Terry Pratchett"}}
+		case strings.HasPrefix(query, "INSERT INTO"):
+			return []string{"id"}, [][]driver.Value{{int64(1)}}
+		}
+		return nil, nil
+	}}
+
+	body := []creditBody{{AuthorID: 2, Role: models.AuthorRoleAuthor}, {AuthorID: 1, Role: models.AuthorRoleAuthor}}
+	require.NoError(t, replaceCredits(openScripted(t, conn), 7, body))
+	assert.Equal(t, []string{models.EventBookUpdated}, conn.outboxEvents())
+	for i, statement := range conn.statements {
+		if strings.HasPrefix(statement, `INSERT INTO "outbox_events"`) {
+			assert.Contains(t, conn.statements[i-1], `UPDATE "books" SET "author"`)
+			var data []byte
+			for _, arg := range conn.args[i] {
+				if value, ok := arg.Value.([]byte); ok {
+					data = value
+				}
+			}
+			assert.Contains(t, string(data), `"author":"Terry Pratchett, Neil Gaiman"`)
+		}
+	}
+}
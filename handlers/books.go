@@ -1,14 +1,20 @@
 package handlers
 
 import (
-	// "connection_to_pg/db"
+	"connection_to_pg/db"
+	"connection_to_pg/events"
+	"connection_to_pg/isbn"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
 	"connection_to_pg/models"
+	"connection_to_pg/requestid"
+	"connection_to_pg/storage"
 	"errors"
-	"fmt"
 	"strconv"
+	"time"
 
 	"encoding/json"
-	"log"
+	"encoding/xml"
 	"net/http"
 
 	// "strconv"
@@ -17,95 +23,196 @@ import (
 	"gorm.io/gorm"
 )
 
-// Define an interface for database operations
-type Database interface {
-	Create(value interface{}) *gorm.DB
-	Find(dest interface{}, conds ...interface{}) *gorm.DB
-	Where(query interface{}, args ...interface{}) *gorm.DB
-	First(dest interface{}, conds ...interface{}) *gorm.DB
-	Save(value interface{}) *gorm.DB
-	Delete(value interface{}) *gorm.DB
-}
+// Database is the set of database operations the handlers rely on
+type Database = db.Database
 
 // Handler struct now depends on the interface, not on *gorm.DB directly
 type Handler struct {
 	DB Database
+	// Blobs stores cover images; cover endpoints answer 503 without it
+	Blobs storage.Store
+	// MaxCoverBytes limits cover uploads, defaultMaxCoverBytes when zero
+	MaxCoverBytes int64
+	// Fines charges late returns; the zero policy charges nothing
+	Fines models.FinePolicy
+	// Events feeds GET /books/events, which answers 503 without it
+	Events *events.Broker
+	// Heartbeat is how often idle event streams are kept alive,
+	// defaultHeartbeat when zero
+	Heartbeat time.Duration
+	// GraphQLLimits limits the queries of /graphql, defaultGraphQLConfig
+	// for zero fields
+	GraphQLLimits models.GraphQLConfig
+}
+
+// bookDetail is a single book together with the state of its copies and
+// holds queue
+type bookDetail struct {
+	XMLName xml.Name `json:"-" xml:"book" yaml:"-" msgpack:"-"`
+	models.Book
+	Circulation *circulation `json:"circulation,omitempty" xml:"circulation,omitempty"`
+}
+
+// dbFor returns the database scoped to the request's context
+func (h *Handler) dbFor(r *http.Request) Database {
+	return h.DB.WithContext(r.Context())
+}
+
+// transaction runs fn in a transaction scoped to the request, run again
+// when it is rolled back by a serialization failure or a deadlock
+func (h *Handler) transaction(r *http.Request, fn func(tx *gorm.DB) error) error {
+	return db.Transaction(r.Context(), h.DB, fn)
 }
 
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+
 	var book models.Book
-	err := json.NewDecoder(r.Body).Decode(&book)
+	err := decodeBody(r, &book)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		httpError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := book.NormalizeISBN(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// ✅ Fix: Ensure result.Error is checked properly
-	result := h.DB.Create(&book)
-	fmt.Printf("Result: %+v\n", result)            // Print the entire result for inspection
-	fmt.Printf("Result.Error: %v\n", result.Error) // Print the error specifically
-
+	result := h.dbFor(r).Create(&book)
+	if result.Error != nil && db.IsUniqueViolation(result.Error) && book.ISBN13 != nil {
+		h.renderDuplicateISBN(w, r, *book.ISBN13)
+		return
+	}
 	if result.Error != nil {
-		fmt.Println("Error block entered") // Make sure this block is executed
-		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("error creating book", "error", result.Error)
+		httpError(w, r, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println("Success block entered")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book created successfully"})
+	logging.FromContext(r.Context()).Debug("book created", "book_id", book.ID)
+	metrics.BooksCreated.Inc()
+	renderMessage(w, r, http.StatusCreated, "Book created successfully")
 }
 
-func (h *Handler) GetAll(w http.ResponseWriter, _ *http.Request) {
-	var books []models.Book
-
-	// Query the database
-	if err := h.DB.Find(&books).Error; err != nil {
-		http.Error(w, `{"error": "Failed to retrieve books"}`, http.StatusInternalServerError)
-		log.Printf("error querying books table: %v", err)
+func (h *Handler) GetAll(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
 		return
 	}
 
-	// Marshal books to JSON
-	j, _ := json.Marshal(books)
+	books := []models.Book{}
+
+	// Query the database, narrowed down by the optional filters
+	filters := parseBookFilters(r.URL.Query())
+	if err := filters.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := filters.find(h.dbFor(r), &books); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve books")
+		logging.FromContext(r.Context()).Error("error querying books table", "error", err)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	render(w, r, http.StatusOK, books)
 }
 
 var jsonMarshal = json.Marshal
 
 func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "query")
-	fmt.Println("Received ID:", idStr)
 
 	id, err := strconv.Atoi(idStr) // Convert searchQuery to an integer
 	if err != nil {
-		http.Error(w, `{"error": "Invalid ID format"}`, http.StatusBadRequest)
+		renderError(w, r, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	mediaType, err := negotiate(r, false)
+	if err != nil {
+		renderNotAcceptable(w, r, false)
 		return
 	}
 
 	if h.DB == nil {
 		panic("h.DB is nil - database connection not initialized")
 	}
-	fmt.Println("Database connection initialized")
 
 	var book models.Book
-	if err := h.DB.First(&book, id).Error; err != nil {
-		http.Error(w, `{"message": "Book not found"}`, http.StatusNotFound)
+	if err := h.dbFor(r).First(&book, id).Error; err != nil {
+		renderMessage(w, r, http.StatusNotFound, "Book not found")
+		return
+	}
+
+	detail := bookDetail{Book: book}
+	if summary, _, err := h.bookCirculation(r, book.ID); err == nil {
+		detail.Circulation = &summary
+	} else {
+		// The book is still worth showing without its availability
+		logging.FromContext(r.Context()).Warn("error querying circulation", "book_id", book.ID, "error", err)
+	}
+
+	j, err := encodeBody(mediaType, detail)
+	if err != nil {
+		httpError(w, r, "Failed to marshal book", http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("Book found:", book)
 
-	j, err := jsonMarshal(book)
+	writeBody(w, http.StatusOK, mediaType, j)
+}
+
+// GetByISBN looks a book up by its ISBN-10 or ISBN-13, hyphens allowed
+func (h *Handler) GetByISBN(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	isbn13, err := isbn.Normalize(chi.URLParam(r, "isbn"))
 	if err != nil {
-		http.Error(w, "Failed to marshal book", http.StatusInternalServerError)
+		renderError(w, r, http.StatusBadRequest, "Invalid ISBN")
+		return
+	}
+
+	var book models.Book
+	if err := h.dbFor(r).First(&book, "isbn13 = ?", isbn13).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Book not found")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error querying book by ISBN", "isbn", isbn13, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(j)
+	render(w, r, http.StatusOK, book)
+}
+
+// conflictResponse points a client at the record its request collided with
+type conflictResponse struct {
+	XMLName   xml.Name `json:"-" xml:"response"`
+	Error     string   `json:"error" xml:"error"`
+	Existing  string   `json:"existing,omitempty" xml:"existing,omitempty"`
+	RequestID string   `json:"request_id,omitempty" xml:"request_id,omitempty"`
+}
+
+// renderDuplicateISBN answers 409 with a link to the book that already has
+// the ISBN
+func (h *Handler) renderDuplicateISBN(w http.ResponseWriter, r *http.Request, isbn13 string) {
+	body := conflictResponse{
+		Error:     "A book with ISBN " + isbn13 + " already exists",
+		RequestID: requestid.FromContext(r.Context()),
+	}
+	var existing models.Book
+	if err := h.dbFor(r).First(&existing, "isbn13 = ?", isbn13).Error; err == nil {
+		body.Existing = "/books/" + strconv.Itoa(existing.ID)
+		w.Header().Set("Location", body.Existing)
+	}
+	render(w, r, http.StatusConflict, body)
 }
 
 func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
@@ -113,25 +220,36 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 	bookIDParam := chi.URLParam(r, "id") // Ensure this matches the test case
 	bookID, err := strconv.Atoi(bookIDParam)
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		httpError(w, r, "Invalid book ID", http.StatusBadRequest)
+		return
+	}
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
 		return
 	}
 
 	// Find the book in the database
 	var book models.Book
-	if err := h.DB.First(&book, bookID).Error; err != nil {
+	if err := h.dbFor(r).First(&book, bookID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, `{"error": "Book not found"}`, http.StatusNotFound)
+			renderError(w, r, http.StatusNotFound, "Book not found")
 			return
 		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		httpError(w, r, "Database error", http.StatusInternalServerError)
 		return
 	}
 
 	// Decode request body
 	var updateData models.Book
-	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
-		http.Error(w, `{"error": "Invalid request payload"}`, http.StatusBadRequest)
+	if err := decodeBody(r, &updateData); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if err := updateData.NormalizeISBN(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -139,49 +257,71 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 	book.Name = updateData.Name
 	book.Description = updateData.Description
 	book.Author = updateData.Author
+	book.ISBN13 = updateData.ISBN13
+	book.ISBN10 = updateData.ISBN10
 
 	// Save updated book
-	if err := h.DB.Save(&book).Error; err != nil {
-		http.Error(w, "Failed to update book", http.StatusInternalServerError)
+	if err := h.dbFor(r).Save(&book).Error; err != nil {
+		if db.IsUniqueViolation(err) && book.ISBN13 != nil {
+			h.renderDuplicateISBN(w, r, *book.ISBN13)
+			return
+		}
+		httpError(w, r, "Failed to update book", http.StatusInternalServerError)
 		return
 	}
 
 	// Success response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book updated successfully"})
+	metrics.BooksUpdated.Inc()
+	renderMessage(w, r, http.StatusOK, "Book updated successfully")
 }
 
+// errBookLent refuses to delete a book whose copies have loans
+var errBookLent = errors.New("Book has copies with loans, it is kept for their lending history")
+
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	// Extract and validate book ID from URL
 	bookIDParam := chi.URLParam(r, "id") // Ensure this matches the URL parameter
 	bookID, err := strconv.Atoi(bookIDParam)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid book ID"}`, http.StatusBadRequest)
-		log.Printf("error parsing book ID from string to integer: %v", err)
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		logging.FromContext(r.Context()).Debug("error parsing book ID", "book_id", bookIDParam, "error", err)
+		return
+	}
+	if !acceptable(w, r, false) {
 		return
 	}
 
 	// Check if the book exists before attempting to delete
 	var book models.Book
-	if err := h.DB.First(&book, bookID).Error; err != nil {
+	if err := h.dbFor(r).First(&book, bookID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, `{"error": "Book not found"}`, http.StatusNotFound)
-			log.Printf("book with ID %d not found", bookID)
+			renderError(w, r, http.StatusNotFound, "Book not found")
+			logging.FromContext(r.Context()).Debug("book not found", "book_id", bookID)
 			return
 		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Printf("error querying book from database: %v", err)
+		httpError(w, r, "Database error", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("error querying book", "book_id", bookID, "error", err)
 		return
 	}
 
-	// Delete the book
-	if err := h.DB.Delete(&book).Error; err != nil {
-		http.Error(w, "Failed to delete book", http.StatusInternalServerError)
-		log.Printf("error deleting book with ID %d: %v", bookID, err)
+	// Delete the book. Rows that depend on it, such as its copies, reviews
+	// and shelf entries, are removed by their foreign keys; shelves close
+	// the gaps left in their order when they are next read. Copies that
+	// were ever lent out keep the book, as their loans restrict deletion.
+	if err := h.dbFor(r).Delete(&book).Error; err != nil {
+		if db.IsForeignKeyViolation(err) {
+			renderError(w, r, http.StatusConflict, errBookLent.Error())
+			return
+		}
+		httpError(w, r, "Failed to delete book", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("error deleting book", "book_id", bookID, "error", err)
 		return
 	}
 
+	// The cover row goes with the book, its images have to be removed here
+	h.deleteCoverBlobs(r.Context(), bookID)
+
 	// Success response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Book deleted successfully"})
+	metrics.BooksDeleted.Inc()
+	renderMessage(w, r, http.StatusOK, "Book deleted successfully")
 }
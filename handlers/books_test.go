@@ -15,6 +15,7 @@ import (
 	"connection_to_pg/models"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -141,6 +142,41 @@ func TestGetAll_DatabaseError(t *testing.T) {
 	mockDB.AssertExpectations(t)
 }
 
+func TestGetAll_WithFilters(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	// The filters are passed to Find as inline conditions
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), []interface{}{"author = ?", "Author One"}).
+		Return(&gorm.DB{})
+
+	req := httptest.NewRequest(http.MethodGet, "/books?author=Author+One", nil)
+	w := httptest.NewRecorder()
+
+	handler.GetAll(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetAll_Sorted(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	// Sorted lists are ordered by the database rather than read with Find
+	database, statement := dryRun(t)
+	mockDB.On("Model", &models.Book{}).Return(database)
+
+	req := httptest.NewRequest(http.MethodGet, "/books?author=Author+One&sort=rating", nil)
+	w := httptest.NewRecorder()
+
+	handler.GetAll(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, *statement, "WHERE author = $1 ORDER BY rating_average, id")
+	mockDB.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+}
+
 func TestGet_Success(t *testing.T) {
 	mockDB := new(mocks.MockDB)
 	book := models.Book{ID: 1, Name: "Test Book", Description: "A test book", Author: "Author Name"}
@@ -151,6 +187,7 @@ func TestGet_Success(t *testing.T) {
 			*argBook = book
 		}).
 		Return(&gorm.DB{}) // Return a valid *gorm.DB instance
+	expectCirculation(mockDB, nil, nil, nil)
 
 	handler := Handler{DB: mockDB}
 
@@ -224,6 +261,7 @@ func TestGet_JSONMarshallingError(t *testing.T) {
 			argBook := args.Get(0).(*models.Book)
 			*argBook = existingBook
 		}).Return(&gorm.DB{})
+	expectCirculation(mockDB, nil, nil, nil)
 
 	req, err := http.NewRequest("GET", "/books/"+strconv.Itoa(bookID), nil)
 	assert.NoError(t, err)
@@ -661,3 +699,33 @@ func TestDelete_FailedToDeleteBook(t *testing.T) {
 
 	mockDB.AssertExpectations(t)
 }
+
+func TestDelete_BookWithLoans(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := Handler{DB: mockDB}
+
+	bookID := 1
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), bookID).
+		Run(func(args mock.Arguments) {
+			args.Get(0).(*models.Book).ID = bookID
+		}).Return(&gorm.DB{})
+
+	// The loans of its copies restrict deleting the book
+	mockDB.On("Delete", mock.AnythingOfType("*models.Book")).
+		Return(&gorm.DB{Error: &pgconn.PgError{Code: "23503", ConstraintName: "fk_loans_copy"}})
+
+	req, err := http.NewRequest("DELETE", "/books/"+strconv.Itoa(bookID), nil)
+	assert.NoError(t, err)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(bookID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	handler.Delete(rr, req)
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.JSONEq(t, `{"error": "Book has copies with loans, it is kept for their lending history"}`, rr.Body.String())
+
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// categoryBody is the request payload for creating and updating categories
+type categoryBody struct {
+	Name     string `json:"name" xml:"name"`
+	ParentID *int   `json:"parent_id" xml:"parent_id"`
+}
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errParentNotFound   = errors.New("parent category not found")
+	errCategoryCycle    = errors.New("a category can't be moved below itself")
+)
+
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+
+	var body categoryBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		renderError(w, r, http.StatusBadRequest, "Category name is required")
+		return
+	}
+
+	category := models.Category{Name: body.Name, ParentID: body.ParentID}
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		parentPath, err := categoryParentPath(tx, body.ParentID)
+		if err != nil {
+			return err
+		}
+		// The path ends in the category's own ID, known only after the insert
+		category.Path = parentPath
+		if err := tx.Create(&category).Error; err != nil {
+			return err
+		}
+		category.Path = models.CategoryPath(parentPath, category.ID)
+		return tx.Model(&category).Update("path", category.Path).Error
+	})
+	if !h.categoryWritten(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", "/categories/"+strconv.Itoa(category.ID))
+	render(w, r, http.StatusCreated, category)
+}
+
+// categoryParentPath returns the path new children of parentID start with
+func categoryParentPath(tx *gorm.DB, parentID *int) (string, error) {
+	if parentID == nil {
+		return "/", nil
+	}
+	var parent models.Category
+	if err := tx.First(&parent, *parentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errParentNotFound
+		}
+		return "", err
+	}
+	return parent.Path, nil
+}
+
+// categoryWritten answers the request when a category transaction failed
+func (h *Handler) categoryWritten(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errCategoryNotFound):
+		renderError(w, r, http.StatusNotFound, "Category not found")
+	case errors.Is(err, errParentNotFound), errors.Is(err, errCategoryCycle):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("error writing category", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to save category")
+	}
+	return false
+}
+
+// ListCategories lists the categories in tree order, parents before their
+// children. With ?root=ID only that category's subtree is listed.
+func (h *Handler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+
+	var conds []interface{}
+	if root := r.URL.Query().Get("root"); root != "" {
+		id, err := strconv.Atoi(root)
+		if err != nil {
+			renderError(w, r, http.StatusBadRequest, "root must be a category ID")
+			return
+		}
+		var category models.Category
+		if err := h.dbFor(r).First(&category, id).Error; err != nil {
+			renderError(w, r, http.StatusNotFound, "Category not found")
+			return
+		}
+		conds = []interface{}{"path LIKE ?", escapeLike(category.Path) + "%"}
+	}
+
+	categories := []models.Category{}
+	if err := h.dbFor(r).Find(&categories, conds...).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying categories table", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve categories")
+		return
+	}
+	sortCategories(categories)
+
+	render(w, r, http.StatusOK, categories)
+}
+
+// sortCategories orders categories depth first by name
+func sortCategories(categories []models.Category) {
+	names := make(map[int]string, len(categories))
+	for _, c := range categories {
+		names[c.ID] = strings.ToLower(c.Name)
+	}
+	// Compare the name of every ancestor, falling back to the ID for
+	// ancestors outside the list
+	key := func(c models.Category) []string {
+		var parts []string
+		for _, id := range strings.Split(strings.Trim(c.Path, "/"), "/") {
+			n, _ := strconv.Atoi(id)
+			name, ok := names[n]
+			if !ok {
+				name = id
+			}
+			parts = append(parts, name+"\x00"+id)
+		}
+		return parts
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		a, b := key(categories[i]), key(categories[j])
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
+// findCategory loads the category named by the {id} parameter and answers
+// the request itself when that fails
+func (h *Handler) findCategory(w http.ResponseWriter, r *http.Request) (models.Category, bool) {
+	var category models.Category
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid category ID")
+		return category, false
+	}
+	if err := h.dbFor(r).First(&category, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Category not found")
+			return category, false
+		}
+		logging.FromContext(r.Context()).Error("error querying category", "category_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return category, false
+	}
+	return category, true
+}
+
+func (h *Handler) GetCategory(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	category, ok := h.findCategory(w, r)
+	if !ok {
+		return
+	}
+	render(w, r, http.StatusOK, category)
+}
+
+// UpdateCategory renames a category and moves it, with its subtree, below
+// another parent
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	var body categoryBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		renderError(w, r, http.StatusBadRequest, "Category name is required")
+		return
+	}
+
+	var category models.Category
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(lockForUpdate).First(&category, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errCategoryNotFound
+			}
+			return err
+		}
+		parentPath, err := categoryParentPath(tx, body.ParentID)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(parentPath, category.Path) {
+			return errCategoryCycle
+		}
+
+		oldPath := category.Path
+		category.Name = body.Name
+		category.ParentID = body.ParentID
+		category.Path = models.CategoryPath(parentPath, category.ID)
+		if err := tx.Save(&category).Error; err != nil {
+			return err
+		}
+		if oldPath == category.Path {
+			return nil
+		}
+		// Rewrite the path prefix of every descendant
+		return tx.Model(&models.Category{}).
+			Where("path LIKE ? AND id <> ?", escapeLike(oldPath)+"%", category.ID).
+			Update("path", gorm.Expr("? || substr(path, ?)", category.Path, len(oldPath)+1)).Error
+	})
+	if !h.categoryWritten(w, r, err) {
+		return
+	}
+
+	render(w, r, http.StatusOK, category)
+}
+
+// DeleteCategory removes a leaf category. Books filed under it are kept.
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	category, ok := h.findCategory(w, r)
+	if !ok {
+		return
+	}
+
+	var child models.Category
+	err := h.dbFor(r).First(&child, "parent_id = ?", category.ID).Error
+	if err == nil {
+		renderError(w, r, http.StatusConflict, "Category has subcategories, move or delete them first")
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if err := h.dbFor(r).Delete(&category).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error deleting category", "category_id", category.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete category")
+		return
+	}
+
+	renderMessage(w, r, http.StatusOK, "Category deleted successfully")
+}
+
+// GetBookCategories lists the categories a book is filed under
+func (h *Handler) GetBookCategories(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	categories := []models.Category{}
+	err := h.dbFor(r).Find(&categories, "id IN (SELECT category_id FROM book_categories WHERE book_id = ?)", book.ID).Error
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error querying book categories", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve categories")
+		return
+	}
+	sortCategories(categories)
+
+	render(w, r, http.StatusOK, categories)
+}
+
+// SetBookCategories files a book under exactly the category IDs in the body
+func (h *Handler) SetBookCategories(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	var ids []int
+	if err := decodeBody(r, &ids); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload, expected a list of category IDs")
+		return
+	}
+
+	var categories []models.Category
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var book models.Book
+		if err := tx.First(&book, bookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errBookNotFound
+			}
+			return err
+		}
+		if err := tx.Find(&categories, "id IN ?", ids).Error; err != nil {
+			return err
+		}
+		if missing := missingIDs(ids, categories); len(missing) > 0 {
+			return fmt.Errorf("%w: %v", errCategoryNotFound, missing)
+		}
+
+		if err := tx.Where("book_id = ?", bookID).Delete(&models.BookCategory{}).Error; err != nil {
+			return err
+		}
+		for _, category := range categories {
+			if err := tx.Create(&models.BookCategory{BookID: bookID, CategoryID: category.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBookNotFound):
+		renderError(w, r, http.StatusNotFound, "Book not found")
+		return
+	case errors.Is(err, errCategoryNotFound):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("error filing book", "book_id", bookID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update categories")
+		return
+	}
+
+	if categories == nil {
+		categories = []models.Category{}
+	}
+	sortCategories(categories)
+	render(w, r, http.StatusOK, categories)
+}
+
+// missingIDs returns the requested IDs no category was found for
+func missingIDs(ids []int, found []models.Category) []int {
+	present := make(map[int]bool, len(found))
+	for _, c := range found {
+		present[c.ID] = true
+	}
+	var missing []int
+	for _, id := range ids {
+		if !present[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestBookFilters_Category(t *testing.T) {
+	filters := parseBookFilters(url.Values{"category": {"4"}})
+
+	assert.NoError(t, filters.validate())
+	assert.Equal(t, []interface{}{
+		"id IN (SELECT bc.book_id FROM book_categories bc JOIN categories c ON c.id = bc.category_id" +
+			" WHERE c.path LIKE (SELECT path FROM categories WHERE id = ?) || '%')",
+		4,
+	}, filters.conds())
+
+	assert.EqualError(t, parseBookFilters(url.Values{"category": {"fiction"}}).validate(), "category must be a category ID")
+}
+
+func TestGetAll_InvalidCategory(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	w := httptest.NewRecorder()
+	handler.GetAll(w, httptest.NewRequest(http.MethodGet, "/books?category=fiction", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+}
+
+func TestSortCategories(t *testing.T) {
+	categories := []models.Category{
+		{ID: 5, Name: "Space opera", Path: "/2/5/"},
+		{ID: 1, Name: "Non-fiction", Path: "/1/"},
+		{ID: 2, Name: "Fiction", Path: "/2/"},
+		{ID: 3, Name: "Fantasy", Path: "/2/3/"},
+	}
+
+	sortCategories(categories)
+
+	var names []string
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"Fiction", "Fantasy", "Space opera", "Non-fiction"}, names)
+	assert.Equal(t, 1, categories[1].Depth())
+}
+
+func TestCreateCategory_NameRequired(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	w := httptest.NewRecorder()
+	handler.CreateCategory(w, httptest.NewRequest(http.MethodPost, "/categories", strings.NewReader(`{"name":"  "}`)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+}
+
+func TestUpdateCategory_TransactionErrors(t *testing.T) {
+	cases := map[error]int{
+		errCategoryNotFound: http.StatusNotFound,
+		errParentNotFound:   http.StatusUnprocessableEntity,
+		errCategoryCycle:    http.StatusUnprocessableEntity,
+	}
+	for err, status := range cases {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+		mockDB.On("Transaction", mock.Anything).Return(err)
+
+		req := withID(httptest.NewRequest(http.MethodPut, "/categories/2", strings.NewReader(`{"name":"Fiction","parent_id":5}`)), "2")
+		w := httptest.NewRecorder()
+		handler.UpdateCategory(w, req)
+
+		assert.Equal(t, status, w.Code, err.Error())
+	}
+}
+
+func TestDeleteCategory_WithChildren(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.Category"), 2).Return(&gorm.DB{})
+	mockDB.On("First", mock.AnythingOfType("*models.Category"), "parent_id = ?").Return(&gorm.DB{})
+
+	w := httptest.NewRecorder()
+	handler.DeleteCategory(w, withID(httptest.NewRequest(http.MethodDelete, "/categories/2", nil), "2"))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	mockDB.AssertNotCalled(t, "Delete", mock.Anything)
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"bytes"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"connection_to_pg/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultMaxCoverBytes limits cover uploads when the handler sets no limit
+	defaultMaxCoverBytes = 5 << 20
+	// maxCoverPixels guards against small files that decode to huge images
+	maxCoverPixels = 40_000_000
+	// coverCacheControl lets clients reuse a cover for an hour before they
+	// revalidate it with its ETag
+	coverCacheControl = "public, max-age=3600"
+)
+
+// coverDecoders lists the image types accepted as covers
+var coverDecoders = map[string]func(io.Reader) (image.Image, error){
+	"image/jpeg": jpeg.Decode,
+	"image/png":  png.Decode,
+	"image/gif":  gif.Decode,
+}
+
+var (
+	errCoverMissing  = errors.New(`multipart upload has no "file" part`)
+	errCoverTooLarge = errors.New("cover image is too large")
+)
+
+// PutCover stores the image in the "file" part of a multipart upload as the
+// book's cover, replacing any previous cover, and generates its thumbnails
+func (h *Handler) PutCover(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if h.Blobs == nil {
+		renderError(w, r, http.StatusServiceUnavailable, "Cover storage is not configured")
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	maxBytes := h.MaxCoverBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxCoverBytes
+	}
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	data, err := readCoverUpload(r, maxBytes)
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		renderError(w, r, http.StatusUnsupportedMediaType, "Cover must be uploaded as multipart/form-data")
+		return
+	case errors.Is(err, errCoverTooLarge), errors.As(err, &maxBytesErr):
+		renderError(w, r, http.StatusRequestEntityTooLarge,
+			"Cover image must not be larger than "+strconv.FormatInt(maxBytes, 10)+" bytes")
+		return
+	case err != nil:
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Trust the bytes, not the type the client declared
+	contentType := http.DetectContentType(data)
+	decode, ok := coverDecoders[contentType]
+	if !ok {
+		renderError(w, r, http.StatusUnsupportedMediaType, "Cover must be a JPEG, PNG or GIF image")
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		renderError(w, r, http.StatusUnprocessableEntity, "Cover image could not be decoded")
+		return
+	}
+	if config.Width*config.Height > maxCoverPixels {
+		renderError(w, r, http.StatusUnprocessableEntity, "Cover image has too many pixels")
+		return
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		renderError(w, r, http.StatusUnprocessableEntity, "Cover image could not be decoded")
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	cover := models.Cover{
+		BookID:        book.ID,
+		ContentType:   contentType,
+		ThumbnailType: thumbnailType(contentType),
+		Size:          int64(len(data)),
+		Width:         config.Width,
+		Height:        config.Height,
+		ETag:          hex.EncodeToString(sum[:]),
+	}
+
+	ctx := r.Context()
+	if err := h.Blobs.Put(ctx, models.CoverKey(book.ID, "original"), bytes.NewReader(data)); err != nil {
+		logging.FromContext(ctx).Error("error storing cover", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to store cover")
+		return
+	}
+	for size, bound := range models.CoverSizes {
+		var buf bytes.Buffer
+		if err := encodeThumbnail(&buf, thumbnail(img, bound), cover.ThumbnailType); err != nil {
+			logging.FromContext(ctx).Error("error encoding thumbnail", "book_id", book.ID, "size", size, "error", err)
+			renderError(w, r, http.StatusInternalServerError, "Failed to create thumbnails")
+			return
+		}
+		if err := h.Blobs.Put(ctx, models.CoverKey(book.ID, size), &buf); err != nil {
+			logging.FromContext(ctx).Error("error storing thumbnail", "book_id", book.ID, "size", size, "error", err)
+			renderError(w, r, http.StatusInternalServerError, "Failed to store cover")
+			return
+		}
+	}
+
+	if err := h.dbFor(r).Save(&cover).Error; err != nil {
+		logging.FromContext(ctx).Error("error saving cover", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to save cover")
+		return
+	}
+
+	render(w, r, http.StatusOK, cover)
+}
+
+// readCoverUpload returns the content of the "file" part, failing with
+// errCoverTooLarge once it exceeds maxBytes
+func readCoverUpload(r *http.Request, maxBytes int64) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, errUnsupportedMediaType
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errCoverMissing
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > maxBytes {
+			return nil, errCoverTooLarge
+		}
+		return data, nil
+	}
+}
+
+// thumbnailType keeps PNG thumbnails for PNG covers, whose transparency
+// JPEG can't hold, and uses JPEG otherwise
+func thumbnailType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// thumbnail scales img down so its longest side is at most bound pixels.
+// Images that already fit are returned unchanged.
+func thumbnail(img image.Image, bound int) image.Image {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= bound && height <= bound {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*bound/width)
+		width = bound
+	} else {
+		width = max(1, width*bound/height)
+		height = bound
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+func encodeThumbnail(w io.Writer, img image.Image, contentType string) error {
+	if contentType == "image/jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(w, img)
+}
+
+// GetCover serves the original cover image of a book
+func (h *Handler) GetCover(w http.ResponseWriter, r *http.Request) {
+	h.serveCover(w, r, "original")
+}
+
+// GetCoverThumbnail serves a thumbnail of the cover in the {size} parameter
+func (h *Handler) GetCoverThumbnail(w http.ResponseWriter, r *http.Request) {
+	size := chi.URLParam(r, "size")
+	if _, ok := models.CoverSizes[size]; !ok {
+		renderError(w, r, http.StatusNotFound, "Unknown cover size, use small or medium")
+		return
+	}
+	h.serveCover(w, r, size)
+}
+
+// serveCover writes a stored cover image with caching headers. Clients
+// holding the current ETag get 304 Not Modified without touching storage.
+func (h *Handler) serveCover(w http.ResponseWriter, r *http.Request, size string) {
+	if h.Blobs == nil {
+		renderError(w, r, http.StatusServiceUnavailable, "Cover storage is not configured")
+		return
+	}
+	cover, ok := h.findCover(w, r)
+	if !ok {
+		return
+	}
+
+	etag := `"` + cover.ETag + `"`
+	contentType := cover.ContentType
+	if size != "original" {
+		etag = `"` + cover.ETag + "-" + size + `"`
+		contentType = cover.ThumbnailType
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", coverCacheControl)
+	w.Header().Set("Last-Modified", cover.UpdatedAt.UTC().Format(http.TimeFormat))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := h.Blobs.Open(r.Context(), models.CoverKey(cover.BookID, size))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			renderError(w, r, http.StatusNotFound, "Cover not found")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error opening cover", "book_id", cover.BookID, "size", size, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to read cover")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	// Seekable blobs get range requests and Content-Length for free
+	if seeker, ok := blob.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", cover.UpdatedAt, seeker)
+		return
+	}
+	if size == "original" {
+		w.Header().Set("Content-Length", strconv.FormatInt(cover.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, blob); err != nil {
+		logging.FromContext(r.Context()).Warn("error writing cover", "book_id", cover.BookID, "error", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// findCover loads the cover of the book named by the {id} parameter and
+// answers the request itself when that fails
+func (h *Handler) findCover(w http.ResponseWriter, r *http.Request) (models.Cover, bool) {
+	var cover models.Cover
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return cover, false
+	}
+	if err := h.dbFor(r).First(&cover, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Book has no cover")
+			return cover, false
+		}
+		logging.FromContext(r.Context()).Error("error querying cover", "book_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return cover, false
+	}
+	return cover, true
+}
+
+// DeleteCover removes a book's cover and its thumbnails
+func (h *Handler) DeleteCover(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if h.Blobs == nil {
+		renderError(w, r, http.StatusServiceUnavailable, "Cover storage is not configured")
+		return
+	}
+	cover, ok := h.findCover(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.dbFor(r).Delete(&cover).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error deleting cover", "book_id", cover.BookID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete cover")
+		return
+	}
+	h.deleteCoverBlobs(r.Context(), cover.BookID)
+
+	renderMessage(w, r, http.StatusOK, "Cover deleted successfully")
+}
+
+// deleteCoverBlobs removes the stored images of a book's cover. Failures
+// only leave unreachable files behind, so they are logged and ignored.
+func (h *Handler) deleteCoverBlobs(ctx context.Context, bookID int) {
+	if h.Blobs == nil {
+		return
+	}
+	keys := []string{models.CoverKey(bookID, "original")}
+	for size := range models.CoverSizes {
+		keys = append(keys, models.CoverKey(bookID, size))
+	}
+	for _, key := range keys {
+		if err := h.Blobs.Delete(ctx, key); err != nil {
+			logging.FromContext(ctx).Warn("error deleting cover image", "key", key, "error", err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"connection_to_pg/storage"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// coverUpload builds a multipart request carrying content as the "file" part
+func coverUpload(t *testing.T, content []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "cover.png")
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPut, "/books/1/cover", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return withID(req, "1")
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestPutCover(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	blobs, err := storage.NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+	handler := &Handler{DB: mockDB, Blobs: blobs}
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 1).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Book).ID = 1
+	}).Return(&gorm.DB{})
+	mockDB.On("Save", mock.AnythingOfType("*models.Cover")).Return(&gorm.DB{})
+
+	w := httptest.NewRecorder()
+	handler.PutCover(w, coverUpload(t, testPNG(t, 800, 400)))
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"content_type":"image/png"`)
+	assert.Contains(t, w.Body.String(), `"width":800`)
+
+	for size, want := range map[string]image.Point{"small": {150, 75}, "medium": {400, 200}} {
+		blob, err := blobs.Open(context.Background(), models.CoverKey(1, size))
+		require.NoError(t, err)
+		config, format, err := image.DecodeConfig(blob)
+		blob.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "png", format)
+		assert.Equal(t, want, image.Pt(config.Width, config.Height), size)
+	}
+}
+
+func TestPutCover_Rejected(t *testing.T) {
+	blobs, err := storage.NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		content []byte
+		status  int
+	}{
+		{"not an image", []byte("%PDF-1.4 definitely a cover"), http.StatusUnsupportedMediaType},
+		{"too large", testPNG(t, 300, 300), http.StatusRequestEntityTooLarge},
+		{"truncated", testPNG(t, 300, 300)[:200], http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(mocks.MockDB)
+			handler := &Handler{DB: mockDB, Blobs: blobs, MaxCoverBytes: 1000}
+			mockDB.On("First", mock.AnythingOfType("*models.Book"), 1).Return(&gorm.DB{})
+
+			w := httptest.NewRecorder()
+			handler.PutCover(w, coverUpload(t, tt.content))
+
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			mockDB.AssertNotCalled(t, "Save", mock.Anything)
+		})
+	}
+}
+
+func TestGetCover(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	blobs, err := storage.NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, blobs.Put(context.Background(), models.CoverKey(1, "small"), bytes.NewReader([]byte("thumb"))))
+	handler := &Handler{DB: mockDB, Blobs: blobs}
+	mockDB.On("First", mock.AnythingOfType("*models.Cover"), 1).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Cover) = models.Cover{
+			BookID: 1, ContentType: "image/png", ThumbnailType: "image/png", ETag: "abc",
+			UpdatedAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}).Return(&gorm.DB{})
+
+	req := withID(httptest.NewRequest(http.MethodGet, "/books/1/cover/small", nil), "1")
+	req = withURLParam(req, "size", "small")
+	w := httptest.NewRecorder()
+	handler.GetCoverThumbnail(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "thumb", w.Body.String())
+	assert.Equal(t, `"abc-small"`, w.Header().Get("ETag"))
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, coverCacheControl, w.Header().Get("Cache-Control"))
+
+	req = withID(httptest.NewRequest(http.MethodGet, "/books/1/cover", nil), "1")
+	req.Header.Set("If-None-Match", `"abc"`)
+	w = httptest.NewRecorder()
+	handler.GetCover(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+// withURLParam adds a route parameter to a request prepared by withID
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	chi.RouteContext(req.Context()).URLParams.Add(key, value)
+	return req
+}
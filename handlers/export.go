@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/transfer"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Export streams the books selected by the list filters as CSV, NDJSON or
+// a JSON array. Rows are read through a database cursor and flushed to the
+// client batch by batch, so the table never has to fit in memory.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = transfer.FormatJSON
+	}
+	encoder, err := transfer.NewEncoder(w, format)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "format must be one of csv, ndjson or json")
+		return
+	}
+
+	filters := parseBookFilters(r.URL.Query())
+	if err := filters.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	query := filters.query(h.dbFor(r))
+
+	filename := fmt.Sprintf("books-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", transfer.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+
+	count, err := transfer.Export(query, encoder, transfer.DefaultBatchSize, flush)
+	if err != nil {
+		// Once rows have been written the status line is gone; the client
+		// sees a truncated document and the connection is cut short.
+		logging.FromContext(r.Context()).Error("error exporting books", "rows", count, "error", err)
+		if count == 0 {
+			w.Header().Del("Content-Disposition")
+			renderError(w, r, http.StatusInternalServerError, "Failed to export books")
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestExport_InvalidFormat(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := httptest.NewRequest(http.MethodGet, "/books/export?format=xlsx", nil)
+	rr := httptest.NewRecorder()
+
+	handler.Export(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"error": "format must be one of csv, ndjson or json"}`, rr.Body.String())
+	mockDB.AssertNotCalled(t, "Model", mock.Anything)
+}
+
+func TestBookFilters_Conds(t *testing.T) {
+	assert.Nil(t, parseBookFilters(url.Values{}).conds())
+
+	conds := parseBookFilters(url.Values{"author": {"Jane Austen"}, "q": {"50%"}}).conds()
+	assert.Equal(t, []interface{}{
+		"author = ? AND (name ILIKE ? OR description ILIKE ? OR author ILIKE ?)",
+		"Jane Austen", `%50\%%`, `%50\%%`, `%50\%%`,
+	}, conds)
+}
+
+// dryRun returns a database that builds statements without sending them,
+// and the SQL of the last query it built
+func dryRun(t *testing.T) (*gorm.DB, *string) {
+	t.Helper()
+	database, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	require.NoError(t, err)
+	var statement string
+	capture := func(tx *gorm.DB) { statement = tx.Statement.SQL.String() }
+	require.NoError(t, database.Callback().Query().After("gorm:query").Register("test:capture", capture))
+	require.NoError(t, database.Callback().Row().After("gorm:row").Register("test:capture", capture))
+	return database, &statement
+}
+
+func TestExport_Sorted(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	database, statement := dryRun(t)
+	mockDB.On("Model", &models.Book{}).Return(database)
+
+	handler.Export(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/books/export?author=Jane+Austen&sort=-reviews", nil))
+	assert.Contains(t, *statement, "WHERE author = $1 ORDER BY rating_count DESC, id")
+
+	handler.Export(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/books/export", nil))
+	assert.Contains(t, *statement, "ORDER BY id")
+}
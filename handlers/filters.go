@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"connection_to_pg/models"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Values of the tag_match filter
+const (
+	tagMatchAny = "any"
+	tagMatchAll = "all"
+)
+
+// bookFilters are the query parameters shared by the list and export endpoints
+type bookFilters struct {
+	Author string
+	Name   string
+	Query  string
+	// Category selects books filed under the category or any descendant
+	Category string
+	// Tags selects books carrying any, or with TagMatch "all" every, tag
+	Tags     []string
+	TagMatch string
+	// MinRating selects rated books with at least this average rating
+	MinRating string
+	// Sort orders the list by rating or review count, see bookSorts
+	Sort string
+}
+
+// bookSorts maps the values of the sort parameter to the ORDER BY they
+// select, on the indexed rating columns. Ties are broken by ID.
+var bookSorts = map[string]string{
+	"rating":   "rating_average, id",
+	"-rating":  "rating_average DESC, id",
+	"reviews":  "rating_count, id",
+	"-reviews": "rating_count DESC, id",
+}
+
+func parseBookFilters(values url.Values) bookFilters {
+	var tags []string
+	for _, value := range values["tags"] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = models.NormalizeTag(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return bookFilters{
+		Author:    strings.TrimSpace(values.Get("author")),
+		Name:      strings.TrimSpace(values.Get("name")),
+		Query:     strings.TrimSpace(values.Get("q")),
+		Category:  strings.TrimSpace(values.Get("category")),
+		Tags:      tags,
+		TagMatch:  strings.TrimSpace(values.Get("tag_match")),
+		MinRating: strings.TrimSpace(values.Get("min_rating")),
+		Sort:      strings.TrimSpace(values.Get("sort")),
+	}
+}
+
+// validate reports filters that can't be turned into conditions
+func (f bookFilters) validate() error {
+	if f.Category != "" {
+		if _, err := strconv.Atoi(f.Category); err != nil {
+			return errors.New("category must be a category ID")
+		}
+	}
+	switch f.TagMatch {
+	case "", tagMatchAny, tagMatchAll:
+	default:
+		return errors.New(`tag_match must be "any" or "all"`)
+	}
+	if f.MinRating != "" {
+		rating, err := strconv.ParseFloat(f.MinRating, 64)
+		if err != nil || rating < models.MinRating || rating > models.MaxRating {
+			return errors.New("min_rating must be a number from 1 to 5")
+		}
+	}
+	if _, ok := bookSorts[f.Sort]; f.Sort != "" && !ok {
+		return errors.New("sort must be one of rating, -rating, reviews or -reviews")
+	}
+	return nil
+}
+
+// conds returns the filters as inline GORM conditions, a query string
+// followed by its arguments. It is empty when no filter is set.
+func (f bookFilters) conds() []interface{} {
+	var clauses []string
+	var args []interface{}
+
+	if f.Author != "" {
+		clauses = append(clauses, "author = ?")
+		args = append(args, f.Author)
+	}
+	if f.Name != "" {
+		clauses = append(clauses, "name = ?")
+		args = append(args, f.Name)
+	}
+	if f.Query != "" {
+		pattern := "%" + escapeLike(f.Query) + "%"
+		clauses = append(clauses, "(name ILIKE ? OR description ILIKE ? OR author ILIKE ?)")
+		args = append(args, pattern, pattern, pattern)
+	}
+	if id, err := strconv.Atoi(f.Category); err == nil {
+		// Descendants share the category's path as a prefix
+		clauses = append(clauses, "id IN (SELECT bc.book_id FROM book_categories bc JOIN categories c ON c.id = bc.category_id"+
+			" WHERE c.path LIKE (SELECT path FROM categories WHERE id = ?) || '%')")
+		args = append(args, id)
+	}
+	if len(f.Tags) > 0 {
+		query := "id IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name IN ?"
+		args = append(args, f.Tags)
+		if f.TagMatch == tagMatchAll {
+			query += " GROUP BY bt.book_id HAVING COUNT(DISTINCT t.id) = ?"
+			args = append(args, distinctCount(f.Tags))
+		}
+		clauses = append(clauses, query+")")
+	}
+	if rating, err := strconv.ParseFloat(f.MinRating, 64); err == nil {
+		clauses = append(clauses, "rating_count > 0 AND rating_average >= ?")
+		args = append(args, rating)
+	}
+
+	if len(clauses) == 0 {
+		return nil
+	}
+	return append([]interface{}{strings.Join(clauses, " AND ")}, args...)
+}
+
+// query returns the books of database matching the filters, ordered as
+// Sort asks or by ID
+func (f bookFilters) query(database Database) *gorm.DB {
+	query := database.Model(&models.Book{})
+	if conds := f.conds(); len(conds) > 0 {
+		query = query.Where(conds[0], conds[1:]...)
+	}
+	if order, ok := bookSorts[f.Sort]; ok {
+		return query.Order(order)
+	}
+	return query.Order("id")
+}
+
+// find loads the books matching the filters into books. Unsorted lists
+// are read with inline conditions, which the book cache can answer.
+func (f bookFilters) find(database Database, books *[]models.Book) error {
+	if f.Sort == "" {
+		return database.Find(books, f.conds()...).Error
+	}
+	return f.query(database).Find(books).Error
+}
+
+// escapeLike escapes the LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func distinctCount(values []string) int {
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		seen[v] = true
+	}
+	return len(seen)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fineBody is the request payload for payments and waivers
+type fineBody struct {
+	Amount int64  `json:"amount_cents" xml:"amount_cents"`
+	Note   string `json:"note" xml:"note"`
+}
+
+// fineAccount is a member's fine balance and ledger
+type fineAccount struct {
+	MemberID int   `json:"member_id" xml:"member_id"`
+	Balance  int64 `json:"balance_cents" xml:"balance_cents"`
+	// Accruing is what the member's overdue loans would be charged if they
+	// were returned now; it is not part of the balance yet
+	Accruing int64 `json:"accruing_cents" xml:"accruing_cents"`
+	// Blocked tells whether the balance stops the member borrowing
+	Blocked bool               `json:"blocked" xml:"blocked"`
+	Entries []models.FineEntry `json:"entries" xml:"entries>entry"`
+}
+
+var (
+	errFinesOwed = errors.New("member owes fines above the checkout limit")
+	errOverpaid  = errors.New("amount exceeds the outstanding balance")
+	errNoNote    = errors.New("a note explaining the waiver is required")
+	errBadAmount = errors.New("amount_cents must be positive")
+	errNoBalance = errors.New("member has no outstanding balance")
+)
+
+// fineBalance sums a member's ledger
+func fineBalance(tx *gorm.DB, memberID int) (int64, error) {
+	var balance int64
+	err := tx.Model(&models.FineEntry{}).Where("member_id = ?", memberID).Select("COALESCE(SUM(amount), 0)").Scan(&balance).Error
+	return balance, err
+}
+
+// chargeLateReturn appends the fine for a returned loan to the ledger. It
+// returns nil when the copy came back in time or within the grace period.
+func chargeLateReturn(tx *gorm.DB, policy models.FinePolicy, loan models.Loan) (*models.FineEntry, error) {
+	amount := policy.Fine(loan.DueAt, *loan.ReturnedAt)
+	if amount == 0 {
+		return nil, nil
+	}
+	entry := models.FineEntry{
+		MemberID: loan.MemberID,
+		LoanID:   &loan.ID,
+		Kind:     models.FineCharge,
+		Amount:   amount,
+		Note:     fmt.Sprintf("returned %d days late", models.DaysLate(loan.DueAt, *loan.ReturnedAt)),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetMemberFines shows a member's balance and ledger, oldest entry first
+func (h *Handler) GetMemberFines(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	member, ok := h.findMember(w, r)
+	if !ok {
+		return
+	}
+	logger := logging.FromContext(r.Context())
+
+	entries := []models.FineEntry{}
+	if err := h.dbFor(r).Find(&entries, "member_id = ?", member.ID).Error; err != nil {
+		logger.Error("error querying fine ledger", "member_id", member.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve fines")
+		return
+	}
+	var loans []models.Loan
+	if err := h.dbFor(r).Find(&loans, "member_id = ? AND returned_at IS NULL", member.ID).Error; err != nil {
+		logger.Error("error querying member loans", "member_id", member.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve fines")
+		return
+	}
+
+	render(w, r, http.StatusOK, h.fineAccount(member.ID, entries, loans, clock()))
+}
+
+// fineAccount totals the ledger and the fines accruing on open loans
+func (h *Handler) fineAccount(memberID int, entries []models.FineEntry, openLoans []models.Loan, now time.Time) fineAccount {
+	account := fineAccount{MemberID: memberID, Entries: entries}
+	for _, entry := range entries {
+		account.Balance += entry.Amount
+	}
+	for _, loan := range openLoans {
+		account.Accruing += h.Fines.Fine(loan.DueAt, now)
+	}
+	account.Blocked = account.Balance > h.Fines.BlockThreshold
+	sortFineEntries(account.Entries)
+	return account
+}
+
+// RecordPayment books a payment against a member's balance
+func (h *Handler) RecordPayment(w http.ResponseWriter, r *http.Request) {
+	h.recordCredit(w, r, models.FinePayment)
+}
+
+// RecordWaiver forgives part or all of a member's balance. A note saying
+// why is required.
+func (h *Handler) RecordWaiver(w http.ResponseWriter, r *http.Request) {
+	h.recordCredit(w, r, models.FineWaiver)
+}
+
+// recordCredit appends a payment or waiver. The member row is locked so
+// concurrent credits can't take the balance below zero.
+func (h *Handler) recordCredit(w http.ResponseWriter, r *http.Request, kind string) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	memberID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid member ID")
+		return
+	}
+	var body fineBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	body.Note = strings.TrimSpace(body.Note)
+	switch {
+	case body.Amount <= 0:
+		renderError(w, r, http.StatusBadRequest, errBadAmount.Error())
+		return
+	case kind == models.FineWaiver && body.Note == "":
+		renderError(w, r, http.StatusBadRequest, errNoNote.Error())
+		return
+	}
+
+	fresh := models.FineEntry{MemberID: memberID, Kind: kind, Amount: -body.Amount, Note: body.Note}
+	entry := fresh
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		// A run again starts over from the entry as requested
+		entry = fresh
+		var member models.Member
+		if err := tx.Clauses(lockForUpdate).First(&member, memberID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errMemberNotFound
+			}
+			return err
+		}
+		balance, err := fineBalance(tx, member.ID)
+		switch {
+		case err != nil:
+			return err
+		case balance <= 0:
+			return errNoBalance
+		case body.Amount > balance:
+			return errOverpaid
+		}
+		return tx.Create(&entry).Error
+	})
+	switch {
+	case errors.Is(err, errMemberNotFound):
+		renderError(w, r, http.StatusNotFound, "Member not found")
+		return
+	case errors.Is(err, errNoBalance), errors.Is(err, errOverpaid):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("error recording "+kind, "member_id", memberID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to record "+kind)
+		return
+	}
+
+	render(w, r, http.StatusCreated, entry)
+}
+
+// sortFineEntries orders a ledger oldest first
+func sortFineEntries(entries []models.FineEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestGetMemberFines(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	fixClock(t, now)
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB, Fines: models.FinePolicy{DailyRate: 25, MaxFine: 1000, BlockThreshold: 500}}
+	mockDB.On("First", mock.AnythingOfType("*models.Member"), 3).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Member).ID = 3
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.FineEntry"), []interface{}{"member_id = ?", 3}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.FineEntry) = []models.FineEntry{
+			{ID: 2, MemberID: 3, Kind: models.FinePayment, Amount: -200, CreatedAt: now.Add(-time.Hour)},
+			{ID: 1, MemberID: 3, Kind: models.FineCharge, Amount: 900, CreatedAt: now.Add(-48 * time.Hour)},
+		}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Loan"), []interface{}{"member_id = ? AND returned_at IS NULL", 3}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Loan) = []models.Loan{{ID: 8, DueAt: now.Add(-50 * time.Hour)}}
+	}).Return(&gorm.DB{})
+
+	w := httptest.NewRecorder()
+	handler.GetMemberFines(w, withID(httptest.NewRequest(http.MethodGet, "/members/3/fines", nil), "3"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"member_id": 3, "balance_cents": 700, "accruing_cents": 75, "blocked": true,
+		"entries": [
+			{"id": 1, "member_id": 3, "kind": "charge", "amount_cents": 900, "created_at": "2024-03-08T12:00:00Z"},
+			{"id": 2, "member_id": 3, "kind": "payment", "amount_cents": -200, "created_at": "2024-03-10T11:00:00Z"}
+		]}`, w.Body.String())
+}
+
+func TestRecordCredit_Validation(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	w := httptest.NewRecorder()
+	handler.RecordPayment(w, withID(httptest.NewRequest(http.MethodPost, "/members/3/payments", strings.NewReader(`{"amount_cents":0}`)), "3"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.RecordWaiver(w, withID(httptest.NewRequest(http.MethodPost, "/members/3/waivers", strings.NewReader(`{"amount_cents":100}`)), "3"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"a note explaining the waiver is required"}`, w.Body.String())
+
+	mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+}
+
+func TestRecordPayment_Overpaid(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Transaction", mock.Anything).Return(errOverpaid)
+
+	w := httptest.NewRecorder()
+	handler.RecordPayment(w, withID(httptest.NewRequest(http.MethodPost, "/members/3/payments", strings.NewReader(`{"amount_cents":5000}`)), "3"))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// defaultGraphQLConfig applies to the zero fields of Handler.GraphQLLimits
+var defaultGraphQLConfig = models.GraphQLConfig{MaxDepth: 10, MaxComplexity: 1000}
+
+// graphqlListSize is what a list field without a first argument is
+// assumed to return when estimating the complexity of a query
+const graphqlListSize = 10
+
+// graphqlListFields are the list fields without a first argument
+var graphqlListFields = map[string]bool{
+	"credits": true, "categories": true, "tags": true, "shelves": true, "entries": true,
+}
+
+// graphqlParams is a GraphQL request as sent in a POST body or GET query
+type graphqlParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlResponse holds only errors, for requests that failed before
+// execution
+type graphqlResponse struct {
+	Errors []gqlerrors.FormattedError `json:"errors"`
+}
+
+// writeGraphQL writes a GraphQL response body
+func writeGraphQL(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// graphqlFailed answers a request that can't be executed
+func graphqlFailed(w http.ResponseWriter, status int, errs ...error) {
+	writeGraphQL(w, status, graphqlResponse{Errors: gqlerrors.FormatErrors(errs...)})
+}
+
+// parseGraphQLParams reads the query from the JSON body of a POST or the
+// parameters of a GET
+func parseGraphQLParams(r *http.Request) (graphqlParams, error) {
+	var params graphqlParams
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		params.Query = query.Get("query")
+		params.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+				return params, errors.New("variables must be a JSON object")
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return params, errors.New("Invalid request body")
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		return params, errors.New("query is required")
+	}
+	return params, nil
+}
+
+// selectOperation returns the operation of document a request runs
+func selectOperation(document *ast.Document, name string) (*ast.OperationDefinition, error) {
+	var selected *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if selected != nil {
+				return nil, errors.New("operationName is required for a document with several operations")
+			}
+			selected = operation
+		} else if operation.Name != nil && operation.Name.Value == name {
+			selected = operation
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("unknown operation %q", name)
+	}
+	return selected, nil
+}
+
+// queryCost measures how deep an operation nests and estimates how many
+// fields it resolves, before any of them is
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// measure returns the depth and complexity of the selections in set, which
+// are at the given depth. Introspection fields are free.
+func (c queryCost) measure(set *ast.SelectionSet, depth int) (int, int) {
+	maxDepth, complexity := depth, 0
+	if set == nil {
+		return maxDepth, complexity
+	}
+	for _, selection := range set.Selections {
+		var childDepth, childComplexity int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			childDepth, childComplexity = c.measure(selection.SelectionSet, depth+1)
+			childComplexity = 1 + c.multiplier(selection)*childComplexity
+		case *ast.InlineFragment:
+			childDepth, childComplexity = c.measure(selection.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			// Validation has ruled out fragment cycles
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				childDepth, childComplexity = c.measure(fragment.SelectionSet, depth)
+			}
+		}
+		maxDepth = max(maxDepth, childDepth)
+		complexity += childComplexity
+	}
+	return maxDepth, complexity
+}
+
+// multiplier is the number of items a field may return its selections for
+func (c queryCost) multiplier(field *ast.Field) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "first" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				return max(n, 1)
+			}
+		case *ast.Variable:
+			switch n := c.variables[value.Name.Value].(type) {
+			case float64:
+				return max(int(n), 1)
+			case int:
+				return max(n, 1)
+			}
+		}
+		return maxGraphQLPage
+	}
+	switch field.Name.Value {
+	case "books":
+		return defaultGraphQLPage
+	case "reviews":
+		return graphqlListSize
+	}
+	if graphqlListFields[field.Name.Value] {
+		return graphqlListSize
+	}
+	return 1
+}
+
+// checkLimits refuses operations nested deeper or estimated costlier than
+// limits allow
+func checkLimits(document *ast.Document, operation *ast.OperationDefinition, variables map[string]interface{}, limits models.GraphQLConfig) error {
+	cost := queryCost{fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			cost.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	depth, complexity := cost.measure(operation.SelectionSet, 0)
+	if depth > limits.MaxDepth {
+		return fmt.Errorf("query is nested %d levels deep, the limit is %d", depth, limits.MaxDepth)
+	}
+	if complexity > limits.MaxComplexity {
+		return fmt.Errorf("query complexity is %d, the limit is %d", complexity, limits.MaxComplexity)
+	}
+	return nil
+}
+
+// graphqlLimits returns the configured limits, defaults filling the gaps
+func (h *Handler) graphqlLimits() models.GraphQLConfig {
+	limits := h.GraphQLLimits
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = defaultGraphQLConfig.MaxDepth
+	}
+	if limits.MaxComplexity <= 0 {
+		limits.MaxComplexity = defaultGraphQLConfig.MaxComplexity
+	}
+	return limits
+}
+
+// GraphQL runs a GraphQL query, mutation or subscription over the books.
+//
+// Queries come as POST {"query", "variables", "operationName"} or as the
+// same GET parameters; mutations need POST. A subscription answers with
+// Server-Sent Events, one "next" event per result, until the client goes
+// away. Operations nested deeper than GraphQLLimits.MaxDepth or estimated to
+// resolve more than GraphQLLimits.MaxComplexity fields are refused.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	schema, err := graphqlSchema()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error building GraphQL schema", "error", err)
+		graphqlFailed(w, http.StatusInternalServerError, errGraphQLInternal)
+		return
+	}
+	params, err := parseGraphQLParams(r)
+	if err != nil {
+		graphqlFailed(w, http.StatusBadRequest, err)
+		return
+	}
+
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(params.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		graphqlFailed(w, http.StatusBadRequest, err)
+		return
+	}
+	if result := graphql.ValidateDocument(&schema, document, nil); !result.IsValid {
+		writeGraphQL(w, http.StatusBadRequest, graphqlResponse{Errors: result.Errors})
+		return
+	}
+	operation, err := selectOperation(document, params.OperationName)
+	if err != nil {
+		graphqlFailed(w, http.StatusBadRequest, err)
+		return
+	}
+	if operation.Operation == ast.OperationTypeMutation && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		graphqlFailed(w, http.StatusMethodNotAllowed, errors.New("mutations must be sent with POST"))
+		return
+	}
+	if err := checkLimits(document, operation, params.Variables, h.graphqlLimits()); err != nil {
+		graphqlFailed(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rq := &graphqlRequest{h: h, r: r, loaders: h.newGraphQLLoaders(r)}
+	execute := graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           document,
+		OperationName: params.OperationName,
+		Args:          params.Variables,
+		Context:       context.WithValue(r.Context(), graphqlRequestKey{}, rq),
+	}
+	if operation.Operation == ast.OperationTypeSubscription {
+		h.streamGraphQL(w, r, execute)
+		return
+	}
+	writeGraphQL(w, http.StatusOK, graphql.Execute(execute))
+}
+
+// streamGraphQL sends the results of a subscription as Server-Sent Events
+func (h *Handler) streamGraphQL(w http.ResponseWriter, r *http.Request, execute graphql.ExecuteParams) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if controller.Flush() != nil {
+		return
+	}
+
+	heartbeat := h.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	results := graphql.ExecuteSubscription(execute)
+	// The executor stops once the request is done, but may be sending a
+	// result then; draining the channel lets it finish
+	defer func() {
+		go func() {
+			for range results {
+			}
+		}()
+	}()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case result, ok := <-results:
+			if !ok {
+				fmt.Fprint(w, "event: complete\ndata:\n\n")
+				controller.Flush()
+				return
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("error encoding GraphQL result", "error", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: next\ndata: %s\n\n", data); err != nil || controller.Flush() != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil || controller.Flush() != nil {
+				return
+			}
+		}
+	}
+}
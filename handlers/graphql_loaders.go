@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"connection_to_pg/models"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// loader batches the lookups GraphQL resolvers make for the same kind of
+// record. load only queues the key and returns a thunk; the executor runs
+// the thunks of a level once every resolver of that level has queued its
+// key, so the first one fetches the whole batch with a single query and
+// the others find their results cached. Loaders live for one request.
+type loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	cache   map[K]V
+	errs    map[K]error
+}
+
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, queued: map[K]bool{}, cache: map[K]V{}, errs: map[K]error{}}
+}
+
+// load queues key and returns a thunk yielding its value, the zero value
+// when there is no record for it
+func (l *loader[K, V]) load(key K) func() (V, error) {
+	l.mu.Lock()
+	if !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.pending) > 0 {
+			keys := l.pending
+			l.pending = nil
+			values, err := l.fetch(keys)
+			for _, k := range keys {
+				if err != nil {
+					l.errs[k] = err
+				} else {
+					l.cache[k] = values[k]
+				}
+			}
+		}
+		return l.cache[key], l.errs[key]
+	}
+}
+
+// graphqlCredit is an author credited on a book as GraphQL shows it
+type graphqlCredit struct {
+	Author   models.Author
+	Role     string
+	Position int
+}
+
+// graphqlLoaders are the loaders of one GraphQL request
+type graphqlLoaders struct {
+	books        *loader[int, *models.Book]
+	credits      *loader[int, []graphqlCredit]
+	categories   *loader[int, []models.Category]
+	tags         *loader[int, []string]
+	reviews      *loader[int, []models.Review]
+	circulation  *loader[int, *circulation]
+	authorBooks  *loader[int, []models.Book]
+	shelfEntries *loader[int, []models.ShelfEntry]
+}
+
+func (h *Handler) newGraphQLLoaders(r *http.Request) *graphqlLoaders {
+	return &graphqlLoaders{
+		books:       newLoader(func(ids []int) (map[int]*models.Book, error) { return h.loadBooks(r, ids) }),
+		credits:     newLoader(func(ids []int) (map[int][]graphqlCredit, error) { return h.loadCredits(r, ids) }),
+		categories:  newLoader(func(ids []int) (map[int][]models.Category, error) { return h.loadBookCategories(r, ids) }),
+		tags:        newLoader(func(ids []int) (map[int][]string, error) { return h.loadBookTags(r, ids) }),
+		reviews:     newLoader(func(ids []int) (map[int][]models.Review, error) { return h.loadBookReviews(r, ids) }),
+		circulation: newLoader(func(ids []int) (map[int]*circulation, error) { return h.loadCirculation(r, ids) }),
+		authorBooks: newLoader(func(ids []int) (map[int][]models.Book, error) { return h.loadAuthorBooks(r, ids) }),
+		shelfEntries: newLoader(func(ids []int) (map[int][]models.ShelfEntry, error) {
+			return h.loadShelfEntries(r, ids)
+		}),
+	}
+}
+
+func (h *Handler) loadBooks(r *http.Request, ids []int) (map[int]*models.Book, error) {
+	var books []models.Book
+	if err := h.dbFor(r).Find(&books, "id IN ?", ids).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.Book, len(books))
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+	return byID, nil
+}
+
+// loadCredits returns the author credits of books in credit order
+func (h *Handler) loadCredits(r *http.Request, bookIDs []int) (map[int][]graphqlCredit, error) {
+	var links []models.BookAuthor
+	if err := h.dbFor(r).Find(&links, "book_id IN ?", bookIDs).Error; err != nil {
+		return nil, err
+	}
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Position < links[j].Position })
+
+	authorIDs := make([]int, 0, len(links))
+	for _, link := range links {
+		authorIDs = append(authorIDs, link.AuthorID)
+	}
+	authors := map[int]models.Author{}
+	if len(authorIDs) > 0 {
+		var found []models.Author
+		if err := h.dbFor(r).Find(&found, "id IN ?", authorIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, author := range found {
+			authors[author.ID] = author
+		}
+	}
+
+	credits := map[int][]graphqlCredit{}
+	for _, link := range links {
+		if author, ok := authors[link.AuthorID]; ok {
+			credits[link.BookID] = append(credits[link.BookID], graphqlCredit{Author: author, Role: link.Role, Position: link.Position})
+		}
+	}
+	return credits, nil
+}
+
+func (h *Handler) loadBookCategories(r *http.Request, bookIDs []int) (map[int][]models.Category, error) {
+	var links []models.BookCategory
+	if err := h.dbFor(r).Find(&links, "book_id IN ?", bookIDs).Error; err != nil {
+		return nil, err
+	}
+	categoryIDs := make([]int, 0, len(links))
+	for _, link := range links {
+		categoryIDs = append(categoryIDs, link.CategoryID)
+	}
+	byID := map[int]models.Category{}
+	if len(categoryIDs) > 0 {
+		var found []models.Category
+		if err := h.dbFor(r).Find(&found, "id IN ?", categoryIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, category := range found {
+			byID[category.ID] = category
+		}
+	}
+
+	categories := map[int][]models.Category{}
+	for _, link := range links {
+		if category, ok := byID[link.CategoryID]; ok {
+			categories[link.BookID] = append(categories[link.BookID], category)
+		}
+	}
+	for _, list := range categories {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+	return categories, nil
+}
+
+func (h *Handler) loadBookTags(r *http.Request, bookIDs []int) (map[int][]string, error) {
+	var links []models.BookTag
+	if err := h.dbFor(r).Find(&links, "book_id IN ?", bookIDs).Error; err != nil {
+		return nil, err
+	}
+	tagIDs := make([]int, 0, len(links))
+	for _, link := range links {
+		tagIDs = append(tagIDs, link.TagID)
+	}
+	names := map[int]string{}
+	if len(tagIDs) > 0 {
+		var found []models.Tag
+		if err := h.dbFor(r).Find(&found, "id IN ?", tagIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, tag := range found {
+			names[tag.ID] = tag.Name
+		}
+	}
+
+	tags := map[int][]string{}
+	for _, link := range links {
+		if name, ok := names[link.TagID]; ok {
+			tags[link.BookID] = append(tags[link.BookID], name)
+		}
+	}
+	for _, list := range tags {
+		sort.Strings(list)
+	}
+	return tags, nil
+}
+
+// loadBookReviews returns the reviews of books, newest first
+func (h *Handler) loadBookReviews(r *http.Request, bookIDs []int) (map[int][]models.Review, error) {
+	var found []models.Review
+	if err := h.dbFor(r).Find(&found, "book_id IN ?", bookIDs).Error; err != nil {
+		return nil, err
+	}
+	sort.SliceStable(found, func(i, j int) bool { return reviewSorts["-created_at"](found[i], found[j]) })
+	reviews := map[int][]models.Review{}
+	for _, review := range found {
+		reviews[review.BookID] = append(reviews[review.BookID], review)
+	}
+	return reviews, nil
+}
+
+// loadCirculation summarises the copies and holds of books, as
+// bookCirculation does for one
+func (h *Handler) loadCirculation(r *http.Request, bookIDs []int) (map[int]*circulation, error) {
+	var copies []models.Copy
+	if err := h.dbFor(r).Find(&copies, "book_id IN ?", bookIDs).Error; err != nil {
+		return nil, err
+	}
+	var loans []models.Loan
+	if err := h.dbFor(r).Find(&loans, "returned_at IS NULL AND copy_id IN (SELECT id FROM copies WHERE book_id IN ?)", bookIDs).Error; err != nil {
+		return nil, err
+	}
+	var holds []models.Hold
+	if err := h.dbFor(r).Find(&holds, "book_id IN ? AND closed_at IS NULL", bookIDs).Error; err != nil {
+		return nil, err
+	}
+
+	copyBooks := map[int]int{}
+	bookCopies := map[int][]models.Copy{}
+	for _, c := range copies {
+		copyBooks[c.ID] = c.BookID
+		bookCopies[c.BookID] = append(bookCopies[c.BookID], c)
+	}
+	bookLoans := map[int][]models.Loan{}
+	for _, loan := range loans {
+		bookLoans[copyBooks[loan.CopyID]] = append(bookLoans[copyBooks[loan.CopyID]], loan)
+	}
+	bookHolds := map[int][]models.Hold{}
+	for _, hold := range holds {
+		bookHolds[hold.BookID] = append(bookHolds[hold.BookID], hold)
+	}
+
+	now := clock()
+	summaries := make(map[int]*circulation, len(bookIDs))
+	for _, id := range bookIDs {
+		summary, _ := summariseCirculation(bookCopies[id], bookLoans[id], bookHolds[id], now)
+		summaries[id] = &summary
+	}
+	return summaries, nil
+}
+
+// loadAuthorBooks returns the books each author is credited on
+func (h *Handler) loadAuthorBooks(r *http.Request, authorIDs []int) (map[int][]models.Book, error) {
+	var links []models.BookAuthor
+	if err := h.dbFor(r).Find(&links, "author_id IN ?", authorIDs).Error; err != nil {
+		return nil, err
+	}
+	bookIDs := make([]int, 0, len(links))
+	for _, link := range links {
+		bookIDs = append(bookIDs, link.BookID)
+	}
+	books := map[int]*models.Book{}
+	if len(bookIDs) > 0 {
+		var err error
+		if books, err = h.loadBooks(r, bookIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	byAuthor := map[int][]models.Book{}
+	seen := map[[2]int]bool{}
+	for _, link := range links {
+		// An author holding several roles on a book lists it once
+		if book, ok := books[link.BookID]; ok && !seen[[2]int{link.AuthorID, link.BookID}] {
+			seen[[2]int{link.AuthorID, link.BookID}] = true
+			byAuthor[link.AuthorID] = append(byAuthor[link.AuthorID], *book)
+		}
+	}
+	for _, list := range byAuthor {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	}
+	return byAuthor, nil
+}
+
+// loadShelfEntries returns the entries of shelves in shelf order,
+// numbered from 1 as loadShelf does
+func (h *Handler) loadShelfEntries(r *http.Request, shelfIDs []int) (map[int][]models.ShelfEntry, error) {
+	var found []models.ShelfEntry
+	if err := h.dbFor(r).Find(&found, "shelf_id IN ?", shelfIDs).Error; err != nil {
+		return nil, err
+	}
+	models.SortShelfEntries(found)
+	entries := map[int][]models.ShelfEntry{}
+	for _, entry := range found {
+		entry.Position = len(entries[entry.ShelfID]) + 1
+		entries[entry.ShelfID] = append(entries[entry.ShelfID], entry)
+	}
+	return entries, nil
+}
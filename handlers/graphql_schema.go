@@ -0,0 +1,750 @@
+package handlers
+
+import (
+	"connection_to_pg/auth"
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"connection_to_pg/models"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+)
+
+// Page sizes of the GraphQL list fields taking a first argument
+const (
+	defaultGraphQLPage = 20
+	maxGraphQLPage     = 100
+)
+
+// errGraphQLInternal stands in for database errors, which are logged
+// rather than shown to the client
+var errGraphQLInternal = errors.New("Internal error, try again later")
+
+// graphqlRequest is what the resolvers of one GraphQL request share, found
+// in the context they are given
+type graphqlRequest struct {
+	h       *Handler
+	r       *http.Request
+	loaders *graphqlLoaders
+}
+
+type graphqlRequestKey struct{}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// failed logs an unexpected error and hides it from the client
+func (rq *graphqlRequest) failed(msg string, err error, args ...any) error {
+	logging.FromContext(rq.r.Context()).Error(msg, append(args, "error", err)...)
+	return errGraphQLInternal
+}
+
+// principal returns the authenticated caller, as requirePrincipal does
+func (rq *graphqlRequest) principal() (auth.Principal, error) {
+	principal, ok := auth.FromContext(rq.r.Context())
+	if !ok {
+		return principal, errors.New("Authentication required, send an API key")
+	}
+	return principal, nil
+}
+
+// deferred turns a loader thunk into one the GraphQL executor resolves
+// once the rest of the level has queued its keys
+func deferred[V any](rq *graphqlRequest, thunk func() (V, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil {
+			return nil, rq.failed("error loading GraphQL field", err)
+		}
+		return value, nil
+	}
+}
+
+// sourceBook returns the book a Book field is resolved on
+func sourceBook(p graphql.ResolveParams) models.Book {
+	if book, ok := p.Source.(*models.Book); ok {
+		return *book
+	}
+	return p.Source.(models.Book)
+}
+
+// bookConnection is a page of books
+type bookConnection struct {
+	Nodes      []models.Book
+	TotalCount int64
+	PageInfo   pageInfo
+}
+
+type pageInfo struct {
+	EndCursor   *string
+	HasNextPage bool
+}
+
+// encodeCursor and decodeCursor turn list offsets into opaque cursors
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if value, ok := strings.CutPrefix(string(raw), "offset:"); ok {
+			if offset, err := strconv.Atoi(value); err == nil && offset >= 0 {
+				return offset, nil
+			}
+		}
+	}
+	return 0, errors.New("invalid cursor")
+}
+
+// pageSize reads the first argument of a list field
+func pageSize(p graphql.ResolveParams, fallback int) (int, error) {
+	first, ok := p.Args["first"].(int)
+	if !ok {
+		return fallback, nil
+	}
+	if first < 0 || first > maxGraphQLPage {
+		return 0, fmt.Errorf("first must be between 0 and %d", maxGraphQLPage)
+	}
+	return first, nil
+}
+
+// graphqlBookFilters turns the filter argument of books into the filters
+// of GET /books, so both lists select the same books
+func graphqlBookFilters(input map[string]interface{}) bookFilters {
+	var filters bookFilters
+	filters.Author, _ = input["author"].(string)
+	filters.Name, _ = input["name"].(string)
+	filters.Query, _ = input["q"].(string)
+	if category, ok := input["category"].(int); ok {
+		filters.Category = strconv.Itoa(category)
+	}
+	if tags, ok := input["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			if tag := models.NormalizeTag(tag.(string)); tag != "" {
+				filters.Tags = append(filters.Tags, tag)
+			}
+		}
+	}
+	filters.TagMatch, _ = input["tagMatch"].(string)
+	if rating, ok := input["minRating"].(float64); ok {
+		filters.MinRating = strconv.FormatFloat(rating, 'f', -1, 64)
+	}
+	return filters
+}
+
+// graphqlSchema is built on first use; its resolvers find the handler and
+// request in their context
+var graphqlSchema = sync.OnceValues(newGraphQLSchema)
+
+func newGraphQLSchema() (graphql.Schema, error) {
+	var bookType, authorType *graphql.Object
+
+	circulationType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Circulation",
+		Description: "The state of the copies and holds queue of a book",
+		Fields: graphql.Fields{
+			"copies":               &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"available":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"onLoan":               &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"reserved":             &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"holds":                &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"estimatedAvailableAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+
+	categoryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Category",
+		Fields: graphql.Fields{
+			"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"name":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"parentId": &graphql.Field{Type: graphql.Int},
+			"path":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+
+	creditType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Credit",
+		Description: "An author credited on a book in a role",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"author":   &graphql.Field{Type: graphql.NewNonNull(authorType)},
+				"role":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"position": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			}
+		}),
+	})
+
+	reviewType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Review",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"rating":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"text":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"author":    &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "Username of the reviewer"},
+				"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+				"updatedAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+				"book": &graphql.Field{
+					Type: bookType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						return deferred(rq, rq.loaders.books.load(p.Source.(models.Review).BookID)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	bookType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Book",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"name":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"description":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"author":        &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "Free-text author, see credits"},
+				"isbn13":        &graphql.Field{Type: graphql.String},
+				"isbn10":        &graphql.Field{Type: graphql.String},
+				"ratingAverage": &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"ratingCount":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"credits": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(creditType))),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						return deferred(rq, rq.loaders.credits.load(sourceBook(p).ID)), nil
+					},
+				},
+				"categories": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(categoryType))),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						return deferred(rq, rq.loaders.categories.load(sourceBook(p).ID)), nil
+					},
+				},
+				"tags": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						return deferred(rq, rq.loaders.tags.load(sourceBook(p).ID)), nil
+					},
+				},
+				"reviews": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(reviewType))),
+					Description: "The newest reviews",
+					Args: graphql.FieldConfigArgument{
+						"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						first, err := pageSize(p, 10)
+						if err != nil {
+							return nil, err
+						}
+						thunk := rq.loaders.reviews.load(sourceBook(p).ID)
+						return deferred(rq, func() ([]models.Review, error) {
+							reviews, err := thunk()
+							return reviews[:min(first, len(reviews))], err
+						}), nil
+					},
+				},
+				"circulation": &graphql.Field{
+					Type: graphql.NewNonNull(circulationType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						return deferred(rq, rq.loaders.circulation.load(sourceBook(p).ID)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	authorType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"bio":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"books": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(bookType))),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						rq := graphqlRequestFrom(p.Context)
+						return deferred(rq, rq.loaders.authorBooks.load(p.Source.(models.Author).ID)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	shelfEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ShelfEntry",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"position":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"note":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"startedAt":  &graphql.Field{Type: graphql.DateTime},
+			"finishedAt": &graphql.Field{Type: graphql.DateTime},
+			"addedAt":    &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"book": &graphql.Field{
+				Type: bookType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rq := graphqlRequestFrom(p.Context)
+					return deferred(rq, rq.loaders.books.load(p.Source.(models.ShelfEntry).BookID)), nil
+				},
+			},
+		},
+	})
+
+	shelfType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Shelf",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"name":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"description": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"shareUrl": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Public link, null while the shelf is private",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if shelf := p.Source.(models.Shelf); shelf.ShareURL != "" {
+						return shelf.ShareURL, nil
+					}
+					return nil, nil
+				},
+			},
+			"entries": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(shelfEntryType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rq := graphqlRequestFrom(p.Context)
+					return deferred(rq, rq.loaders.shelfEntries.load(p.Source.(models.Shelf).ID)), nil
+				},
+			},
+		},
+	})
+
+	bookSortType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "BookSort",
+		Values: graphql.EnumValueConfigMap{
+			"ID":           &graphql.EnumValueConfig{Value: "id"},
+			"NAME":         &graphql.EnumValueConfig{Value: "name, id"},
+			"RATING":       &graphql.EnumValueConfig{Value: bookSorts["rating"]},
+			"RATING_DESC":  &graphql.EnumValueConfig{Value: bookSorts["-rating"]},
+			"REVIEWS":      &graphql.EnumValueConfig{Value: bookSorts["reviews"]},
+			"REVIEWS_DESC": &graphql.EnumValueConfig{Value: bookSorts["-reviews"]},
+		},
+	})
+
+	tagMatchType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "TagMatch",
+		Values: graphql.EnumValueConfigMap{
+			"ANY": &graphql.EnumValueConfig{Value: tagMatchAny},
+			"ALL": &graphql.EnumValueConfig{Value: tagMatchAll},
+		},
+	})
+
+	bookFilterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "BookFilter",
+		Description: "The filters of GET /books",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"author":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"name":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"q":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"category":  &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"tags":      &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"tagMatch":  &graphql.InputObjectFieldConfig{Type: tagMatchType},
+			"minRating": &graphql.InputObjectFieldConfig{Type: graphql.Float},
+		},
+	})
+
+	bookInputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "BookInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"description": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"author":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"isbn13":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"isbn10":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"endCursor":   &graphql.Field{Type: graphql.String},
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+
+	bookConnectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BookConnection",
+		Fields: graphql.Fields{
+			"nodes":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(bookType)))},
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+		},
+	})
+
+	bookEventKindType := graphql.NewEnum(graphql.EnumConfig{
+		Name: "BookEventType",
+		Values: graphql.EnumValueConfigMap{
+			"CREATED": &graphql.EnumValueConfig{Value: models.EventBookCreated},
+			"UPDATED": &graphql.EnumValueConfig{Value: models.EventBookUpdated},
+			"DELETED": &graphql.EnumValueConfig{Value: models.EventBookDeleted},
+		},
+	})
+
+	bookEventType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "BookEvent",
+		Description: "A change to a book, as published on GET /books/events",
+		Fields: graphql.Fields{
+			"sequence": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(models.OutboxEvent).ID, nil
+				},
+			},
+			"id": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "CloudEvent id",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(models.OutboxEvent).EventID, nil
+				},
+			},
+			"type": &graphql.Field{Type: graphql.NewNonNull(bookEventKindType)},
+			"time": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"bookId": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Int),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return strconv.Atoi(p.Source.(models.OutboxEvent).Subject)
+				},
+			},
+			"book": &graphql.Field{
+				Type:        bookType,
+				Description: "The book as changed, null once deleted",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					event := p.Source.(models.OutboxEvent)
+					if event.Type == models.EventBookDeleted {
+						return nil, nil
+					}
+					var book models.Book
+					if err := json.Unmarshal(event.Data, &book); err != nil {
+						return nil, graphqlRequestFrom(p.Context).failed("error decoding book event", err, "sequence", event.ID)
+					}
+					return book, nil
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"book": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rq := graphqlRequestFrom(p.Context)
+					return deferred(rq, rq.loaders.books.load(p.Args["id"].(int))), nil
+				},
+			},
+			"books": &graphql.Field{
+				Type: graphql.NewNonNull(bookConnectionType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: bookFilterType},
+					"sort":   &graphql.ArgumentConfig{Type: bookSortType, DefaultValue: "id"},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultGraphQLPage},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: resolveBooks,
+			},
+			"author": &graphql.Field{
+				Type: authorType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rq := graphqlRequestFrom(p.Context)
+					var author models.Author
+					err := rq.h.dbFor(rq.r).First(&author, p.Args["id"].(int)).Error
+					if errors.Is(err, gorm.ErrRecordNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, rq.failed("error querying author", err, "author_id", p.Args["id"])
+					}
+					return author, nil
+				},
+			},
+			"shelves": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(shelfType))),
+				Description: "The caller's shelves by name; requires authentication",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					rq := graphqlRequestFrom(p.Context)
+					principal, err := rq.principal()
+					if err != nil {
+						return nil, err
+					}
+					shelves := []models.Shelf{}
+					if err := rq.h.dbFor(rq.r).Find(&shelves, "user_id = ?", principal.UserID).Error; err != nil {
+						return nil, rq.failed("error querying shelves", err, "user_id", principal.UserID)
+					}
+					sort.Slice(shelves, func(i, j int) bool { return shelves[i].Name < shelves[j].Name })
+					for i := range shelves {
+						shelves[i] = withShareURL(shelves[i])
+					}
+					return shelves, nil
+				},
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInputType)},
+				},
+				Resolve: resolveCreateBook,
+			},
+			"updateBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInputType)},
+				},
+				Resolve: resolveUpdateBook,
+			},
+			"deleteBook": &graphql.Field{
+				Type:        graphql.NewNonNull(bookType),
+				Description: "Deletes a book and returns it as it was",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: resolveDeleteBook,
+			},
+		},
+	})
+
+	subscriptionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"bookEvents": &graphql.Field{
+				Type: graphql.NewNonNull(bookEventType),
+				Args: graphql.FieldConfigArgument{
+					"types":   &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(bookEventKindType))},
+					"bookIds": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.Int))},
+				},
+				Subscribe: subscribeBookEvents,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					// Each event is resolved on its own, so it must not see
+					// what the loaders cached for an earlier one
+					rq := graphqlRequestFrom(p.Context)
+					rq.loaders = rq.h.newGraphQLLoaders(rq.r)
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:        queryType,
+		Mutation:     mutationType,
+		Subscription: subscriptionType,
+	})
+}
+
+// resolveBooks lists a page of books matching the filter
+func resolveBooks(p graphql.ResolveParams) (interface{}, error) {
+	rq := graphqlRequestFrom(p.Context)
+	filterArg, _ := p.Args["filter"].(map[string]interface{})
+	filters := graphqlBookFilters(filterArg)
+	if err := filters.validate(); err != nil {
+		return nil, err
+	}
+	first, err := pageSize(p, defaultGraphQLPage)
+	if err != nil {
+		return nil, err
+	}
+	offset := 0
+	if after, ok := p.Args["after"].(string); ok {
+		if offset, err = decodeCursor(after); err != nil {
+			return nil, err
+		}
+		offset++
+	}
+
+	query := rq.h.dbFor(rq.r).Model(&models.Book{})
+	if conds := filters.conds(); len(conds) > 0 {
+		query = query.Where(conds[0], conds[1:]...)
+	}
+	query = query.Session(&gorm.Session{})
+
+	page := bookConnection{Nodes: []models.Book{}}
+	if err := query.Count(&page.TotalCount).Error; err != nil {
+		return nil, rq.failed("error counting books", err)
+	}
+	// One more than asked for tells whether there is a next page
+	if err := query.Order(p.Args["sort"].(string)).Offset(offset).Limit(first + 1).Find(&page.Nodes).Error; err != nil {
+		return nil, rq.failed("error querying books table", err)
+	}
+	if len(page.Nodes) > first {
+		page.Nodes = page.Nodes[:first]
+		page.PageInfo.HasNextPage = true
+	}
+	if len(page.Nodes) > 0 {
+		cursor := encodeCursor(offset + len(page.Nodes) - 1)
+		page.PageInfo.EndCursor = &cursor
+	}
+	return page, nil
+}
+
+// bookFromInput fills the fields of book the BookInput sets, replacing
+// them all as PUT /books/{id} does
+func bookFromInput(book *models.Book, input map[string]interface{}) error {
+	book.Name, _ = input["name"].(string)
+	book.Description, _ = input["description"].(string)
+	book.Author, _ = input["author"].(string)
+	book.ISBN13, book.ISBN10 = nil, nil
+	if value, ok := input["isbn13"].(string); ok {
+		book.ISBN13 = &value
+	}
+	if value, ok := input["isbn10"].(string); ok {
+		book.ISBN10 = &value
+	}
+	return book.NormalizeISBN()
+}
+
+// errDuplicateISBN reports the ISBN a book collided with, as the 409 of
+// the REST endpoints does
+func errDuplicateISBN(book models.Book) error {
+	return errors.New("A book with ISBN " + *book.ISBN13 + " already exists")
+}
+
+func resolveCreateBook(p graphql.ResolveParams) (interface{}, error) {
+	rq := graphqlRequestFrom(p.Context)
+	var book models.Book
+	if err := bookFromInput(&book, p.Args["input"].(map[string]interface{})); err != nil {
+		return nil, err
+	}
+	if err := rq.h.dbFor(rq.r).Create(&book).Error; err != nil {
+		if db.IsUniqueViolation(err) && book.ISBN13 != nil {
+			return nil, errDuplicateISBN(book)
+		}
+		return nil, rq.failed("error creating book", err)
+	}
+	logging.FromContext(rq.r.Context()).Debug("book created", "book_id", book.ID)
+	metrics.BooksCreated.Inc()
+	return book, nil
+}
+
+// findBook returns the book with the given ID, or an error fit for the
+// client
+func (rq *graphqlRequest) findBook(id int) (models.Book, error) {
+	var book models.Book
+	err := rq.h.dbFor(rq.r).First(&book, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return book, errors.New("Book not found")
+	}
+	if err != nil {
+		return book, rq.failed("error querying book", err, "book_id", id)
+	}
+	return book, nil
+}
+
+func resolveUpdateBook(p graphql.ResolveParams) (interface{}, error) {
+	rq := graphqlRequestFrom(p.Context)
+	book, err := rq.findBook(p.Args["id"].(int))
+	if err != nil {
+		return nil, err
+	}
+	if err := bookFromInput(&book, p.Args["input"].(map[string]interface{})); err != nil {
+		return nil, err
+	}
+	if err := rq.h.dbFor(rq.r).Save(&book).Error; err != nil {
+		if db.IsUniqueViolation(err) && book.ISBN13 != nil {
+			return nil, errDuplicateISBN(book)
+		}
+		return nil, rq.failed("error updating book", err, "book_id", book.ID)
+	}
+	metrics.BooksUpdated.Inc()
+	return book, nil
+}
+
+func resolveDeleteBook(p graphql.ResolveParams) (interface{}, error) {
+	rq := graphqlRequestFrom(p.Context)
+	book, err := rq.findBook(p.Args["id"].(int))
+	if err != nil {
+		return nil, err
+	}
+	if err := rq.h.dbFor(rq.r).Delete(&book).Error; err != nil {
+		if db.IsForeignKeyViolation(err) {
+			return nil, errBookLent
+		}
+		return nil, rq.failed("error deleting book", err, "book_id", book.ID)
+	}
+	rq.h.deleteCoverBlobs(rq.r.Context(), book.ID)
+	metrics.BooksDeleted.Inc()
+	return book, nil
+}
+
+// subscribeBookEvents feeds the events of the broker matching the
+// arguments to a subscription until its request ends
+func subscribeBookEvents(p graphql.ResolveParams) (interface{}, error) {
+	rq := graphqlRequestFrom(p.Context)
+	if rq.h.Events == nil {
+		return nil, errors.New("Event stream is not available")
+	}
+	var filter eventFilter
+	if types, ok := p.Args["types"].([]interface{}); ok {
+		for _, t := range types {
+			filter.types = append(filter.types, t.(string))
+		}
+	}
+	if ids, ok := p.Args["bookIds"].([]interface{}); ok {
+		for _, id := range ids {
+			filter.bookIDs = append(filter.bookIDs, strconv.Itoa(id.(int)))
+		}
+	}
+
+	subscription := rq.h.Events.Subscribe()
+	events := make(chan interface{})
+	go func() {
+		defer close(events)
+		defer rq.h.Events.Unsubscribe(subscription)
+		for {
+			select {
+			case <-p.Context.Done():
+				return
+			case event, ok := <-subscription.C:
+				if !ok {
+					return
+				}
+				if !filter.match(event) {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-p.Context.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bufio"
+	"connection_to_pg/events"
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// postGraphQL runs a GraphQL request against handler
+func postGraphQL(handler *Handler, req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	handler.GraphQL(w, req)
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body
+}
+
+// newGraphQLRequest returns a POST of query
+func newGraphQLRequest(query string, variables map[string]interface{}) *http.Request {
+	body, _ := json.Marshal(graphqlParams{Query: query, Variables: variables})
+	return httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+}
+
+// inIDs matches the conditions of a batched "... IN ?" lookup of ids, which
+// the loaders may collect in any order
+func inIDs(query string, ids ...int) interface{} {
+	return mock.MatchedBy(func(where []interface{}) bool {
+		if len(where) != 2 || where[0] != query {
+			return false
+		}
+		got, ok := where[1].([]int)
+		got = slices.Clone(got)
+		slices.Sort(got)
+		return ok && slices.Equal(got, ids)
+	})
+}
+
+func TestGraphQL_BatchesNestedLookups(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), inIDs("id IN ?", 1, 2, 3)).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = []models.Book{{ID: 1, Name: "Dune"}, {ID: 2, Name: "Emma"}}
+	}).Return(&gorm.DB{}).Once()
+	mockDB.On("Find", mock.AnythingOfType("*[]models.BookAuthor"), inIDs("book_id IN ?", 1, 2)).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.BookAuthor) = []models.BookAuthor{
+			{BookID: 1, AuthorID: 10, Role: models.AuthorRoleAuthor},
+			{BookID: 2, AuthorID: 20, Role: models.AuthorRoleAuthor},
+		}
+	}).Return(&gorm.DB{}).Once()
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Author"), inIDs("id IN ?", 10, 20)).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Author) = []models.Author{{ID: 10, Name: "Frank Herbert"}, {ID: 20, Name: "Jane Austen"}}
+	}).Return(&gorm.DB{}).Once()
+
+	w, body := postGraphQL(handler, newGraphQLRequest(`{
+		a: book(id: 1) { name credits { role author { name } } }
+		b: book(id: 2) { name credits { author { name } } }
+		missing: book(id: 3) { name }
+	}`, nil))
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(t, body["errors"])
+	data := body["data"].(map[string]interface{})
+	assert.Equal(t, "Dune", data["a"].(map[string]interface{})["name"])
+	assert.Contains(t, w.Body.String(), `"author":{"name":"Frank Herbert"},"role":"author"`)
+	assert.Contains(t, w.Body.String(), `"name":"Jane Austen"`)
+	assert.Nil(t, data["missing"])
+	mockDB.AssertExpectations(t)
+}
+
+func TestGraphQL_Limits(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB), GraphQLLimits: models.GraphQLConfig{MaxDepth: 4, MaxComplexity: 500}}
+
+	tests := []struct {
+		name, query string
+		variables   map[string]interface{}
+		err         string
+	}{
+		{"depth", `{ book(id: 1) { reviews { book { credits { author { name } } } } } }`, nil, "nested 6 levels deep"},
+		{"depth through fragments", `{ book(id: 1) { ...deep } } fragment deep on Book { reviews { book { credits { role } } } }`, nil, "nested 5 levels deep"},
+		{"complexity", `{ books(first: 100) { nodes { reviews(first: 50) { text } } } }`, nil, "complexity is 5201"},
+		{"complexity from variables", `query($n: Int) { books(first: $n) { nodes { name tags credits { role } } } }`, map[string]interface{}{"n": 100}, "complexity is 1401"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := postGraphQL(handler, newGraphQLRequest(tt.query, tt.variables))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.err)
+		})
+	}
+
+	// Introspection is free, so tools can always read the schema
+	w, body := postGraphQL(handler, newGraphQLRequest(`{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, body["errors"])
+}
+
+func TestGraphQL_InvalidRequests(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB)}
+
+	w, _ := postGraphQL(handler, newGraphQLRequest(`{ book(id: 1) { title } }`, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `Cannot query field \"title\"`)
+
+	w, _ = postGraphQL(handler, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mutation := url.Values{"query": {`mutation { deleteBook(id: 1) { id } }`}}
+	w, _ = postGraphQL(handler, httptest.NewRequest(http.MethodGet, "/graphql?"+mutation.Encode(), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestGraphQL_CreateBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Book).ID = 5
+	}).Return(nil)
+
+	w, _ := postGraphQL(handler, newGraphQLRequest(`mutation($input: BookInput!) { createBook(input: $input) { id name isbn13 isbn10 } }`,
+		map[string]interface{}{"input": map[string]interface{}{"name": "Dune", "isbn13": "978-0-441-17271-9"}}))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":{"createBook":{"id":5,"name":"Dune","isbn13":"9780441172719","isbn10":"0441172717"}}}`, w.Body.String())
+}
+
+func TestGraphQL_CreateBook_DuplicateISBN(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Return(gorm.ErrDuplicatedKey)
+
+	w, body := postGraphQL(handler, newGraphQLRequest(`mutation { createBook(input: {name: "Dune", isbn13: "9780441172719"}) { id } }`, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, body["data"])
+	assert.Contains(t, w.Body.String(), "A book with ISBN 9780441172719 already exists")
+}
+
+func TestGraphQL_DeleteBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	expectBook(mockDB, 1)
+	mockDB.On("Delete", mock.AnythingOfType("*models.Book")).Return(&gorm.DB{})
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 2).Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+
+	w, _ := postGraphQL(handler, newGraphQLRequest(`mutation { deleteBook(id: 1) { id } }`, nil))
+	assert.JSONEq(t, `{"data":{"deleteBook":{"id":1}}}`, w.Body.String())
+
+	w, _ = postGraphQL(handler, newGraphQLRequest(`mutation { deleteBook(id: 2) { id } }`, nil))
+	assert.Contains(t, w.Body.String(), "Book not found")
+	mockDB.AssertNumberOfCalls(t, "Delete", 1)
+}
+
+func TestGraphQL_ShelvesRequireAuthentication(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	_, body := postGraphQL(handler, newGraphQLRequest(`{ shelves { name } }`, nil))
+	assert.Contains(t, body["errors"].([]interface{})[0].(map[string]interface{})["message"], "Authentication required")
+
+	token := "abc"
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Shelf"), []interface{}{"user_id = ?", 7}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Shelf) = []models.Shelf{{ID: 2, Name: "to read", ShareToken: &token}, {ID: 1, Name: "finished"}}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.ShelfEntry"), inIDs("shelf_id IN ?", 1, 2)).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.ShelfEntry) = []models.ShelfEntry{{ID: 4, ShelfID: 2, Position: 3}}
+	}).Return(&gorm.DB{}).Once()
+
+	w, _ := postGraphQL(handler, asUser(newGraphQLRequest(`{ shelves { name shareUrl entries { id position } } }`, nil), 7, models.RoleUser))
+	assert.JSONEq(t, `{"data":{"shelves":[
+		{"name":"finished","shareUrl":null,"entries":[]},
+		{"name":"to read","shareUrl":"/shared/shelves/abc","entries":[{"id":4,"position":1}]}
+	]}}`, w.Body.String())
+}
+
+func TestGraphQL_Subscription(t *testing.T) {
+	broker := events.NewBroker(nil, time.Second, 8)
+	handler := &Handler{Events: broker, Heartbeat: time.Hour}
+	server := httptest.NewServer(http.HandlerFunc(handler.GraphQL))
+	defer server.Close()
+
+	query := url.Values{"query": {`subscription { bookEvents(types: [CREATED]) { sequence type bookId book { name } } }`}}
+	resp, err := http.Get(server.URL + "?" + query.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The subscription is made once the stream is open; keep publishing
+	// until it sees an event
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for id := int64(1); ; id++ {
+			event := models.OutboxEvent{ID: id, Type: models.EventBookDeleted, Subject: "1", Data: []byte(`{"id":1}`)}
+			if id%2 == 0 {
+				event = models.OutboxEvent{ID: id, Type: models.EventBookCreated, Subject: "2", Data: []byte(`{"id":2,"name":"Emma"}`)}
+			}
+			broker.Publish(event)
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan())
+	assert.Equal(t, "event: next", lines.Text())
+	require.True(t, lines.Scan())
+	assert.Contains(t, lines.Text(), `{"bookEvents":{"book":{"name":"Emma"},"bookId":2,"sequence":2,"type":"CREATED"}}`)
+}
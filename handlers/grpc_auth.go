@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"connection_to_pg/auth"
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// apiKeyFromMetadata returns the API key sent as a bearer token in the
+// authorization metadata or in x-api-key, as apiKeyFromRequest does for
+// HTTP headers
+func apiKeyFromMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if scheme, token, ok := strings.Cut(value, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	if keys := md.Get("x-api-key"); len(keys) > 0 {
+		return strings.TrimSpace(keys[0])
+	}
+	return ""
+}
+
+// authenticateRPC returns ctx carrying the principal of the API key of the
+// call, if one was sent, and its database client as Authenticate does
+func (h *Handler) authenticateRPC(ctx context.Context) (context.Context, error) {
+	key := apiKeyFromMetadata(ctx)
+	if key == "" {
+		addr := ""
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+		}
+		return db.WithClient(ctx, addrClient(addr)), nil
+	}
+	user, err := h.userOfKey(ctx, key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "Invalid API key")
+	}
+	if err != nil {
+		return nil, grpcFailed(ctx, "error resolving API key", err)
+	}
+	logging.SetPrincipal(ctx, user.Username)
+	ctx = auth.NewContext(ctx, auth.Principal{UserID: user.ID, Username: user.Username, Role: user.Role})
+	return db.WithClient(ctx, userClient(user.ID)), nil
+}
+
+// AuthenticateUnary is Authenticate for unary gRPC calls: calls without an
+// API key continue anonymously, unknown and revoked keys fail with
+// UNAUTHENTICATED.
+func (h *Handler) AuthenticateUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := h.authenticateRPC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// AuthenticateStream is AuthenticateUnary for streaming calls
+func (h *Handler) AuthenticateStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := h.authenticateRPC(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream is a server stream carrying the caller's principal
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
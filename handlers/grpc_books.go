@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"connection_to_pg/models"
+	booksv1 "connection_to_pg/proto/books/v1"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/gorm"
+)
+
+// BooksService serves the books of Handler over gRPC, with the same
+// validation and side effects as the HTTP endpoints
+type BooksService struct {
+	booksv1.UnimplementedBooksServiceServer
+	h *Handler
+}
+
+// BooksService returns the gRPC service of the handler
+func (h *Handler) BooksService() *BooksService {
+	return &BooksService{h: h}
+}
+
+// errGRPCInternal hides the cause of a failure from clients; the cause is
+// logged
+var errGRPCInternal = status.Error(codes.Internal, "Database error")
+
+// grpcFailed logs an unexpected error and returns errGRPCInternal
+func grpcFailed(ctx context.Context, msg string, err error, args ...any) error {
+	logging.FromContext(ctx).Error(msg, append(args, "error", err)...)
+	return errGRPCInternal
+}
+
+// bookMessage converts a book to its protobuf message
+func bookMessage(book models.Book) *booksv1.Book {
+	return &booksv1.Book{
+		Id:            int64(book.ID),
+		Name:          book.Name,
+		Description:   book.Description,
+		Author:        book.Author,
+		Isbn13:        book.ISBN13,
+		Isbn10:        book.ISBN10,
+		RatingAverage: book.RatingAverage,
+		RatingCount:   int32(book.RatingCount),
+	}
+}
+
+// setBookInput copies the fields of input onto book and normalises its
+// ISBNs
+func setBookInput(book *models.Book, input *booksv1.BookInput) error {
+	if input == nil {
+		return status.Error(codes.InvalidArgument, "book is required")
+	}
+	book.Name = input.GetName()
+	book.Description = input.GetDescription()
+	book.Author = input.GetAuthor()
+	book.ISBN13 = input.Isbn13
+	book.ISBN10 = input.Isbn10
+	if err := book.NormalizeISBN(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// duplicateISBN is the error of a write colliding with another book's ISBN
+func duplicateISBN(isbn13 string) error {
+	return status.Error(codes.AlreadyExists, "A book with ISBN "+isbn13+" already exists")
+}
+
+// findBook loads the book with id
+func (s *BooksService) findBook(ctx context.Context, id int64) (models.Book, error) {
+	var book models.Book
+	err := s.h.DB.WithContext(ctx).First(&book, int(id)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return book, status.Error(codes.NotFound, "Book not found")
+	}
+	if err != nil {
+		return book, grpcFailed(ctx, "error querying book", err, "book_id", id)
+	}
+	return book, nil
+}
+
+func (s *BooksService) CreateBook(ctx context.Context, req *booksv1.CreateBookRequest) (*booksv1.Book, error) {
+	var book models.Book
+	if err := setBookInput(&book, req.GetBook()); err != nil {
+		return nil, err
+	}
+	if err := s.h.DB.WithContext(ctx).Create(&book).Error; err != nil {
+		if db.IsUniqueViolation(err) && book.ISBN13 != nil {
+			return nil, duplicateISBN(*book.ISBN13)
+		}
+		return nil, grpcFailed(ctx, "error creating book", err)
+	}
+	logging.FromContext(ctx).Debug("book created", "book_id", book.ID)
+	metrics.BooksCreated.Inc()
+	return bookMessage(book), nil
+}
+
+func (s *BooksService) GetBook(ctx context.Context, req *booksv1.GetBookRequest) (*booksv1.Book, error) {
+	book, err := s.findBook(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return bookMessage(book), nil
+}
+
+// ListBooks sends the books matching the filters of req one message each,
+// in the order GET /books returns them
+func (s *BooksService) ListBooks(req *booksv1.ListBooksRequest, stream booksv1.BooksService_ListBooksServer) error {
+	ctx := stream.Context()
+	filters := bookFilters{
+		Author:   strings.TrimSpace(req.GetAuthor()),
+		Name:     strings.TrimSpace(req.GetName()),
+		Query:    strings.TrimSpace(req.GetQuery()),
+		TagMatch: strings.TrimSpace(req.GetTagMatch()),
+		Sort:     strings.TrimSpace(req.GetSort()),
+	}
+	if id := req.GetCategoryId(); id != 0 {
+		filters.Category = strconv.FormatInt(id, 10)
+	}
+	if rating := req.GetMinRating(); rating != 0 {
+		filters.MinRating = strconv.FormatFloat(rating, 'f', -1, 64)
+	}
+	for _, tag := range req.GetTags() {
+		if tag = models.NormalizeTag(tag); tag != "" {
+			filters.Tags = append(filters.Tags, tag)
+		}
+	}
+	if err := filters.validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Books are sent as they are read rather than loaded first, so the
+	// stream starts at once and memory doesn't grow with the catalogue
+	query := filters.query(s.h.DB.WithContext(ctx))
+	rows, err := query.Rows()
+	if err != nil {
+		return grpcFailed(ctx, "error querying books table", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var book models.Book
+		if err := query.ScanRows(rows, &book); err != nil {
+			return grpcFailed(ctx, "error reading books table", err)
+		}
+		if err := stream.Send(bookMessage(book)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return grpcFailed(ctx, "error reading books table", err)
+	}
+	return nil
+}
+
+func (s *BooksService) UpdateBook(ctx context.Context, req *booksv1.UpdateBookRequest) (*booksv1.Book, error) {
+	book, err := s.findBook(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := setBookInput(&book, req.GetBook()); err != nil {
+		return nil, err
+	}
+	if err := s.h.DB.WithContext(ctx).Save(&book).Error; err != nil {
+		if db.IsUniqueViolation(err) && book.ISBN13 != nil {
+			return nil, duplicateISBN(*book.ISBN13)
+		}
+		return nil, grpcFailed(ctx, "error updating book", err, "book_id", book.ID)
+	}
+	metrics.BooksUpdated.Inc()
+	return bookMessage(book), nil
+}
+
+func (s *BooksService) DeleteBook(ctx context.Context, req *booksv1.DeleteBookRequest) (*emptypb.Empty, error) {
+	book, err := s.findBook(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.h.DB.WithContext(ctx).Delete(&book).Error; err != nil {
+		if db.IsForeignKeyViolation(err) {
+			return nil, status.Error(codes.FailedPrecondition, errBookLent.Error())
+		}
+		return nil, grpcFailed(ctx, "error deleting book", err, "book_id", book.ID)
+	}
+	// The cover row goes with the book, its images have to be removed here
+	s.h.deleteCoverBlobs(ctx, book.ID)
+	metrics.BooksDeleted.Inc()
+	return &emptypb.Empty{}, nil
+}
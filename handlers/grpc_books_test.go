@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	booksv1 "connection_to_pg/proto/books/v1"
+	"context"
+	"database/sql/driver"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// dialBooksService serves the BooksService of handler in memory and
+// returns a client of it
+func dialBooksService(t *testing.T, handler *Handler) booksv1.BooksServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(handler.AuthenticateUnary),
+		grpc.StreamInterceptor(handler.AuthenticateStream),
+	)
+	booksv1.RegisterBooksServiceServer(server, handler.BooksService())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return booksv1.NewBooksServiceClient(conn)
+}
+
+func TestBooksService_CreateBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	client := dialBooksService(t, &Handler{DB: mockDB})
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Book).ID = 5
+	}).Return(nil).Once()
+
+	book, err := client.CreateBook(context.Background(), &booksv1.CreateBookRequest{
+		Book: &booksv1.BookInput{Name: "Dune", Isbn13: proto.String("978-0-441-17271-9")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), book.GetId())
+	assert.Equal(t, "9780441172719", book.GetIsbn13())
+	assert.Equal(t, "0441172717", book.GetIsbn10())
+
+	_, err = client.CreateBook(context.Background(), &booksv1.CreateBookRequest{
+		Book: &booksv1.BookInput{Name: "Dune", Isbn13: proto.String("978-0-441-17271-0")},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.CreateBook(context.Background(), &booksv1.CreateBookRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Return(gorm.ErrDuplicatedKey)
+	_, err = client.CreateBook(context.Background(), &booksv1.CreateBookRequest{
+		Book: &booksv1.BookInput{Name: "Dune", Isbn13: proto.String("9780441172719")},
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Equal(t, "A book with ISBN 9780441172719 already exists", status.Convert(err).Message())
+}
+
+func TestBooksService_GetBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	client := dialBooksService(t, &Handler{DB: mockDB})
+	expectBook(mockDB, 1)
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 2).Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+
+	book, err := client.GetBook(context.Background(), &booksv1.GetBookRequest{Id: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), book.GetId())
+
+	_, err = client.GetBook(context.Background(), &booksv1.GetBookRequest{Id: 2})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestBooksService_ListBooks(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	client := dialBooksService(t, &Handler{DB: mockDB})
+	conn := &scriptedConn{answer: func(string) ([]string, [][]driver.Value) {
+		return []string{"id", "name", "rating_average"}, [][]driver.Value{{int64(1), "Dune", 3.0}, {int64(2), "Dune Messiah", 4.5}}
+	}}
+	mockDB.On("Model", &models.Book{}).Return(openScripted(t, conn).Model(&models.Book{}).Session(&gorm.Session{}))
+
+	stream, err := client.ListBooks(context.Background(), &booksv1.ListBooksRequest{Author: "Frank Herbert"})
+	require.NoError(t, err)
+	var ids []int64
+	for {
+		book, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ids = append(ids, book.GetId())
+	}
+	assert.Equal(t, []int64{1, 2}, ids)
+	assert.Equal(t, `SELECT * FROM "books" WHERE author = $1 ORDER BY id`, conn.statements[0])
+	mockDB.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+
+	stream, err = client.ListBooks(context.Background(), &booksv1.ListBooksRequest{Author: "Frank Herbert", Sort: "-rating"})
+	require.NoError(t, err)
+	for err == nil {
+		_, err = stream.Recv()
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Contains(t, conn.statements[1], "WHERE author = $1 ORDER BY rating_average DESC, id")
+
+	stream, err = client.ListBooks(context.Background(), &booksv1.ListBooksRequest{Sort: "name"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestBooksService_UpdateAndDeleteBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	client := dialBooksService(t, &Handler{DB: mockDB})
+	expectBook(mockDB, 1)
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 2).Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+	mockDB.On("Save", mock.AnythingOfType("*models.Book")).Return(&gorm.DB{})
+	mockDB.On("Delete", mock.AnythingOfType("*models.Book")).Return(&gorm.DB{})
+
+	book, err := client.UpdateBook(context.Background(), &booksv1.UpdateBookRequest{Id: 1, Book: &booksv1.BookInput{Name: "Emma"}})
+	require.NoError(t, err)
+	assert.Equal(t, "Emma", book.GetName())
+
+	_, err = client.DeleteBook(context.Background(), &booksv1.DeleteBookRequest{Id: 1})
+	require.NoError(t, err)
+	_, err = client.DeleteBook(context.Background(), &booksv1.DeleteBookRequest{Id: 2})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	mockDB.AssertNumberOfCalls(t, "Delete", 1)
+}
+
+func TestBooksService_RefusesInvalidAPIKey(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	client := dialBooksService(t, &Handler{DB: mockDB})
+	mockDB.On("First", mock.AnythingOfType("*models.APIKey"), "hash = ? AND revoked_at IS NULL").Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer bk_revoked")
+	_, err := client.GetBook(ctx, &booksv1.GetBookRequest{Id: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err := client.ListBooks(ctx, &booksv1.ListBooksRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
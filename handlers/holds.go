@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// holdBody is the request payload for placing a hold
+type holdBody struct {
+	MemberID int `json:"member_id" xml:"member_id"`
+}
+
+// circulation summarises the copies of a book and its holds queue
+type circulation struct {
+	Copies    int `json:"copies" xml:"copies"`
+	Available int `json:"available" xml:"available"`
+	OnLoan    int `json:"on_loan" xml:"on_loan"`
+	// Reserved copies wait on the hold shelf for a member to pick them up
+	Reserved int `json:"reserved" xml:"reserved"`
+	Holds    int `json:"holds" xml:"holds"`
+	// EstimatedAvailableAt is when a hold placed now would likely be ready
+	EstimatedAvailableAt *time.Time      `json:"estimated_available_at,omitempty" xml:"estimated_available_at,omitempty"`
+	Queue                []queuePosition `json:"queue" xml:"queue"`
+}
+
+// queuePosition is an active hold as shown on the book, without the member
+type queuePosition struct {
+	HoldID               int        `json:"hold_id" xml:"hold_id"`
+	Position             int        `json:"position" xml:"position"`
+	Status               string     `json:"status" xml:"status"`
+	EstimatedAvailableAt *time.Time `json:"estimated_available_at,omitempty" xml:"estimated_available_at,omitempty"`
+	ExpiresAt            *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+}
+
+var (
+	errHoldNotFound  = errors.New("hold not found")
+	errHoldClosed    = errors.New("hold is no longer active")
+	errDuplicateHold = errors.New("member already has an active hold on this book")
+	errCopyAvailable = errors.New("a copy is available, check it out instead")
+	errCopyReserved  = errors.New("copy is reserved for another member")
+)
+
+// PlaceHold queues a member for a book whose copies are all out
+func (h *Handler) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+	var body holdBody
+	if err := decodeBody(r, &body); err != nil || body.MemberID <= 0 {
+		renderError(w, r, http.StatusBadRequest, "member_id is required")
+		return
+	}
+
+	var hold models.Hold
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		now := clock()
+		if err := lockBook(tx, bookID); err != nil {
+			return err
+		}
+		var member models.Member
+		if err := tx.First(&member, body.MemberID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errMemberNotFound
+			}
+			return err
+		}
+		if err := expireHolds(tx, bookID, now); err != nil {
+			return err
+		}
+
+		var available int64
+		err := tx.Model(&models.Copy{}).
+			Where("book_id = ?", bookID).
+			Where("id NOT IN (SELECT copy_id FROM loans WHERE returned_at IS NULL)").
+			Where("id NOT IN (SELECT copy_id FROM holds WHERE status = ? AND copy_id IS NOT NULL)", models.HoldReady).
+			Count(&available).Error
+		if err != nil {
+			return err
+		}
+		if available > 0 {
+			return errCopyAvailable
+		}
+
+		hold = models.Hold{BookID: bookID, MemberID: member.ID, Status: models.HoldWaiting, CreatedAt: now}
+		if err := tx.Create(&hold).Error; err != nil {
+			if db.IsUniqueViolation(err) {
+				return errDuplicateHold
+			}
+			return err
+		}
+		var ahead int64
+		err = tx.Model(&models.Hold{}).
+			Where("book_id = ? AND status = ? AND (created_at, id) < (?, ?)", bookID, models.HoldWaiting, hold.CreatedAt, hold.ID).
+			Count(&ahead).Error
+		hold.Position = int(ahead) + 1
+		return err
+	})
+	if !h.holdWritten(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", "/holds/"+strconv.Itoa(hold.ID))
+	render(w, r, http.StatusCreated, hold)
+}
+
+// holdWritten answers the request when a holds transaction failed
+func (h *Handler) holdWritten(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errBookNotFound):
+		renderError(w, r, http.StatusNotFound, "Book not found")
+	case errors.Is(err, errHoldNotFound):
+		renderError(w, r, http.StatusNotFound, "Hold not found")
+	case errors.Is(err, errMemberNotFound):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errDuplicateHold), errors.Is(err, errCopyAvailable), errors.Is(err, errHoldClosed):
+		renderError(w, r, http.StatusConflict, err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("error writing hold", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update hold")
+	}
+	return false
+}
+
+// lockBook takes a row lock on a book. Every change to a book's holds
+// queue holds it, so allocations for one book happen one at a time.
+func lockBook(tx *gorm.DB, bookID int) error {
+	var book models.Book
+	if err := tx.Clauses(lockForUpdate).First(&book, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errBookNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// allocateCopy sets a free copy aside for the next waiting hold on the
+// book. It returns nil when nobody is waiting and the copy goes back on
+// the shelf. The caller holds the book lock.
+func allocateCopy(tx *gorm.DB, bookID, copyID int, now time.Time) (*models.Hold, error) {
+	var hold models.Hold
+	err := tx.Clauses(lockForUpdate).
+		Where("book_id = ? AND status = ?", bookID, models.HoldWaiting).
+		Order("created_at, id").
+		First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hold.Allocate(copyID, now)
+	if err := tx.Save(&hold).Error; err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// expireHolds closes the ready holds of a book whose pickup window has
+// passed and hands their copies to the next members in line. The caller
+// holds the book lock.
+func expireHolds(tx *gorm.DB, bookID int, now time.Time) error {
+	var expired []models.Hold
+	err := tx.Clauses(lockForUpdate).
+		Where("book_id = ? AND status = ? AND expires_at < ?", bookID, models.HoldReady, now).
+		Order("expires_at, id").
+		Find(&expired).Error
+	if err != nil {
+		return err
+	}
+	for _, hold := range expired {
+		copyID := hold.CopyID
+		hold.Close(models.HoldExpired, now)
+		if err := tx.Save(&hold).Error; err != nil {
+			return err
+		}
+		if copyID == nil {
+			continue
+		}
+		if _, err := allocateCopy(tx, bookID, *copyID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimCopy lets a member check out a copy with respect to the holds
+// queue: a copy set aside for someone else is refused, and the member's
+// own active hold on the book is fulfilled. The caller holds the book lock.
+func claimCopy(tx *gorm.DB, bookCopy models.Copy, memberID int, now time.Time) error {
+	if err := expireHolds(tx, bookCopy.BookID, now); err != nil {
+		return err
+	}
+	var reserved models.Hold
+	err := tx.Where("copy_id = ? AND status = ?", bookCopy.ID, models.HoldReady).First(&reserved).Error
+	if err == nil && reserved.MemberID != memberID {
+		return errCopyReserved
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var own models.Hold
+	err = tx.Where("book_id = ? AND member_id = ? AND closed_at IS NULL", bookCopy.BookID, memberID).First(&own).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if own.Status == models.HoldReady && own.CopyID != nil && *own.CopyID != bookCopy.ID {
+		// The member took another copy; the one set aside moves on
+		if _, err := allocateCopy(tx, bookCopy.BookID, *own.CopyID, now); err != nil {
+			return err
+		}
+	}
+	own.Close(models.HoldFulfilled, now)
+	return tx.Save(&own).Error
+}
+
+// findHold loads the hold named by the {id} parameter and answers the
+// request itself when that fails
+func (h *Handler) findHold(w http.ResponseWriter, r *http.Request) (models.Hold, bool) {
+	var hold models.Hold
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid hold ID")
+		return hold, false
+	}
+	if err := h.dbFor(r).First(&hold, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Hold not found")
+			return hold, false
+		}
+		logging.FromContext(r.Context()).Error("error querying hold", "hold_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return hold, false
+	}
+	return hold, true
+}
+
+// GetHold fetches a hold with its current place in the queue
+func (h *Handler) GetHold(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	hold, ok := h.findHold(w, r)
+	if !ok {
+		return
+	}
+	if hold.Active() {
+		_, queue, err := h.bookCirculation(r, hold.BookID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("error querying holds queue", "book_id", hold.BookID, "error", err)
+			renderError(w, r, http.StatusInternalServerError, "Failed to retrieve hold")
+			return
+		}
+		for _, queued := range queue {
+			if queued.ID == hold.ID {
+				hold = queued
+			}
+		}
+	}
+	render(w, r, http.StatusOK, hold)
+}
+
+// CancelHold withdraws an active hold. A copy that was set aside for it is
+// offered to the next member in line.
+func (h *Handler) CancelHold(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	hold, ok := h.findHold(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.transaction(r, func(tx *gorm.DB) error {
+		now := clock()
+		if err := lockBook(tx, hold.BookID); err != nil {
+			return err
+		}
+		if err := tx.Clauses(lockForUpdate).First(&hold, hold.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errHoldNotFound
+			}
+			return err
+		}
+		if !hold.Active() {
+			return errHoldClosed
+		}
+		copyID := hold.CopyID
+		hold.Close(models.HoldCancelled, now)
+		if err := tx.Save(&hold).Error; err != nil {
+			return err
+		}
+		if copyID == nil {
+			return nil
+		}
+		_, err := allocateCopy(tx, hold.BookID, *copyID, now)
+		return err
+	})
+	if !h.holdWritten(w, r, err) {
+		return
+	}
+
+	render(w, r, http.StatusOK, hold)
+}
+
+// GetBookHolds lists the active holds on a book in queue order
+func (h *Handler) GetBookHolds(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	// Bring the queue up to date before showing it
+	err := h.transaction(r, func(tx *gorm.DB) error {
+		if err := lockBook(tx, book.ID); err != nil {
+			return err
+		}
+		return expireHolds(tx, book.ID, clock())
+	})
+	if !h.holdWritten(w, r, err) {
+		return
+	}
+	_, queue, err := h.bookCirculation(r, book.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error querying holds queue", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve holds")
+		return
+	}
+
+	render(w, r, http.StatusOK, queue)
+}
+
+// bookCirculation loads the copies, open loans and active holds of a book
+// and summarises them
+func (h *Handler) bookCirculation(r *http.Request, bookID int) (circulation, []models.Hold, error) {
+	var copies []models.Copy
+	if err := h.dbFor(r).Find(&copies, "book_id = ?", bookID).Error; err != nil {
+		return circulation{}, nil, err
+	}
+	var loans []models.Loan
+	if err := h.dbFor(r).Find(&loans, "returned_at IS NULL AND copy_id IN (SELECT id FROM copies WHERE book_id = ?)", bookID).Error; err != nil {
+		return circulation{}, nil, err
+	}
+	var holds []models.Hold
+	if err := h.dbFor(r).Find(&holds, "book_id = ? AND closed_at IS NULL", bookID).Error; err != nil {
+		return circulation{}, nil, err
+	}
+	summary, queue := summariseCirculation(copies, loans, holds, clock())
+	return summary, queue, nil
+}
+
+// summariseCirculation counts a book's copies by state and numbers its
+// active holds: ready holds first with position 0, then the waiting holds
+// from 1 in the order they were placed, each with an availability estimate
+func summariseCirculation(copies []models.Copy, openLoans []models.Loan, activeHolds []models.Hold, now time.Time) (circulation, []models.Hold) {
+	summary := circulation{Copies: len(copies), OnLoan: len(openLoans), Queue: []queuePosition{}}
+	dueDates := make([]time.Time, len(openLoans))
+	for i, loan := range openLoans {
+		dueDates[i] = loan.DueAt
+	}
+
+	var ready, waiting []models.Hold
+	for _, hold := range activeHolds {
+		if hold.Status == models.HoldReady {
+			ready = append(ready, hold)
+		} else {
+			waiting = append(waiting, hold)
+		}
+	}
+	models.SortHolds(ready)
+	models.SortHolds(waiting)
+	summary.Reserved = len(ready)
+	summary.Holds = len(waiting)
+	summary.Available = max(0, summary.Copies-summary.OnLoan-summary.Reserved)
+
+	queue := append(ready, waiting...)
+	for i := range queue {
+		hold := &queue[i]
+		if hold.Status == models.HoldWaiting {
+			hold.Position = i - len(ready) + 1
+			hold.EstimatedAvailableAt = models.EstimateAvailability(hold.Position, dueDates, summary.Available, now)
+		}
+		summary.Queue = append(summary.Queue, queuePosition{
+			HoldID:               hold.ID,
+			Position:             hold.Position,
+			Status:               hold.Status,
+			EstimatedAvailableAt: hold.EstimatedAvailableAt,
+			ExpiresAt:            hold.ExpiresAt,
+		})
+	}
+	if summary.Copies > 0 {
+		summary.EstimatedAvailableAt = models.EstimateAvailability(len(waiting)+1, dueDates, summary.Available, now)
+	}
+	if queue == nil {
+		queue = []models.Hold{}
+	}
+	return summary, queue
+}
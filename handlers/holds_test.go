@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// expectCirculation answers the queries bookCirculation makes
+func expectCirculation(mockDB *mocks.MockDB, copies []models.Copy, loans []models.Loan, holds []models.Hold) {
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Copy"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Copy) = copies
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Loan"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Loan) = loans
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Hold"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Hold) = holds
+	}).Return(&gorm.DB{})
+}
+
+func TestSummariseCirculation(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	copies := []models.Copy{{ID: 1}, {ID: 2}, {ID: 3}}
+	loans := []models.Loan{
+		{CopyID: 1, DueAt: now.Add(10 * 24 * time.Hour)},
+		{CopyID: 2, DueAt: now.Add(2 * 24 * time.Hour)},
+	}
+	expires := now.Add(models.PickupWindow)
+	copyID := 3
+	holds := []models.Hold{
+		{ID: 9, Status: models.HoldWaiting, CreatedAt: now.Add(-time.Hour)},
+		{ID: 7, Status: models.HoldWaiting, CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: 5, Status: models.HoldReady, CopyID: &copyID, ExpiresAt: &expires, CreatedAt: now.Add(-72 * time.Hour)},
+		{ID: 8, Status: models.HoldWaiting, CreatedAt: now.Add(-time.Hour)},
+	}
+
+	summary, queue := summariseCirculation(copies, loans, holds, now)
+
+	assert.Equal(t, 3, summary.Copies)
+	assert.Equal(t, 0, summary.Available)
+	assert.Equal(t, 2, summary.OnLoan)
+	assert.Equal(t, 1, summary.Reserved)
+	assert.Equal(t, 3, summary.Holds)
+
+	ids := make([]int, len(queue))
+	positions := make([]int, len(queue))
+	for i, hold := range queue {
+		ids[i], positions[i] = hold.ID, hold.Position
+	}
+	assert.Equal(t, []int{5, 7, 8, 9}, ids)
+	assert.Equal(t, []int{0, 1, 2, 3}, positions)
+	assert.Nil(t, queue[0].EstimatedAvailableAt)
+	// The next two members get the copies as their loans come due, the
+	// third waits for the first of them to come back again
+	assert.Equal(t, now.Add(2*24*time.Hour), *queue[1].EstimatedAvailableAt)
+	assert.Equal(t, now.Add(10*24*time.Hour), *queue[2].EstimatedAvailableAt)
+	assert.Equal(t, now.Add(2*24*time.Hour+models.LoanPeriod), *queue[3].EstimatedAvailableAt)
+	assert.Equal(t, now.Add(10*24*time.Hour+models.LoanPeriod), *summary.EstimatedAvailableAt)
+}
+
+func TestGet_Circulation(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	fixClock(t, now)
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), 4).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Book) = models.Book{ID: 4, Name: "Dune"}
+	}).Return(&gorm.DB{})
+	expectCirculation(mockDB,
+		[]models.Copy{{ID: 1, BookID: 4}},
+		[]models.Loan{{CopyID: 1, DueAt: now.Add(24 * time.Hour)}},
+		[]models.Hold{{ID: 3, BookID: 4, MemberID: 2, Status: models.HoldWaiting}})
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("query", "4")
+	req := httptest.NewRequest(http.MethodGet, "/books/4", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handler.Get(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Name        string      `json:"name"`
+		Circulation circulation `json:"circulation"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "Dune", body.Name)
+	assert.Equal(t, 1, body.Circulation.Holds)
+	require.Len(t, body.Circulation.Queue, 1)
+	assert.Equal(t, queuePosition{HoldID: 3, Position: 1, Status: models.HoldWaiting, EstimatedAvailableAt: body.Circulation.Queue[0].EstimatedAvailableAt}, body.Circulation.Queue[0])
+	assert.Equal(t, now.Add(24*time.Hour), body.Circulation.Queue[0].EstimatedAvailableAt.UTC())
+	assert.NotContains(t, w.Body.String(), "member_id")
+}
+
+func TestPlaceHold_Rejected(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errCopyAvailable, http.StatusConflict},
+		{errDuplicateHold, http.StatusConflict},
+		{errBookNotFound, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			mockDB := new(mocks.MockDB)
+			handler := &Handler{DB: mockDB}
+			mockDB.On("Transaction", mock.Anything).Return(tt.err)
+
+			req := withID(httptest.NewRequest(http.MethodPost, "/books/1/holds", strings.NewReader(`{"member_id":2}`)), "1")
+			w := httptest.NewRecorder()
+			handler.PlaceHold(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestHold_Allocate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	hold := models.Hold{Status: models.HoldWaiting}
+
+	hold.Allocate(6, now)
+
+	assert.Equal(t, models.HoldReady, hold.Status)
+	assert.Equal(t, 6, *hold.CopyID)
+	assert.Equal(t, now.Add(models.PickupWindow), *hold.ExpiresAt)
+	assert.True(t, hold.Active())
+
+	hold.Close(models.HoldExpired, now)
+	assert.False(t, hold.Active())
+}
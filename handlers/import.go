@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"connection_to_pg/requestid"
+	"connection_to_pg/transfer"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Import streams a CSV, NDJSON or JSON upload into the books table.
+//
+// Query parameters:
+//
+//	format    csv, ndjson or json; defaults to the Content-Type of the upload
+//	map       column mapping such as "title:name,writer:author"
+//	key       natural key to upsert by, e.g. "name" or "name,author"
+//	dry_run   validate and resolve rows without writing
+//	on_error  "continue" (default) or "abort" to stop at the first bad row
+//
+// The body is either the raw file or a multipart form with a "file" part.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	query := r.URL.Query()
+
+	mapping, err := transfer.ParseMapping(query.Get("map"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	key, err := transfer.ParseKey(query.Get("key"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := transfer.ImportOptions{Key: key}
+	if opts.DryRun, err = parseBoolParam(query.Get("dry_run")); err != nil {
+		renderError(w, r, http.StatusBadRequest, "dry_run must be true or false")
+		return
+	}
+	switch query.Get("on_error") {
+	case "", "continue":
+	case "abort":
+		opts.AbortOnError = true
+	default:
+		renderError(w, r, http.StatusBadRequest, `on_error must be "continue" or "abort"`)
+		return
+	}
+
+	body, format, err := importSource(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f := query.Get("format"); f != "" {
+		format = f
+	}
+	if format == "" {
+		renderError(w, r, http.StatusUnsupportedMediaType, "Unable to determine the upload format, set ?format=csv|ndjson|json")
+		return
+	}
+
+	decoder, err := transfer.NewDecoder(body, format, mapping)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := transfer.Import(h.dbFor(r), decoder, opts)
+	countImport(report)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("error reading import stream", "rows", report.Processed, "error", err)
+		render(w, r, http.StatusBadRequest, importFailure{
+			Error:     "Failed to read upload: " + err.Error(),
+			RequestID: requestid.FromContext(r.Context()),
+			Report:    report,
+		})
+		return
+	}
+
+	render(w, r, http.StatusOK, report)
+}
+
+// countImport adds the books an import wrote to the business counters
+func countImport(report transfer.ImportReport) {
+	if report.DryRun {
+		return
+	}
+	metrics.BooksCreated.Add(float64(report.Created))
+	metrics.BooksUpdated.Add(float64(report.Updated))
+}
+
+// importFailure is returned when the upload stream broke off midway
+type importFailure struct {
+	XMLName   xml.Name              `json:"-" xml:"response"`
+	Error     string                `json:"error" xml:"error"`
+	RequestID string                `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	Report    transfer.ImportReport `json:"report" xml:"report"`
+}
+
+// importSource returns the upload stream and the format implied by its type
+func importSource(r *http.Request) (io.Reader, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, transfer.FormatFromContentType(mediaType), nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", errors.New(`multipart upload has no "file" part`)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		format := transfer.FormatFromContentType(part.Header.Get("Content-Type"))
+		if format == "" {
+			format = transfer.FormatFromFilename(part.FileName())
+		}
+		return part, format, nil
+	}
+}
+
+func parseBoolParam(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport_CSVSuccess(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Return(nil).Twice()
+
+	body := "Title,Writer\nDune,Frank Herbert\nEmma,Jane Austen\n"
+	req := httptest.NewRequest(http.MethodPost, "/books/import?map=title:name,writer:author", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+
+	handler.Import(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var report transfer.ImportReport
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 0, report.Failed)
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestImport_MultipartNDJSONDryRun(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "books.ndjson")
+	require.NoError(t, err)
+	part.Write([]byte("{\"name\":\"Dune\"}\n{\"author\":\"Nameless\"}\n"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/books/import?dry_run=true", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	handler.Import(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var report transfer.ImportReport
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, []transfer.RowError{{Row: 2, Field: "name", Error: "name is required"}}, report.Errors)
+
+	mockDB.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestImport_UnknownFormat(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB)}
+
+	req := httptest.NewRequest(http.MethodPost, "/books/import", strings.NewReader("whatever"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rr := httptest.NewRecorder()
+
+	handler.Import(rr, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+}
+
+func TestImport_InvalidKey(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB)}
+
+	req := httptest.NewRequest(http.MethodPost, "/books/import?key=description", strings.NewReader("name\nDune\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+
+	handler.Import(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `unknown key field \"description\"`)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestCreate_NormalizesISBN(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.MatchedBy(func(b *models.Book) bool {
+		return *b.ISBN13 == "9780306406157" && *b.ISBN10 == "0306406152"
+	})).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(`{"name":"Dune","isbn10":"0-306-40615-2"}`))
+	w := httptest.NewRecorder()
+	handler.Create(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreate_InvalidISBN(t *testing.T) {
+	bodies := []string{
+		`{"name":"Dune","isbn13":"978-0-306-40615-8"}`,
+		`{"name":"Dune","isbn13":"9780306406157","isbn10":"080442957X"}`,
+	}
+	for _, body := range bodies {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+
+		w := httptest.NewRecorder()
+		handler.Create(w, httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		mockDB.AssertNotCalled(t, "Create", mock.Anything)
+	}
+}
+
+func TestCreate_DuplicateISBN(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Return(&pgconn.PgError{Code: "23505"})
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), "isbn13 = ?").Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Book).ID = 12
+	}).Return(&gorm.DB{})
+
+	req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(`{"name":"Dune","isbn13":"978-0-306-40615-7"}`))
+	w := httptest.NewRecorder()
+	handler.Create(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "/books/12", w.Header().Get("Location"))
+	assert.JSONEq(t, `{"error":"A book with ISBN 9780306406157 already exists","existing":"/books/12"}`, w.Body.String())
+}
+
+func TestGetByISBN(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), "isbn13 = ?").Run(func(args mock.Arguments) {
+		isbn13 := "9780306406157"
+		*args.Get(0).(*models.Book) = models.Book{ID: 12, Name: "Dune", ISBN13: &isbn13}
+	}).Return(&gorm.DB{})
+
+	for _, value := range []string{"0-306-40615-2", "bogus"} {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("isbn", value)
+		req := httptest.NewRequest(http.MethodGet, "/books/isbn/"+value, nil)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+
+		handler.GetByISBN(w, req)
+
+		if value == "bogus" {
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			continue
+		}
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":12,"name":"Dune","description":"","author":"","isbn13":"9780306406157"}`, w.Body.String())
+	}
+}
@@ -0,0 +1,503 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// clock returns the current time; tests replace it to move time forward
+var clock = time.Now
+
+// copyBody is the request payload for adding copies
+type copyBody struct {
+	Barcode string `json:"barcode" xml:"barcode"`
+}
+
+// checkoutBody is the request payload for lending a copy to a member
+type checkoutBody struct {
+	CopyID   int `json:"copy_id" xml:"copy_id"`
+	MemberID int `json:"member_id" xml:"member_id"`
+}
+
+// copyStatus is a copy together with its open loan, if any
+type copyStatus struct {
+	models.Copy
+	Available bool       `json:"available" xml:"available"`
+	DueAt     *time.Time `json:"due_at,omitempty" xml:"due_at,omitempty"`
+}
+
+// overdueLoan is one line of the overdue report
+type overdueLoan struct {
+	XMLName     xml.Name  `json:"-" xml:"loan"`
+	LoanID      int       `json:"loan_id" xml:"loan_id"`
+	CopyID      int       `json:"copy_id" xml:"copy_id"`
+	Barcode     string    `json:"barcode" xml:"barcode"`
+	BookID      int       `json:"book_id" xml:"book_id"`
+	BookName    string    `json:"book_name" xml:"book_name"`
+	MemberID    int       `json:"member_id" xml:"member_id"`
+	MemberName  string    `json:"member_name" xml:"member_name"`
+	MemberEmail string    `json:"member_email" xml:"member_email"`
+	DueAt       time.Time `json:"due_at" xml:"due_at"`
+	DaysOverdue int       `json:"days_overdue" xml:"days_overdue"`
+}
+
+var (
+	errLoanNotFound   = errors.New("loan not found")
+	errCopyNotFound   = errors.New("copy not found")
+	errMemberNotFound = errors.New("member not found")
+	errCopyOnLoan     = errors.New("copy is already on loan")
+	errLoanLimit      = errors.New("member has reached their loan limit")
+	errLoanReturned   = errors.New("loan has already been returned")
+	errRenewalLimit   = errors.New("loan can't be renewed again")
+	errOverdueRenewal = errors.New("overdue loans can't be renewed, return the copy first")
+)
+
+// AddCopy registers a physical copy of a book
+func (h *Handler) AddCopy(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	var body copyBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	body.Barcode = strings.TrimSpace(body.Barcode)
+	if body.Barcode == "" {
+		renderError(w, r, http.StatusBadRequest, "Barcode is required")
+		return
+	}
+
+	fresh := models.Copy{BookID: book.ID, Barcode: body.Barcode}
+	bookCopy := fresh
+	err := h.transaction(r, func(tx *gorm.DB) error {
+		// A run again starts over from the copy as requested
+		bookCopy = fresh
+		if err := lockBook(tx, book.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(&bookCopy).Error; err != nil {
+			return err
+		}
+		// A new copy goes straight to the first member waiting for it
+		_, err := allocateCopy(tx, book.ID, bookCopy.ID, clock())
+		return err
+	})
+	switch {
+	case errors.Is(err, errBookNotFound):
+		renderError(w, r, http.StatusNotFound, "Book not found")
+		return
+	case db.IsUniqueViolation(err):
+		renderError(w, r, http.StatusConflict, "A copy with this barcode already exists")
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("error creating copy", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to add copy")
+		return
+	}
+
+	render(w, r, http.StatusCreated, bookCopy)
+}
+
+// GetBookCopies lists the copies of a book and whether they are on the
+// shelf
+func (h *Handler) GetBookCopies(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	var copies []models.Copy
+	if err := h.dbFor(r).Find(&copies, "book_id = ?", book.ID).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying copies", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve copies")
+		return
+	}
+	var loans []models.Loan
+	err := h.dbFor(r).Find(&loans, "returned_at IS NULL AND copy_id IN (SELECT id FROM copies WHERE book_id = ?)", book.ID).Error
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error querying loans", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve copies")
+		return
+	}
+
+	render(w, r, http.StatusOK, copyStatuses(copies, loans))
+}
+
+// copyStatuses pairs copies with their open loans, ordered by ID
+func copyStatuses(copies []models.Copy, openLoans []models.Loan) []copyStatus {
+	due := make(map[int]time.Time, len(openLoans))
+	for _, loan := range openLoans {
+		due[loan.CopyID] = loan.DueAt
+	}
+	statuses := make([]copyStatus, len(copies))
+	for i, c := range copies {
+		statuses[i] = copyStatus{Copy: c, Available: true}
+		if at, ok := due[c.ID]; ok {
+			statuses[i].Available = false
+			statuses[i].DueAt = &at
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// Checkout lends a copy to a member. The member, copy and book rows are
+// locked, in that order, so concurrent checkouts can neither lend the same
+// copy twice nor take a member past their loan limit. A copy set aside for
+// a hold can only be lent to the member who placed it.
+func (h *Handler) Checkout(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+
+	var body checkoutBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil || body.CopyID <= 0 || body.MemberID <= 0 {
+		renderError(w, r, http.StatusBadRequest, "copy_id and member_id are required")
+		return
+	}
+
+	var loan models.Loan
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		var err error
+		loan, err = checkout(tx, h.Fines, body.MemberID, body.CopyID, clock())
+		return err
+	})
+	if !h.loanWritten(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", "/loans/"+strconv.Itoa(loan.ID))
+	render(w, r, http.StatusCreated, loan)
+}
+
+// checkout creates the loan inside a transaction
+func checkout(tx *gorm.DB, policy models.FinePolicy, memberID, copyID int, now time.Time) (models.Loan, error) {
+	var member models.Member
+	if err := tx.Clauses(lockForUpdate).First(&member, memberID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Loan{}, errMemberNotFound
+		}
+		return models.Loan{}, err
+	}
+	balance, err := fineBalance(tx, member.ID)
+	if err != nil {
+		return models.Loan{}, err
+	}
+	if balance > policy.BlockThreshold {
+		return models.Loan{}, errFinesOwed
+	}
+	var bookCopy models.Copy
+	if err := tx.Clauses(lockForUpdate).First(&bookCopy, copyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Loan{}, errCopyNotFound
+		}
+		return models.Loan{}, err
+	}
+
+	var onLoan int64
+	if err := tx.Model(&models.Loan{}).Where("copy_id = ? AND returned_at IS NULL", bookCopy.ID).Count(&onLoan).Error; err != nil {
+		return models.Loan{}, err
+	}
+	if onLoan > 0 {
+		return models.Loan{}, errCopyOnLoan
+	}
+	if err := lockBook(tx, bookCopy.BookID); err != nil {
+		return models.Loan{}, err
+	}
+	if err := claimCopy(tx, bookCopy, member.ID, now); err != nil {
+		return models.Loan{}, err
+	}
+	var open int64
+	if err := tx.Model(&models.Loan{}).Where("member_id = ? AND returned_at IS NULL", member.ID).Count(&open).Error; err != nil {
+		return models.Loan{}, err
+	}
+	if open >= int64(member.LoanLimit) {
+		return models.Loan{}, errLoanLimit
+	}
+
+	loan := models.Loan{
+		CopyID:       bookCopy.ID,
+		MemberID:     member.ID,
+		CheckedOutAt: now,
+		DueAt:        now.Add(models.LoanPeriod),
+	}
+	if err := tx.Create(&loan).Error; err != nil {
+		// The partial unique index catches what the lock can't: a loan
+		// opened outside this code path
+		if db.IsUniqueViolation(err) {
+			return models.Loan{}, errCopyOnLoan
+		}
+		return models.Loan{}, err
+	}
+	return loan, nil
+}
+
+// loanWritten answers the request when a circulation transaction failed
+func (h *Handler) loanWritten(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errLoanNotFound):
+		renderError(w, r, http.StatusNotFound, "Loan not found")
+	case errors.Is(err, errMemberNotFound), errors.Is(err, errCopyNotFound):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errCopyOnLoan), errors.Is(err, errLoanReturned), errors.Is(err, errCopyReserved):
+		renderError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, errLoanLimit), errors.Is(err, errFinesOwed), errors.Is(err, errRenewalLimit), errors.Is(err, errOverdueRenewal):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("error writing loan", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update loan")
+	}
+	return false
+}
+
+// lockLoan loads the open loan named by the {id} parameter with a row lock
+func lockLoan(tx *gorm.DB, id int) (models.Loan, error) {
+	var loan models.Loan
+	if err := tx.Clauses(lockForUpdate).First(&loan, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return loan, errLoanNotFound
+		}
+		return loan, err
+	}
+	if !loan.Open() {
+		return loan, errLoanReturned
+	}
+	return loan, nil
+}
+
+// RenewLoan extends an open loan by another loan period, counted from its
+// current due date
+func (h *Handler) RenewLoan(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid loan ID")
+		return
+	}
+
+	var loan models.Loan
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		var err error
+		if loan, err = lockLoan(tx, id); err != nil {
+			return err
+		}
+		if err := renew(&loan, clock()); err != nil {
+			return err
+		}
+		return tx.Save(&loan).Error
+	})
+	if !h.loanWritten(w, r, err) {
+		return
+	}
+
+	render(w, r, http.StatusOK, loan)
+}
+
+// renew applies the renewal rules to an open loan
+func renew(loan *models.Loan, now time.Time) error {
+	if loan.Overdue(now) {
+		return errOverdueRenewal
+	}
+	if loan.Renewals >= models.MaxRenewals {
+		return errRenewalLimit
+	}
+	loan.Renewals++
+	loan.DueAt = loan.DueAt.Add(models.LoanPeriod)
+	return nil
+}
+
+// returnedLoan is a returned loan with the fine charged for it and the
+// hold its copy was set aside for, if any
+type returnedLoan struct {
+	models.Loan
+	Fine *models.FineEntry `json:"fine,omitempty" xml:"fine,omitempty"`
+	Hold *models.Hold      `json:"hold,omitempty" xml:"hold,omitempty"`
+}
+
+// ReturnLoan checks a copy back in, charges a late return to the member's
+// fine ledger and allocates the copy to the next member waiting for it
+func (h *Handler) ReturnLoan(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid loan ID")
+		return
+	}
+
+	var returned returnedLoan
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		returned = returnedLoan{}
+		var err error
+		if returned.Loan, err = lockLoan(tx, id); err != nil {
+			return err
+		}
+		now := clock()
+		returned.ReturnedAt = &now
+		if err := tx.Save(&returned.Loan).Error; err != nil {
+			return err
+		}
+		if returned.Fine, err = chargeLateReturn(tx, h.Fines, returned.Loan); err != nil {
+			return err
+		}
+
+		var bookCopy models.Copy
+		if err := tx.First(&bookCopy, returned.CopyID).Error; err != nil {
+			return err
+		}
+		if err := lockBook(tx, bookCopy.BookID); err != nil {
+			return err
+		}
+		if err := expireHolds(tx, bookCopy.BookID, now); err != nil {
+			return err
+		}
+		returned.Hold, err = allocateCopy(tx, bookCopy.BookID, bookCopy.ID, now)
+		return err
+	})
+	if !h.loanWritten(w, r, err) {
+		return
+	}
+
+	render(w, r, http.StatusOK, returned)
+}
+
+func (h *Handler) GetLoan(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid loan ID")
+		return
+	}
+
+	var loan models.Loan
+	if err := h.dbFor(r).First(&loan, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Loan not found")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error querying loan", "loan_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	render(w, r, http.StatusOK, loan)
+}
+
+// GetOverdueLoans reports every open loan past its due date, most overdue
+// first, with the copy, book and member needed to chase it up
+func (h *Handler) GetOverdueLoans(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	now := clock()
+	logger := logging.FromContext(r.Context())
+
+	var loans []models.Loan
+	if err := h.dbFor(r).Find(&loans, "returned_at IS NULL AND due_at < ?", now).Error; err != nil {
+		logger.Error("error querying overdue loans", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve overdue loans")
+		return
+	}
+	copyIDs := make([]int, len(loans))
+	memberIDs := make([]int, len(loans))
+	for i, loan := range loans {
+		copyIDs[i] = loan.CopyID
+		memberIDs[i] = loan.MemberID
+	}
+
+	var copies []models.Copy
+	var members []models.Member
+	var books []models.Book
+	if len(loans) > 0 {
+		err := h.dbFor(r).Find(&copies, "id IN ?", copyIDs).Error
+		if err == nil {
+			err = h.dbFor(r).Find(&members, "id IN ?", memberIDs).Error
+		}
+		if err == nil {
+			err = h.dbFor(r).Find(&books, "id IN (SELECT book_id FROM copies WHERE id IN ?)", copyIDs).Error
+		}
+		if err != nil {
+			logger.Error("error querying overdue loan details", "error", err)
+			renderError(w, r, http.StatusInternalServerError, "Failed to retrieve overdue loans")
+			return
+		}
+	}
+
+	render(w, r, http.StatusOK, overdueReport(loans, copies, members, books, now))
+}
+
+// overdueReport joins overdue loans with their copies, members and books
+func overdueReport(loans []models.Loan, copies []models.Copy, members []models.Member, books []models.Book, now time.Time) []overdueLoan {
+	copyByID := make(map[int]models.Copy, len(copies))
+	for _, c := range copies {
+		copyByID[c.ID] = c
+	}
+	memberByID := make(map[int]models.Member, len(members))
+	for _, m := range members {
+		memberByID[m.ID] = m
+	}
+	bookByID := make(map[int]models.Book, len(books))
+	for _, b := range books {
+		bookByID[b.ID] = b
+	}
+
+	report := make([]overdueLoan, 0, len(loans))
+	for _, loan := range loans {
+		bookCopy := copyByID[loan.CopyID]
+		member := memberByID[loan.MemberID]
+		report = append(report, overdueLoan{
+			LoanID:      loan.ID,
+			CopyID:      loan.CopyID,
+			Barcode:     bookCopy.Barcode,
+			BookID:      bookCopy.BookID,
+			BookName:    bookByID[bookCopy.BookID].Name,
+			MemberID:    loan.MemberID,
+			MemberName:  member.Name,
+			MemberEmail: member.Email,
+			DueAt:       loan.DueAt,
+			DaysOverdue: loan.DaysOverdue(now),
+		})
+	}
+	sort.SliceStable(report, func(i, j int) bool { return report[i].DueAt.Before(report[j].DueAt) })
+	return report
+}
+
+// sortLoans orders loans newest first
+func sortLoans(loans []models.Loan) {
+	sort.SliceStable(loans, func(i, j int) bool { return loans[i].CheckedOutAt.After(loans[j].CheckedOutAt) })
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// fixClock stops the handler clock at now for the rest of the test
+func fixClock(t *testing.T, now time.Time) {
+	previous := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = previous })
+}
+
+func TestCheckout_Validation(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	w := httptest.NewRecorder()
+	handler.Checkout(w, httptest.NewRequest(http.MethodPost, "/loans", strings.NewReader(`{"copy_id":1}`)))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+}
+
+func TestCheckout_Rejected(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errCopyOnLoan, http.StatusConflict},
+		{errLoanLimit, http.StatusUnprocessableEntity},
+		{errMemberNotFound, http.StatusUnprocessableEntity},
+		{errFinesOwed, http.StatusUnprocessableEntity},
+		{errCopyReserved, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			mockDB := new(mocks.MockDB)
+			handler := &Handler{DB: mockDB}
+			mockDB.On("Transaction", mock.Anything).Return(tt.err)
+
+			w := httptest.NewRecorder()
+			handler.Checkout(w, httptest.NewRequest(http.MethodPost, "/loans", strings.NewReader(`{"copy_id":1,"member_id":2}`)))
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.JSONEq(t, `{"error":"`+tt.err.Error()+`"}`, w.Body.String())
+		})
+	}
+}
+
+func TestRenew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	loan := models.Loan{CheckedOutAt: now, DueAt: now.Add(models.LoanPeriod)}
+
+	require.NoError(t, renew(&loan, now))
+	assert.Equal(t, now.Add(2*models.LoanPeriod), loan.DueAt)
+	require.NoError(t, renew(&loan, now))
+	assert.ErrorIs(t, renew(&loan, now), errRenewalLimit)
+
+	overdue := models.Loan{DueAt: now.Add(-time.Hour)}
+	assert.ErrorIs(t, renew(&overdue, now), errOverdueRenewal)
+}
+
+func TestGetOverdueLoans(t *testing.T) {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	fixClock(t, now)
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Loan"), []interface{}{"returned_at IS NULL AND due_at < ?", now}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Loan) = []models.Loan{
+			{ID: 1, CopyID: 10, MemberID: 20, DueAt: now.Add(-time.Hour)},
+			{ID: 2, CopyID: 11, MemberID: 20, DueAt: now.Add(-50 * time.Hour)},
+		}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Copy"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Copy) = []models.Copy{{ID: 10, BookID: 5, Barcode: "A-10"}, {ID: 11, BookID: 5, Barcode: "A-11"}}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Member"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Member) = []models.Member{{ID: 20, Name: "Ada", Email: "ada@example.com"}}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = []models.Book{{ID: 5, Name: "Dune"}}
+	}).Return(&gorm.DB{})
+
+	req := httptest.NewRequest(http.MethodGet, "/loans/overdue", nil)
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	handler.GetOverdueLoans(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "loan_id,copy_id,barcode,book_id,book_name,member_id,member_name,member_email,due_at,days_overdue\n"+
+		"2,11,A-11,5,Dune,20,Ada,ada@example.com,2024-03-07T22:00:00Z,3\n"+
+		"1,10,A-10,5,Dune,20,Ada,ada@example.com,2024-03-09T23:00:00Z,1\n", w.Body.String())
+}
+
+func TestCreateMember(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.MatchedBy(func(m *models.Member) bool {
+		m.ID = 7
+		return m.Email == "ada@example.com" && m.LoanLimit == models.DefaultLoanLimit
+	})).Return(nil)
+
+	w := httptest.NewRecorder()
+	handler.CreateMember(w, httptest.NewRequest(http.MethodPost, "/members", strings.NewReader(`{"name":"Ada","email":" Ada@Example.com "}`)))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/members/7", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	handler.CreateMember(w, httptest.NewRequest(http.MethodPost, "/members", strings.NewReader(`{"name":"Ada","email":"nope"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// memberBody is the request payload for creating and updating members
+type memberBody struct {
+	Name      string `json:"name" xml:"name"`
+	Email     string `json:"email" xml:"email"`
+	LoanLimit *int   `json:"loan_limit" xml:"loan_limit"`
+}
+
+// apply validates the body and copies it onto m
+func (b memberBody) apply(m *models.Member) error {
+	m.Name = strings.TrimSpace(b.Name)
+	m.Email = models.NormalizeEmail(b.Email)
+	if m.Name == "" {
+		return errors.New("Member name is required")
+	}
+	if !strings.Contains(m.Email, "@") {
+		return errors.New("A valid email address is required")
+	}
+	switch {
+	case b.LoanLimit != nil && *b.LoanLimit < 0:
+		return errors.New("loan_limit must not be negative")
+	case b.LoanLimit != nil:
+		m.LoanLimit = *b.LoanLimit
+	case m.ID == 0:
+		m.LoanLimit = models.DefaultLoanLimit
+	}
+	return nil
+}
+
+func (h *Handler) CreateMember(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+
+	var body memberBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var member models.Member
+	if err := body.apply(&member); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.dbFor(r).Create(&member).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			renderError(w, r, http.StatusConflict, "A member with this email already exists")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error creating member", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to create member")
+		return
+	}
+
+	w.Header().Set("Location", "/members/"+strconv.Itoa(member.ID))
+	render(w, r, http.StatusCreated, member)
+}
+
+// ListMembers lists members, optionally only those whose name or email
+// contains ?q=
+func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+
+	var conds []interface{}
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		conds = []interface{}{"name ILIKE ? OR email ILIKE ?", pattern, pattern}
+	}
+
+	members := []models.Member{}
+	if err := h.dbFor(r).Find(&members, conds...).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying members table", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve members")
+		return
+	}
+
+	render(w, r, http.StatusOK, members)
+}
+
+// findMember loads the member named by the {id} parameter and answers the
+// request itself when that fails
+func (h *Handler) findMember(w http.ResponseWriter, r *http.Request) (models.Member, bool) {
+	var member models.Member
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid member ID")
+		return member, false
+	}
+	if err := h.dbFor(r).First(&member, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Member not found")
+			return member, false
+		}
+		logging.FromContext(r.Context()).Error("error querying member", "member_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return member, false
+	}
+	return member, true
+}
+
+func (h *Handler) GetMember(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	member, ok := h.findMember(w, r)
+	if !ok {
+		return
+	}
+	render(w, r, http.StatusOK, member)
+}
+
+// UpdateMember changes a member's details. Lowering the loan limit below
+// the member's open loans only blocks further checkouts.
+func (h *Handler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	member, ok := h.findMember(w, r)
+	if !ok {
+		return
+	}
+
+	var body memberBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if err := body.apply(&member); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.dbFor(r).Save(&member).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			renderError(w, r, http.StatusConflict, "A member with this email already exists")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error updating member", "member_id", member.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update member")
+		return
+	}
+
+	render(w, r, http.StatusOK, member)
+}
+
+// GetMemberLoans lists a member's loans, newest first. ?open=true leaves
+// out returned loans.
+func (h *Handler) GetMemberLoans(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	openOnly, err := parseBoolParam(r.URL.Query().Get("open"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "open must be true or false")
+		return
+	}
+	member, ok := h.findMember(w, r)
+	if !ok {
+		return
+	}
+
+	conds := []interface{}{"member_id = ?", member.ID}
+	if openOnly {
+		conds = []interface{}{"member_id = ? AND returned_at IS NULL", member.ID}
+	}
+	loans := []models.Loan{}
+	if err := h.dbFor(r).Find(&loans, conds...).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying member loans", "member_id", member.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve loans")
+		return
+	}
+	sortLoans(loans)
+
+	render(w, r, http.StatusOK, loans)
+}
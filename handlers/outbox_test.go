@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// scriptedConn is a database connection that records the statements sent
+// to it with their arguments and answers queries with the rows answer
+// returns for them
+type scriptedConn struct {
+	answer     func(query string) (columns []string, rows [][]driver.Value)
+	statements []string
+	args       [][]driver.NamedValue
+}
+
+func (c *scriptedConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *scriptedConn) Driver() driver.Driver                        { return nil }
+func (c *scriptedConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *scriptedConn) Close() error                                 { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c *scriptedConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.statements = append(c.statements, query)
+	c.args = append(c.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *scriptedConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.statements = append(c.statements, query)
+	c.args = append(c.args, args)
+	columns, rows := c.answer(query)
+	return &scriptedRows{columns: columns, rows: rows}, nil
+}
+
+type scriptedRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func openScripted(t *testing.T, conn *scriptedConn) *gorm.DB {
+	t.Helper()
+	database, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	require.NoError(t, err)
+	return database
+}
+
+// outboxEvents returns the types of the events conn saw written to the
+// outbox
+func (c *scriptedConn) outboxEvents() []string {
+	var types []string
+	for i, statement := range c.statements {
+		if !strings.HasPrefix(statement, `INSERT INTO "outbox_events"`) {
+			continue
+		}
+		for _, arg := range c.args[i] {
+			if value, ok := arg.Value.(string); ok && strings.HasPrefix(value, "books.") {
+				types = append(types, value)
+			}
+		}
+	}
+	return types
+}
@@ -0,0 +1,533 @@
+package handlers
+
+import (
+	"bytes"
+	"connection_to_pg/logging"
+	"connection_to_pg/requestid"
+	"connection_to_pg/tracing"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v3"
+)
+
+// Media types the handlers can produce and consume
+const (
+	mediaJSON    = "application/json"
+	mediaXML     = "application/xml"
+	mediaYAML    = "application/yaml"
+	mediaCSV     = "text/csv"
+	mediaMsgPack = "application/msgpack"
+)
+
+// mediaAliases maps alternative spellings onto the canonical media type
+var mediaAliases = map[string]string{
+	"text/xml":                mediaXML,
+	"application/x-yaml":      mediaYAML,
+	"text/yaml":               mediaYAML,
+	"text/x-yaml":             mediaYAML,
+	"application/x-msgpack":   mediaMsgPack,
+	"application/vnd.msgpack": mediaMsgPack,
+}
+
+// producible lists the response types in order of server preference
+var producible = []string{mediaJSON, mediaXML, mediaYAML, mediaCSV, mediaMsgPack}
+
+// contentTypes is the Content-Type header sent for each media type
+var contentTypes = map[string]string{
+	mediaJSON:    "application/json",
+	mediaXML:     "application/xml; charset=utf-8",
+	mediaYAML:    "application/yaml; charset=utf-8",
+	mediaCSV:     "text/csv; charset=utf-8",
+	mediaMsgPack: "application/msgpack",
+}
+
+var (
+	errNotAcceptable        = errors.New("not acceptable")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// messageResponse is the body of responses that only carry a status text
+type messageResponse struct {
+	XMLName xml.Name `json:"-" xml:"response"`
+	Message string   `json:"message,omitempty" xml:"message,omitempty"`
+	Error   string   `json:"error,omitempty" xml:"error,omitempty"`
+	// RequestID lets clients quote the failing request in bug reports
+	RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
+}
+
+// canonicalMediaType strips parameters from a media type and resolves aliases
+func canonicalMediaType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(value, ";")[0]))
+	}
+	if alias, ok := mediaAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// acceptRange is one entry of an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if alias, ok := mediaAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// quality returns the q value the client gives mediaType, using the most
+// specific matching range. It is -1 when no range matches.
+func quality(ranges []acceptRange, mediaType string) float64 {
+	best, specificity := -1.0, -1
+	typ, _, _ := strings.Cut(mediaType, "/")
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == mediaType:
+			s = 2
+		case ar.mediaType == typ+"/*":
+			s = 1
+		case ar.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			best, specificity = ar.q, s
+		}
+	}
+	return best
+}
+
+// negotiate picks the response media type from the Accept header. CSV is
+// only offered for collections.
+func negotiate(r *http.Request, collection bool) (string, error) {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return mediaJSON, nil
+	}
+	ranges := parseAccept(header)
+
+	chosen, chosenQ := "", 0.0
+	for _, mediaType := range producible {
+		if mediaType == mediaCSV && !collection {
+			continue
+		}
+		if q := quality(ranges, mediaType); q > chosenQ {
+			chosen, chosenQ = mediaType, q
+		}
+	}
+	if chosen == "" {
+		return "", errNotAcceptable
+	}
+	return chosen, nil
+}
+
+// isCollection reports whether v is rendered as a list of records
+func isCollection(v interface{}) bool {
+	return reflect.Indirect(reflect.ValueOf(v)).Kind() == reflect.Slice
+}
+
+// render writes v with status in the representation negotiated from the
+// Accept header
+func render(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	mediaType, err := negotiate(r, isCollection(v))
+	if err != nil {
+		renderNotAcceptable(w, r, isCollection(v))
+		return
+	}
+
+	_, span := tracing.Span(r, "encode", attribute.String("media_type", mediaType))
+	body, err := encodeBody(mediaType, v)
+	span.End()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error encoding response", "media_type", mediaType, "error", err)
+		httpError(w, r, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, status, mediaType, body)
+}
+
+// renderMessage writes a {"message": ...} body
+func renderMessage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	renderStatusText(w, r, status, "message", message)
+}
+
+// renderError writes an {"error": ...} body
+func renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	renderStatusText(w, r, status, "error", message)
+}
+
+func renderStatusText(w http.ResponseWriter, r *http.Request, status int, key, text string) {
+	mediaType, err := negotiate(r, false)
+	if err != nil || mediaType == mediaJSON {
+		// JSON keeps the exact body format clients have always received.
+		// An unacceptable Accept header doesn't hide the actual error.
+		quoted, _ := json.Marshal(text)
+		setContentType(w, mediaJSON)
+		w.WriteHeader(status)
+		if id := requestid.FromContext(r.Context()); key == "error" && id != "" {
+			fmt.Fprintf(w, "{\"%s\": %s, \"request_id\": \"%s\"}\n", key, quoted, id)
+			return
+		}
+		fmt.Fprintf(w, "{\"%s\": %s}\n", key, quoted)
+		return
+	}
+
+	body := messageResponse{Message: text}
+	if key == "error" {
+		body = messageResponse{Error: text, RequestID: requestid.FromContext(r.Context())}
+	}
+	encoded, err := encodeBody(mediaType, body)
+	if err != nil {
+		httpError(w, r, text, status)
+		return
+	}
+	writeBody(w, status, mediaType, encoded)
+}
+
+func renderNotAcceptable(w http.ResponseWriter, r *http.Request, collection bool) {
+	var offered []string
+	for _, mediaType := range producible {
+		if mediaType != mediaCSV || collection {
+			offered = append(offered, mediaType)
+		}
+	}
+	// The client can't read any of our formats, so answer in JSON
+	body, _ := json.Marshal(messageResponse{
+		Error:     "Not acceptable, supported types: " + strings.Join(offered, ", "),
+		RequestID: requestid.FromContext(r.Context()),
+	})
+	setContentType(w, mediaJSON)
+	w.WriteHeader(http.StatusNotAcceptable)
+	w.Write(append(body, '\n'))
+}
+
+// httpError writes a plain text error like http.Error, followed by the
+// request ID when there is one
+func httpError(w http.ResponseWriter, r *http.Request, text string, status int) {
+	if id := requestid.FromContext(r.Context()); id != "" {
+		text = fmt.Sprintf("%s (request_id: %s)", text, id)
+	}
+	http.Error(w, text, status)
+}
+
+// NotFound answers requests no route matched
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, http.StatusNotFound, "Not found")
+}
+
+// MethodNotAllowed answers requests whose route exists for other methods
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// acceptable reports whether the request can be answered at all, so
+// handlers can refuse before doing any work
+func acceptable(w http.ResponseWriter, r *http.Request, collection bool) bool {
+	if _, err := negotiate(r, collection); err != nil {
+		renderNotAcceptable(w, r, collection)
+		return false
+	}
+	return true
+}
+
+func setContentType(w http.ResponseWriter, mediaType string) {
+	w.Header().Set("Content-Type", contentTypes[mediaType])
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Vary", "Accept")
+}
+
+func writeBody(w http.ResponseWriter, status int, mediaType string, body []byte) {
+	setContentType(w, mediaType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// encodeBody serialises v as mediaType
+func encodeBody(mediaType string, v interface{}) ([]byte, error) {
+	switch mediaType {
+	case mediaJSON:
+		return jsonMarshal(v)
+	case mediaXML:
+		return xmlMarshal(v)
+	case mediaYAML:
+		return yamlMarshal(v)
+	case mediaCSV:
+		return csvMarshal(v)
+	case mediaMsgPack:
+		return msgpackMarshal(v)
+	}
+	return nil, fmt.Errorf("%w: %s", errNotAcceptable, mediaType)
+}
+
+// decodeBody reads the request body into v according to its Content-Type.
+// A missing Content-Type is treated as JSON.
+func decodeBody(r *http.Request, v interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	mediaType := mediaJSON
+	if contentType != "" {
+		mediaType = canonicalMediaType(contentType)
+	}
+	_, span := tracing.Span(r, "decode", attribute.String("media_type", mediaType))
+	defer span.End()
+
+	switch mediaType {
+	case mediaJSON:
+		return json.NewDecoder(r.Body).Decode(v)
+	case mediaXML:
+		return xml.NewDecoder(r.Body).Decode(v)
+	case mediaYAML:
+		return yamlDecode(r.Body, v)
+	case mediaMsgPack:
+		dec := msgpack.NewDecoder(r.Body)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	}
+	return fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType)
+}
+
+// supportedRequestType reports whether decodeBody understands the request's
+// Content-Type, so handlers can answer 415 before touching the database
+func supportedRequestType(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	switch canonicalMediaType(contentType) {
+	case mediaJSON, mediaXML, mediaYAML, mediaMsgPack:
+		return true
+	}
+	return false
+}
+
+// renderUnsupportedMediaType answers a request whose body can't be decoded
+func renderUnsupportedMediaType(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, http.StatusUnsupportedMediaType, "Unsupported Content-Type, use one of "+strings.Join([]string{mediaJSON, mediaXML, mediaYAML, mediaMsgPack}, ", "))
+}
+
+func xmlMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	var err error
+	switch {
+	case rv.Kind() == reflect.Slice:
+		// A list of books becomes <books><book>...</book></books>
+		item := xmlElementName(rv.Type().Elem())
+		start := xml.StartElement{Name: xml.Name{Local: item + "s"}}
+		if err = enc.EncodeToken(start); err != nil {
+			return nil, err
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err = enc.EncodeElement(rv.Index(i).Interface(), xml.StartElement{Name: xml.Name{Local: item}}); err != nil {
+				return nil, err
+			}
+		}
+		err = enc.EncodeToken(start.End())
+	case rv.Kind() == reflect.Struct && hasXMLName(rv.Type()):
+		err = enc.Encode(v)
+	default:
+		err = enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: xmlElementName(rv.Type())}})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func hasXMLName(t reflect.Type) bool {
+	_, ok := t.FieldByName("XMLName")
+	return ok
+}
+
+// xmlElementName derives an element name such as "book" from a Go type
+func xmlElementName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if name == "" {
+		return "item"
+	}
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// yamlMarshal goes through JSON so field names and omitempty follow the
+// json tags, then re-styles the document as block YAML
+func yamlMarshal(v interface{}) ([]byte, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(j, &node); err != nil {
+		return nil, err
+	}
+	resetYAMLStyle(&node)
+	return yaml.Marshal(&node)
+}
+
+func resetYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// yamlDecode mirrors yamlMarshal by converting the document to JSON first
+func yamlDecode(r io.Reader, v interface{}) error {
+	var doc interface{}
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// csvMarshal writes a slice of structs as CSV. Columns are the json names
+// of the scalar fields; nested values are left out.
+func csvMarshal(v interface{}) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%w: csv needs a collection", errNotAcceptable)
+	}
+	elem := rv.Type().Elem()
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("csv can't encode %s", elem)
+	}
+
+	columns := csvColumns(elem)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(header)
+	for i := 0; i < rv.Len(); i++ {
+		item := reflect.Indirect(rv.Index(i))
+		record := make([]string, len(columns))
+		if item.IsValid() {
+			for j, c := range columns {
+				record[j] = csvValue(item.FieldByIndex(c.index))
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func csvColumns(t reflect.Type) []csvColumn {
+	var columns []csvColumn
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Map, reflect.Array, reflect.Interface:
+			if ft != timeType {
+				continue
+			}
+		}
+		columns = append(columns, csvColumn{name: name, index: field.Index})
+	}
+	return columns
+}
+
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
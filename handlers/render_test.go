@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"connection_to_pg/requestid"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"gorm.io/gorm"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept     string
+		collection bool
+		want       string
+		wantErr    bool
+	}{
+		{accept: "", want: mediaJSON},
+		{accept: "*/*", want: mediaJSON},
+		{accept: "application/xml", want: mediaXML},
+		{accept: "text/xml", want: mediaXML},
+		{accept: "application/json;q=0.5, application/yaml", want: mediaYAML},
+		{accept: "application/*;q=0.2, application/msgpack", want: mediaMsgPack},
+		{accept: "text/csv", collection: true, want: mediaCSV},
+		{accept: "text/csv", wantErr: true},
+		{accept: "text/html", wantErr: true},
+		{accept: "application/json;q=0", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/books", nil)
+		r.Header.Set("Accept", tc.accept)
+
+		got, err := negotiate(r, tc.collection)
+
+		if tc.wantErr {
+			assert.ErrorIs(t, err, errNotAcceptable, tc.accept)
+			continue
+		}
+		assert.NoError(t, err, tc.accept)
+		assert.Equal(t, tc.want, got, tc.accept)
+	}
+}
+
+func getAllWithAccept(t *testing.T, accept string) *httptest.ResponseRecorder {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = []models.Book{
+			{ID: 1, Name: "Dune", Author: "Frank Herbert"},
+			{ID: 2, Name: "Emma", Description: "A novel", Author: "Jane Austen"},
+		}
+	}).Return(&gorm.DB{}).Maybe()
+
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	handler.GetAll(w, req)
+	return w
+}
+
+func TestGetAll_XML(t *testing.T) {
+	w := getAllWithAccept(t, "application/xml")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<books>\n  <book>\n    <id>1</id>\n    <name>Dune</name>")
+}
+
+func TestGetAll_YAML(t *testing.T) {
+	w := getAllWithAccept(t, "application/yaml")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "- id: 1\n  name: Dune\n  description: \"\"\n  author: Frank Herbert\n- id: 2\n  name: Emma\n  description: A novel\n  author: Jane Austen\n", w.Body.String())
+}
+
+func TestGetAll_CSV(t *testing.T) {
+	w := getAllWithAccept(t, "text/csv")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "id,name,description,author,isbn13,isbn10,rating_average,rating_count\n1,Dune,,Frank Herbert,,,0,0\n2,Emma,A novel,Jane Austen,,,0,0\n", w.Body.String())
+}
+
+func TestGetAll_MessagePack(t *testing.T) {
+	w := getAllWithAccept(t, "application/msgpack")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var books []map[string]interface{}
+	require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &books))
+	assert.Equal(t, "Dune", books[0]["name"])
+}
+
+func TestGetAll_NotAcceptable(t *testing.T) {
+	w := getAllWithAccept(t, "text/html")
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Contains(t, w.Body.String(), "text/csv")
+}
+
+func TestGet_CSVNotAcceptableForSingleBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := Handler{DB: mockDB}
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("query", "1")
+	req := httptest.NewRequest(http.MethodGet, "/books/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+
+	handler.Get(w, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	mockDB.AssertNotCalled(t, "First", mock.Anything, mock.Anything)
+}
+
+func TestCreate_XMLBody(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	mockDB.On("Create", mock.MatchedBy(func(b *models.Book) bool {
+		return b.Name == "Dune" && b.Author == "Frank Herbert"
+	})).Return(nil)
+
+	body := `<book><name>Dune</name><author>Frank Herbert</author></book>`
+	req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+
+	handler.Create(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), "<response>\n  <message>Book created successfully</message>\n</response>")
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreate_YAMLAndMessagePackBodies(t *testing.T) {
+	packed, err := msgpack.Marshal(map[string]string{"name": "Emma", "author": "Jane Austen"})
+	require.NoError(t, err)
+
+	bodies := map[string][]byte{
+		"application/yaml":    []byte("name: Emma\nauthor: Jane Austen\n"),
+		"application/msgpack": packed,
+	}
+	for contentType, body := range bodies {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+		mockDB.On("Create", mock.MatchedBy(func(b *models.Book) bool {
+			return b.Name == "Emma" && b.Author == "Jane Austen"
+		})).Return(nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+
+		handler.Create(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code, contentType)
+		mockDB.AssertExpectations(t)
+	}
+}
+
+func TestCreate_UnsupportedMediaType(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader("name=Dune"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	handler.Create(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	mockDB.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestErrors_IncludeRequestID(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB)}
+	newRequest := func(accept string) *http.Request {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("query", "abc")
+		rctx.URLParams.Add("id", "abc")
+		req := httptest.NewRequest(http.MethodGet, "/books/abc", nil)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+		req = req.WithContext(requestid.NewContext(ctx, "req-1"))
+		req.Header.Set("Accept", accept)
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	handler.Get(w, newRequest(""))
+	assert.Equal(t, "{\"error\": \"Invalid ID format\", \"request_id\": \"req-1\"}\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.Get(w, newRequest("application/xml"))
+	assert.Contains(t, w.Body.String(), "<request_id>req-1</request_id>")
+
+	w = httptest.NewRecorder()
+	handler.Update(w, newRequest(""))
+	assert.Equal(t, "Invalid book ID (request_id: req-1)\n", w.Body.String())
+}
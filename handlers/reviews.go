@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"connection_to_pg/auth"
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// reviewBody is the request payload for writing a review
+type reviewBody struct {
+	Rating int    `json:"rating" xml:"rating"`
+	Text   string `json:"text" xml:"text"`
+}
+
+// validate checks the body and trims the text
+func (b *reviewBody) validate() error {
+	if !models.ValidRating(b.Rating) {
+		return fmt.Errorf("rating must be a whole number from %d to %d", models.MinRating, models.MaxRating)
+	}
+	b.Text = strings.TrimSpace(b.Text)
+	return nil
+}
+
+var (
+	errReviewNotFound  = errors.New("review not found")
+	errDuplicateReview = errors.New("user has already reviewed this book")
+	errNotReviewAuthor = errors.New("only the author of a review can change it")
+)
+
+// reviewSorts maps the values of the sort parameter of the reviews list to
+// the order they select
+var reviewSorts = map[string]func(a, b models.Review) bool{
+	"created_at":  func(a, b models.Review) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"-created_at": func(a, b models.Review) bool { return a.CreatedAt.After(b.CreatedAt) },
+	"rating":      func(a, b models.Review) bool { return a.Rating < b.Rating },
+	"-rating":     func(a, b models.Review) bool { return a.Rating > b.Rating },
+}
+
+// adjustRating updates the rating aggregates of a book by the change one
+// review makes. The update is a single statement relative to the stored
+// values, so concurrent reviews of the same book don't lose each other's
+// changes. Being raw SQL, it records the change to the book in the outbox
+// and announces it itself.
+func adjustRating(tx *gorm.DB, bookID, count, sum int) error {
+	var book models.Book
+	result := tx.Raw(`UPDATE books SET rating_count = rating_count + ?, rating_sum = rating_sum + ?,
+		rating_average = CASE WHEN rating_count + ? = 0 THEN 0 ELSE ROUND((rating_sum + ?)::numeric / (rating_count + ?), 2) END
+		WHERE id = ? RETURNING *`, count, sum, count, sum, count, bookID).Scan(&book)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errBookNotFound
+	}
+	if err := models.RecordBookEvent(tx, models.EventBookUpdated, &book); err != nil {
+		return err
+	}
+	return db.NotifyChange(tx, "books", "update", bookID)
+}
+
+// CreateReview adds the caller's review of a book
+func (h *Handler) CreateReview(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	var body reviewBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if err := body.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fresh := models.Review{
+		BookID: book.ID,
+		UserID: &principal.UserID,
+		Author: principal.Username,
+		Rating: body.Rating,
+		Text:   body.Text,
+	}
+	review := fresh
+	err := h.transaction(r, func(tx *gorm.DB) error {
+		// A run again starts over from the review as requested
+		review = fresh
+		if err := tx.Create(&review).Error; err != nil {
+			if db.IsUniqueViolation(err) {
+				return errDuplicateReview
+			}
+			return err
+		}
+		return adjustRating(tx, book.ID, 1, review.Rating)
+	})
+	if errors.Is(err, errDuplicateReview) {
+		var existing models.Review
+		if h.dbFor(r).First(&existing, "book_id = ? AND user_id = ?", book.ID, principal.UserID).Error == nil {
+			w.Header().Set("Location", reviewLocation(existing))
+		}
+		renderError(w, r, http.StatusConflict, "You have already reviewed this book, update your review instead")
+		return
+	}
+	if !h.reviewWritten(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", reviewLocation(review))
+	render(w, r, http.StatusCreated, review)
+}
+
+func reviewLocation(review models.Review) string {
+	return "/books/" + strconv.Itoa(review.BookID) + "/reviews/" + strconv.Itoa(review.ID)
+}
+
+// reviewWritten answers the request when a review transaction failed
+func (h *Handler) reviewWritten(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errBookNotFound):
+		renderError(w, r, http.StatusNotFound, "Book not found")
+	case errors.Is(err, errReviewNotFound):
+		renderError(w, r, http.StatusNotFound, "Review not found")
+	case errors.Is(err, errNotReviewAuthor):
+		renderError(w, r, http.StatusForbidden, err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("error writing review", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to save review")
+	}
+	return false
+}
+
+// ListReviews lists the reviews of a book, newest first.
+//
+// Query parameters:
+//
+//	rating  only reviews with these star ratings, e.g. "4,5"
+//	sort    created_at, -created_at (default), rating or -rating
+func (h *Handler) ListReviews(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	query := r.URL.Query()
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "-created_at"
+	}
+	less, ok := reviewSorts[sortBy]
+	if !ok {
+		renderError(w, r, http.StatusBadRequest, "sort must be one of created_at, -created_at, rating or -rating")
+		return
+	}
+	var ratings []int
+	if value := query.Get("rating"); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			rating, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || !models.ValidRating(rating) {
+				renderError(w, r, http.StatusBadRequest, "rating must be a list of star ratings from 1 to 5")
+				return
+			}
+			ratings = append(ratings, rating)
+		}
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	conds := []interface{}{"book_id = ?", book.ID}
+	if len(ratings) > 0 {
+		conds = []interface{}{"book_id = ? AND rating IN ?", book.ID, ratings}
+	}
+	reviews := []models.Review{}
+	if err := h.dbFor(r).Find(&reviews, conds...).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying reviews", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve reviews")
+		return
+	}
+	sort.SliceStable(reviews, func(i, j int) bool { return less(reviews[i], reviews[j]) })
+
+	render(w, r, http.StatusOK, reviews)
+}
+
+// reviewID parses the {reviewID} parameter
+func reviewID(r *http.Request) (int, error) {
+	return strconv.Atoi(chi.URLParam(r, "reviewID"))
+}
+
+func (h *Handler) GetReview(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+	id, err := reviewID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	var review models.Review
+	if err := h.dbFor(r).First(&review, "id = ? AND book_id = ?", id, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Review not found")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error querying review", "review_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	render(w, r, http.StatusOK, review)
+}
+
+// lockReview loads a review of a book with a row lock and checks that the
+// caller may change it: its author or an admin
+func lockReview(tx *gorm.DB, principal auth.Principal, bookID, id int) (models.Review, error) {
+	var review models.Review
+	if err := tx.Clauses(lockForUpdate).First(&review, "id = ? AND book_id = ?", id, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return review, errReviewNotFound
+		}
+		return review, err
+	}
+	owner := review.UserID != nil && *review.UserID == principal.UserID
+	if !owner && principal.Role != models.RoleAdmin {
+		return review, errNotReviewAuthor
+	}
+	return review, nil
+}
+
+// UpdateReview changes the rating and text of a review
+func (h *Handler) UpdateReview(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+	id, err := reviewID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+	var body reviewBody
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if err := body.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var review models.Review
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		var err error
+		if review, err = lockReview(tx, principal, bookID, id); err != nil {
+			return err
+		}
+		delta := body.Rating - review.Rating
+		review.Rating = body.Rating
+		review.Text = body.Text
+		if err := tx.Save(&review).Error; err != nil {
+			return err
+		}
+		if delta == 0 {
+			return nil
+		}
+		return adjustRating(tx, bookID, 0, delta)
+	})
+	if !h.reviewWritten(w, r, err) {
+		return
+	}
+
+	render(w, r, http.StatusOK, review)
+}
+
+// DeleteReview removes a review and its rating from the book
+func (h *Handler) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+	id, err := reviewID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	err = h.transaction(r, func(tx *gorm.DB) error {
+		review, err := lockReview(tx, principal, bookID, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&review).Error; err != nil {
+			return err
+		}
+		return adjustRating(tx, bookID, -1, -review.Rating)
+	})
+	if !h.reviewWritten(w, r, err) {
+		return
+	}
+
+	renderMessage(w, r, http.StatusOK, "Review deleted successfully")
+}
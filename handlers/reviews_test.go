@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"connection_to_pg/auth"
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// asUser authenticates a request as the given user
+func asUser(req *http.Request, userID int, role string) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Principal{UserID: userID, Username: "reader", Role: role}))
+}
+
+func expectBook(mockDB *mocks.MockDB, id int) {
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), id).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Book).ID = id
+	}).Return(&gorm.DB{})
+}
+
+func TestCreateReview_RequiresAuthentication(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := withID(httptest.NewRequest(http.MethodPost, "/books/1/reviews", strings.NewReader(`{"rating":5}`)), "1")
+	w := httptest.NewRecorder()
+	handler.CreateReview(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+}
+
+func TestCreateReview(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	expectBook(mockDB, 1)
+	mockDB.On("Transaction", mock.Anything).Return(nil)
+
+	req := withID(httptest.NewRequest(http.MethodPost, "/books/1/reviews", strings.NewReader(`{"rating":4,"text":" Loved it "}`)), "1")
+	w := httptest.NewRecorder()
+	handler.CreateReview(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"author":"reader"`)
+	assert.Contains(t, w.Body.String(), `"text":"Loved it"`)
+	assert.Contains(t, w.Body.String(), `"user_id":7`)
+}
+
+func TestCreateReview_Invalid(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	expectBook(mockDB, 1)
+
+	req := withID(httptest.NewRequest(http.MethodPost, "/books/1/reviews", strings.NewReader(`{"rating":6}`)), "1")
+	w := httptest.NewRecorder()
+	handler.CreateReview(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"rating must be a whole number from 1 to 5"}`, w.Body.String())
+	mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+}
+
+func TestCreateReview_Duplicate(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	expectBook(mockDB, 1)
+	mockDB.On("Transaction", mock.Anything).Return(errDuplicateReview)
+	mockDB.On("First", mock.AnythingOfType("*models.Review"), "book_id = ? AND user_id = ?").Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Review) = models.Review{ID: 12, BookID: 1}
+	}).Return(&gorm.DB{})
+
+	req := withID(httptest.NewRequest(http.MethodPost, "/books/1/reviews", strings.NewReader(`{"rating":3}`)), "1")
+	w := httptest.NewRecorder()
+	handler.CreateReview(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "/books/1/reviews/12", w.Header().Get("Location"))
+}
+
+func TestUpdateReview_NotAuthor(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Transaction", mock.Anything).Return(errNotReviewAuthor)
+
+	req := withURLParam(withID(httptest.NewRequest(http.MethodPut, "/books/1/reviews/12", strings.NewReader(`{"rating":1}`)), "1"), "reviewID", "12")
+	w := httptest.NewRecorder()
+	handler.UpdateReview(w, asUser(req, 8, models.RoleUser))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestListReviews(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	expectBook(mockDB, 1)
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Review"), []interface{}{"book_id = ? AND rating IN ?", 1, []int{4, 5}}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Review) = []models.Review{
+			{ID: 1, Rating: 4, CreatedAt: now},
+			{ID: 2, Rating: 5, CreatedAt: now.Add(-time.Hour)},
+			{ID: 3, Rating: 4, CreatedAt: now.Add(time.Hour)},
+		}
+	}).Return(&gorm.DB{})
+
+	req := withID(httptest.NewRequest(http.MethodGet, "/books/1/reviews?rating=4,5&sort=-rating", nil), "1")
+	w := httptest.NewRecorder()
+	handler.ListReviews(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Less(t, strings.Index(body, `"id":2`), strings.Index(body, `"id":1`))
+	assert.Less(t, strings.Index(body, `"id":1`), strings.Index(body, `"id":3`))
+
+	w = httptest.NewRecorder()
+	handler.ListReviews(w, withID(httptest.NewRequest(http.MethodGet, "/books/1/reviews?rating=0", nil), "1"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBookFilters_Rating(t *testing.T) {
+	filters := parseBookFilters(url.Values{"min_rating": {"3.5"}, "sort": {"-rating"}})
+	require.NoError(t, filters.validate())
+	assert.Equal(t, []interface{}{"rating_count > 0 AND rating_average >= ?", 3.5}, filters.conds())
+
+	mockDB := new(mocks.MockDB)
+	database, statement := dryRun(t)
+	mockDB.On("Model", &models.Book{}).Return(database)
+	var books []models.Book
+	require.NoError(t, filters.find(mockDB, &books))
+	assert.Contains(t, *statement, "WHERE rating_count > 0 AND rating_average >= $1 ORDER BY rating_average DESC, id")
+
+	assert.Error(t, parseBookFilters(url.Values{"min_rating": {"6"}}).validate())
+	assert.Error(t, parseBookFilters(url.Values{"sort": {"name"}}).validate())
+}
+
+func TestAuthenticate(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.APIKey"), "hash = ? AND revoked_at IS NULL").Run(func(args mock.Arguments) {
+		args.Get(0).(*models.APIKey).UserID = 4
+	}).Return(&gorm.DB{}).Once()
+	mockDB.On("First", mock.AnythingOfType("*models.User"), 4).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.User) = models.User{ID: 4, Username: "alice", Role: models.RoleAdmin}
+	}).Return(&gorm.DB{})
+
+	var seen auth.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = auth.FromContext(r.Context())
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("Authorization", "Bearer bk_valid")
+	handler.Authenticate(next).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, auth.Principal{UserID: 4, Username: "alice", Role: models.RoleAdmin}, seen)
+
+	mockDB.On("First", mock.AnythingOfType("*models.APIKey"), "hash = ? AND revoked_at IS NULL").Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+	req = httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("X-API-Key", "bk_revoked")
+	w := httptest.NewRecorder()
+	handler.Authenticate(next).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdjustRating_RecordsEvent(t *testing.T) {
+	conn := &scriptedConn{answer: func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "UPDATE books"):
+			return []string{"id", "name", "rating_count", "rating_sum"}, [][]driver.Value{{int64(7), "Dune", int64(2), int64(9)}}
+		case strings.HasPrefix(query, `INSERT INTO "outbox_events"`):
+			return []string{"id"}, [][]driver.Value{{int64(1)}}
+		}
+		return nil, nil
+	}}
+
+	require.NoError(t, adjustRating(openScripted(t, conn), 7, 1, 4))
+	assert.Contains(t, conn.statements[0], "RETURNING *")
+	assert.Equal(t, []string{models.EventBookUpdated}, conn.outboxEvents())
+
+	conn = &scriptedConn{answer: func(string) ([]string, [][]driver.Value) { return []string{"id"}, nil }}
+	assert.ErrorIs(t, adjustRating(openScripted(t, conn), 7, 1, 4), errBookNotFound)
+	assert.Empty(t, conn.outboxEvents())
+}
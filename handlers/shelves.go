@@ -0,0 +1,760 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// shelfBody is the request payload for creating and updating shelves
+type shelfBody struct {
+	Name        string `json:"name" xml:"name"`
+	Description string `json:"description" xml:"description"`
+}
+
+// validate checks the body and normalises the name
+func (b *shelfBody) validate() error {
+	b.Name = models.NormalizeShelfName(b.Name)
+	switch {
+	case b.Name == "":
+		return errors.New("name is required")
+	case utf8.RuneCountInString(b.Name) > models.MaxShelfNameLength:
+		return fmt.Errorf("name is longer than %d characters", models.MaxShelfNameLength)
+	}
+	return nil
+}
+
+// entryBody is the request payload for adding and updating shelf entries
+type entryBody struct {
+	// BookID is only read when adding an entry
+	BookID     int        `json:"book_id" xml:"book_id"`
+	Note       string     `json:"note" xml:"note"`
+	StartedAt  *time.Time `json:"started_at" xml:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" xml:"finished_at"`
+	// Position places the entry, 1 for the top of the shelf; new entries
+	// go to the bottom and updated ones stay put without it
+	Position *int `json:"position" xml:"position"`
+}
+
+// validate checks the dates and position of the body
+func (b *entryBody) validate() error {
+	if b.StartedAt != nil && b.FinishedAt != nil && b.FinishedAt.Before(*b.StartedAt) {
+		return errors.New("finished_at is before started_at")
+	}
+	if b.Position != nil && *b.Position < 1 {
+		return errors.New("position must be 1 or more")
+	}
+	return nil
+}
+
+// orderBody is the request payload of PUT /shelves/{id}/order
+type orderBody struct {
+	EntryIDs []int `json:"entry_ids" xml:"entry_ids>id"`
+}
+
+// sharedShelf is a shelf as seen through its public link
+type sharedShelf struct {
+	XMLName     xml.Name            `json:"-" xml:"shelf" yaml:"-" msgpack:"-"`
+	Name        string              `json:"name" xml:"name"`
+	Description string              `json:"description" xml:"description"`
+	UpdatedAt   time.Time           `json:"updated_at" xml:"updated_at"`
+	Entries     []models.ShelfEntry `json:"entries" xml:"entries>entry"`
+}
+
+var (
+	errShelfNotFound  = errors.New("shelf not found")
+	errEntryNotFound  = errors.New("shelf entry not found")
+	errDuplicateShelf = errors.New("a shelf with this name already exists")
+	errDuplicateEntry = errors.New("book is already on the shelf")
+	errUnknownBook    = errors.New("unknown book")
+	errBadOrder       = errors.New("entry_ids must list every entry of the shelf exactly once")
+)
+
+func shelfLocation(id int) string {
+	return "/shelves/" + strconv.Itoa(id)
+}
+
+// newShareToken returns a random, URL-safe secret for a public shelf link
+func newShareToken() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// withShareURL fills in the public link of a shared shelf
+func withShareURL(shelf models.Shelf) models.Shelf {
+	shelf.ShareURL = ""
+	if shelf.Shared() {
+		shelf.ShareURL = "/shared/shelves/" + *shelf.ShareToken
+	}
+	return shelf
+}
+
+// shelfWritten answers the request when a shelf transaction failed
+func (h *Handler) shelfWritten(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errShelfNotFound):
+		renderError(w, r, http.StatusNotFound, "Shelf not found")
+	case errors.Is(err, errEntryNotFound):
+		renderError(w, r, http.StatusNotFound, "Shelf entry not found")
+	case errors.Is(err, errDuplicateShelf), errors.Is(err, errDuplicateEntry):
+		renderError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, errUnknownBook):
+		renderError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, errBadOrder):
+		renderError(w, r, http.StatusBadRequest, err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("error writing shelf", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to save shelf")
+	}
+	return false
+}
+
+// lockShelf loads one of the user's shelves with a row lock, which
+// serialises changes to the order of its entries
+func lockShelf(tx *gorm.DB, userID, id int) (models.Shelf, error) {
+	var shelf models.Shelf
+	if err := tx.Clauses(lockForUpdate).First(&shelf, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return shelf, errShelfNotFound
+		}
+		return shelf, err
+	}
+	return shelf, nil
+}
+
+// shelfEntries loads the entries of a locked shelf in order
+func shelfEntries(tx *gorm.DB, shelfID int) ([]models.ShelfEntry, error) {
+	var entries []models.ShelfEntry
+	if err := tx.Find(&entries, "shelf_id = ?", shelfID).Error; err != nil {
+		return nil, err
+	}
+	models.SortShelfEntries(entries)
+	return entries, nil
+}
+
+// renumberEntries stores positions 1, 2, ... for entries in slice order,
+// writing only the entries whose position changed
+func renumberEntries(tx *gorm.DB, entries []models.ShelfEntry) error {
+	for i := range entries {
+		if entries[i].Position == i+1 {
+			continue
+		}
+		entries[i].Position = i + 1
+		if err := tx.Model(&models.ShelfEntry{}).Where("id = ?", entries[i].ID).Update("position", i+1).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveEntry moves the entry at index from so that it ends up at position,
+// clamped to the end of the shelf
+func moveEntry(entries []models.ShelfEntry, from, position int) []models.ShelfEntry {
+	entry := entries[from]
+	rest := append(append([]models.ShelfEntry(nil), entries[:from]...), entries[from+1:]...)
+	to := min(position-1, len(rest))
+	return append(rest[:to], append([]models.ShelfEntry{entry}, rest[to:]...)...)
+}
+
+// reorderEntries arranges entries in the order of ids, which must name
+// every entry exactly once
+func reorderEntries(entries []models.ShelfEntry, ids []int) ([]models.ShelfEntry, error) {
+	if len(ids) != len(entries) {
+		return nil, errBadOrder
+	}
+	byID := make(map[int]models.ShelfEntry, len(entries))
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+	}
+	ordered := make([]models.ShelfEntry, 0, len(ids))
+	for _, id := range ids {
+		entry, ok := byID[id]
+		if !ok {
+			return nil, errBadOrder
+		}
+		delete(byID, id)
+		ordered = append(ordered, entry)
+	}
+	return ordered, nil
+}
+
+// loadShelf fills in the entries of a shelf with their books. Positions
+// are numbered from 1 whatever gaps deleted books left behind.
+func (h *Handler) loadShelf(r *http.Request, shelf *models.Shelf) error {
+	entries := []models.ShelfEntry{}
+	if err := h.dbFor(r).Find(&entries, "shelf_id = ?", shelf.ID).Error; err != nil {
+		return err
+	}
+	models.SortShelfEntries(entries)
+	if len(entries) > 0 {
+		ids := make([]int, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.BookID
+		}
+		var books []models.Book
+		if err := h.dbFor(r).Find(&books, "id IN ?", ids).Error; err != nil {
+			return err
+		}
+		byID := make(map[int]*models.Book, len(books))
+		for i := range books {
+			byID[books[i].ID] = &books[i]
+		}
+		for i := range entries {
+			entries[i].Position = i + 1
+			entries[i].Book = byID[entries[i].BookID]
+		}
+	}
+	shelf.Entries = entries
+	return nil
+}
+
+// renderShelf answers with a shelf and its entries
+func (h *Handler) renderShelf(w http.ResponseWriter, r *http.Request, status int, shelf models.Shelf) {
+	if err := h.loadShelf(r, &shelf); err != nil {
+		logging.FromContext(r.Context()).Error("error querying shelf entries", "shelf_id", shelf.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve shelf")
+		return
+	}
+	render(w, r, status, withShareURL(shelf))
+}
+
+// ListShelves lists the caller's shelves by name, without their entries
+func (h *Handler) ListShelves(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	shelves := []models.Shelf{}
+	if err := h.dbFor(r).Find(&shelves, "user_id = ?", principal.UserID).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying shelves", "user_id", principal.UserID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve shelves")
+		return
+	}
+	sort.Slice(shelves, func(i, j int) bool { return shelves[i].Name < shelves[j].Name })
+	for i := range shelves {
+		shelves[i] = withShareURL(shelves[i])
+	}
+
+	render(w, r, http.StatusOK, shelves)
+}
+
+// CreateShelf adds a private shelf for the caller
+func (h *Handler) CreateShelf(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	var body shelfBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := body.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shelf := models.Shelf{UserID: principal.UserID, Name: body.Name, Description: body.Description}
+	if err := h.dbFor(r).Create(&shelf).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			var existing models.Shelf
+			if h.dbFor(r).First(&existing, "user_id = ? AND name = ?", principal.UserID, body.Name).Error == nil {
+				w.Header().Set("Location", shelfLocation(existing.ID))
+			}
+			renderError(w, r, http.StatusConflict, errDuplicateShelf.Error())
+			return
+		}
+		logging.FromContext(r.Context()).Error("error creating shelf", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to create shelf")
+		return
+	}
+
+	w.Header().Set("Location", shelfLocation(shelf.ID))
+	render(w, r, http.StatusCreated, withShareURL(shelf))
+}
+
+// findShelf loads one of the caller's shelves, answering the request when
+// there is none. Other users' shelves are reported as missing.
+func (h *Handler) findShelf(w http.ResponseWriter, r *http.Request, userID int) (models.Shelf, bool) {
+	var shelf models.Shelf
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return shelf, false
+	}
+	if err := h.dbFor(r).First(&shelf, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Shelf not found")
+			return shelf, false
+		}
+		logging.FromContext(r.Context()).Error("error querying shelf", "shelf_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return shelf, false
+	}
+	return shelf, true
+}
+
+// GetShelf shows one of the caller's shelves with its entries in order
+func (h *Handler) GetShelf(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	shelf, ok := h.findShelf(w, r, principal.UserID)
+	if !ok {
+		return
+	}
+
+	h.renderShelf(w, r, http.StatusOK, shelf)
+}
+
+// UpdateShelf renames a shelf and changes its description
+func (h *Handler) UpdateShelf(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+	var body shelfBody
+	err = decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := body.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var shelf models.Shelf
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if shelf, err = lockShelf(tx, principal.UserID, id); err != nil {
+			return err
+		}
+		shelf.Name = body.Name
+		shelf.Description = body.Description
+		if err := tx.Save(&shelf).Error; err != nil {
+			if db.IsUniqueViolation(err) {
+				return errDuplicateShelf
+			}
+			return err
+		}
+		return nil
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	h.renderShelf(w, r, http.StatusOK, shelf)
+}
+
+// DeleteShelf removes a shelf and its entries; the books stay in the
+// catalogue
+func (h *Handler) DeleteShelf(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		shelf, err := lockShelf(tx, principal.UserID, id)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&shelf).Error
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	renderMessage(w, r, http.StatusOK, "Shelf deleted successfully")
+}
+
+// entryID parses the {entryID} parameter
+func entryID(r *http.Request) (int, error) {
+	return strconv.Atoi(chi.URLParam(r, "entryID"))
+}
+
+// decodeEntryBody reads and validates an entry payload, answering the
+// request when it is invalid
+func decodeEntryBody(w http.ResponseWriter, r *http.Request) (entryBody, bool) {
+	var body entryBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return body, false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return body, false
+	}
+	if err := body.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return body, false
+	}
+	return body, true
+}
+
+// AddShelfEntry puts a book on one of the caller's shelves
+func (h *Handler) AddShelfEntry(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+	body, ok := decodeEntryBody(w, r)
+	if !ok {
+		return
+	}
+
+	var shelf models.Shelf
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if shelf, err = lockShelf(tx, principal.UserID, id); err != nil {
+			return err
+		}
+		var book models.Book
+		if err := tx.First(&book, body.BookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w %d", errUnknownBook, body.BookID)
+			}
+			return err
+		}
+		entries, err := shelfEntries(tx, shelf.ID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.BookID == book.ID {
+				return errDuplicateEntry
+			}
+		}
+
+		at := len(entries)
+		if body.Position != nil {
+			at = min(*body.Position-1, len(entries))
+		}
+		entry := models.ShelfEntry{
+			ShelfID:    shelf.ID,
+			BookID:     book.ID,
+			Position:   at + 1,
+			Note:       body.Note,
+			StartedAt:  body.StartedAt,
+			FinishedAt: body.FinishedAt,
+		}
+		if err := tx.Create(&entry).Error; err != nil {
+			if db.IsUniqueViolation(err) {
+				return errDuplicateEntry
+			}
+			return err
+		}
+		// Make room for the new entry, closing any gaps on the way
+		entries = append(entries[:at:at], append([]models.ShelfEntry{entry}, entries[at:]...)...)
+		return renumberEntries(tx, entries)
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Location", shelfLocation(shelf.ID))
+	h.renderShelf(w, r, http.StatusCreated, shelf)
+}
+
+// UpdateShelfEntry replaces the note and dates of an entry and, given a
+// position, moves it
+func (h *Handler) UpdateShelfEntry(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+	entryID, err := entryID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid entry ID")
+		return
+	}
+	body, ok := decodeEntryBody(w, r)
+	if !ok {
+		return
+	}
+
+	var shelf models.Shelf
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if shelf, err = lockShelf(tx, principal.UserID, id); err != nil {
+			return err
+		}
+		entries, err := shelfEntries(tx, shelf.ID)
+		if err != nil {
+			return err
+		}
+		index := -1
+		for i, entry := range entries {
+			if entry.ID == entryID {
+				index = i
+			}
+		}
+		if index < 0 {
+			return errEntryNotFound
+		}
+
+		entry := entries[index]
+		entry.Note = body.Note
+		entry.StartedAt = body.StartedAt
+		entry.FinishedAt = body.FinishedAt
+		if err := tx.Save(&entry).Error; err != nil {
+			return err
+		}
+		if body.Position != nil {
+			entries = moveEntry(entries, index, *body.Position)
+		}
+		return renumberEntries(tx, entries)
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	h.renderShelf(w, r, http.StatusOK, shelf)
+}
+
+// DeleteShelfEntry takes a book off a shelf
+func (h *Handler) DeleteShelfEntry(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+	entryID, err := entryID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid entry ID")
+		return
+	}
+
+	var shelf models.Shelf
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if shelf, err = lockShelf(tx, principal.UserID, id); err != nil {
+			return err
+		}
+		entries, err := shelfEntries(tx, shelf.ID)
+		if err != nil {
+			return err
+		}
+		for i, entry := range entries {
+			if entry.ID != entryID {
+				continue
+			}
+			if err := tx.Delete(&entry).Error; err != nil {
+				return err
+			}
+			return renumberEntries(tx, append(entries[:i:i], entries[i+1:]...))
+		}
+		return errEntryNotFound
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	h.renderShelf(w, r, http.StatusOK, shelf)
+}
+
+// ReorderShelf puts the entries of a shelf in the order of the entry_ids
+// of the payload
+func (h *Handler) ReorderShelf(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+	var body orderBody
+	err = decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var shelf models.Shelf
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if shelf, err = lockShelf(tx, principal.UserID, id); err != nil {
+			return err
+		}
+		entries, err := shelfEntries(tx, shelf.ID)
+		if err != nil {
+			return err
+		}
+		if entries, err = reorderEntries(entries, body.EntryIDs); err != nil {
+			return err
+		}
+		return renumberEntries(tx, entries)
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	h.renderShelf(w, r, http.StatusOK, shelf)
+}
+
+// ShareShelf makes a shelf readable by anyone with its public link. Sharing
+// a shared shelf again replaces the link, so the old one stops working.
+func (h *Handler) ShareShelf(w http.ResponseWriter, r *http.Request) {
+	h.setShareToken(w, r, true)
+}
+
+// UnshareShelf makes a shelf private again and revokes its public link
+func (h *Handler) UnshareShelf(w http.ResponseWriter, r *http.Request) {
+	h.setShareToken(w, r, false)
+}
+
+func (h *Handler) setShareToken(w http.ResponseWriter, r *http.Request, share bool) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid shelf ID")
+		return
+	}
+
+	var shelf models.Shelf
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if shelf, err = lockShelf(tx, principal.UserID, id); err != nil {
+			return err
+		}
+		shelf.ShareToken = nil
+		if share {
+			token, err := newShareToken()
+			if err != nil {
+				return err
+			}
+			shelf.ShareToken = &token
+		}
+		return tx.Save(&shelf).Error
+	})
+	if !h.shelfWritten(w, r, err) {
+		return
+	}
+
+	h.renderShelf(w, r, http.StatusOK, shelf)
+}
+
+// GetSharedShelf shows a shared shelf to anyone holding its public link
+func (h *Handler) GetSharedShelf(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	token := chi.URLParam(r, "token")
+
+	var shelf models.Shelf
+	if err := h.dbFor(r).First(&shelf, "share_token = ?", token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Shelf not found")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error querying shared shelf", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if err := h.loadShelf(r, &shelf); err != nil {
+		logging.FromContext(r.Context()).Error("error querying shelf entries", "shelf_id", shelf.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve shelf")
+		return
+	}
+
+	render(w, r, http.StatusOK, sharedShelf{
+		Name:        shelf.Name,
+		Description: shelf.Description,
+		UpdatedAt:   shelf.UpdatedAt,
+		Entries:     shelf.Entries,
+	})
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func entryIDs(entries []models.ShelfEntry) []int {
+	ids := make([]int, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func TestListShelves_RequiresAuthentication(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB)}
+
+	w := httptest.NewRecorder()
+	handler.ListShelves(w, httptest.NewRequest(http.MethodGet, "/shelves", nil))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCreateShelf(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.Shelf")).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Shelf).ID = 3
+	}).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/shelves", strings.NewReader(`{"name":"  to read "}`))
+	w := httptest.NewRecorder()
+	handler.CreateShelf(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/shelves/3", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `"name":"to read"`)
+	assert.Contains(t, w.Body.String(), `"user_id":7`)
+	assert.NotContains(t, w.Body.String(), "share_url")
+}
+
+func TestCreateShelf_Invalid(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := httptest.NewRequest(http.MethodPost, "/shelves", strings.NewReader(`{"name":" "}`))
+	w := httptest.NewRecorder()
+	handler.CreateShelf(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestCreateShelf_Duplicate(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.Shelf")).Return(gorm.ErrDuplicatedKey)
+	mockDB.On("First", mock.AnythingOfType("*models.Shelf"), "user_id = ? AND name = ?").Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Shelf).ID = 2
+	}).Return(&gorm.DB{})
+
+	req := httptest.NewRequest(http.MethodPost, "/shelves", strings.NewReader(`{"name":"finished"}`))
+	w := httptest.NewRecorder()
+	handler.CreateShelf(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "/shelves/2", w.Header().Get("Location"))
+}
+
+// TestGetShelf_DeletedBook checks that a shelf reads correctly after one of
+// its books left the catalogue: the entry is gone and the positions of the
+// others close up
+func TestGetShelf_DeletedBook(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	token := "s3cret"
+	mockDB.On("First", mock.AnythingOfType("*models.Shelf"), "id = ? AND user_id = ?").Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Shelf) = models.Shelf{ID: 3, UserID: 7, Name: "reading", ShareToken: &token}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.ShelfEntry"), []interface{}{"shelf_id = ?", 3}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.ShelfEntry) = []models.ShelfEntry{
+			{ID: 12, ShelfID: 3, BookID: 20, Position: 4},
+			{ID: 10, ShelfID: 3, BookID: 10, Position: 1},
+		}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.Book"), []interface{}{"id IN ?", []int{10, 20}}).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Book) = []models.Book{{ID: 20, Name: "Dune"}, {ID: 10, Name: "Emma"}}
+	}).Return(&gorm.DB{})
+
+	req := withID(httptest.NewRequest(http.MethodGet, "/shelves/3", nil), "3")
+	w := httptest.NewRecorder()
+	handler.GetShelf(w, asUser(req, 7, models.RoleUser))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `"share_url":"/shared/shelves/s3cret"`)
+	assert.Contains(t, body, `"id":10,"shelf_id":3,"book_id":10,"book":{"id":10,"name":"Emma"`)
+	assert.Regexp(t, `"id":10,.*"position":1,.*"id":12,.*"position":2,`, body)
+}
+
+func TestGetShelf_OtherUser(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.Shelf"), "id = ? AND user_id = ?").Return(&gorm.DB{Error: gorm.ErrRecordNotFound})
+
+	req := withID(httptest.NewRequest(http.MethodGet, "/shelves/3", nil), "3")
+	w := httptest.NewRecorder()
+	handler.GetShelf(w, asUser(req, 8, models.RoleUser))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGetSharedShelf(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	token := "s3cret"
+	mockDB.On("First", mock.AnythingOfType("*models.Shelf"), "share_token = ?").Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.Shelf) = models.Shelf{ID: 3, UserID: 7, Name: "reading", ShareToken: &token}
+	}).Return(&gorm.DB{})
+	mockDB.On("Find", mock.AnythingOfType("*[]models.ShelfEntry"), []interface{}{"shelf_id = ?", 3}).Return(&gorm.DB{})
+
+	req := withURLParam(withID(httptest.NewRequest(http.MethodGet, "/shared/shelves/s3cret", nil), ""), "token", "s3cret")
+	w := httptest.NewRecorder()
+	handler.GetSharedShelf(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"reading","description":"","updated_at":"0001-01-01T00:00:00Z","entries":[]}`, w.Body.String())
+}
+
+func TestShelfWritten(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{errShelfNotFound, http.StatusNotFound},
+		{errEntryNotFound, http.StatusNotFound},
+		{errDuplicateEntry, http.StatusConflict},
+		{errDuplicateShelf, http.StatusConflict},
+		{errUnknownBook, http.StatusUnprocessableEntity},
+		{errBadOrder, http.StatusBadRequest},
+		{errors.New("boom"), http.StatusInternalServerError},
+	} {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+		mockDB.On("Transaction", mock.Anything).Return(tc.err)
+
+		req := withID(httptest.NewRequest(http.MethodPut, "/shelves/3/order", strings.NewReader(`{"entry_ids":[1,2]}`)), "3")
+		w := httptest.NewRecorder()
+		handler.ReorderShelf(w, asUser(req, 7, models.RoleUser))
+
+		assert.Equal(t, tc.code, w.Code, tc.err.Error())
+	}
+}
+
+func TestAddShelfEntry_InvalidDates(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := withID(httptest.NewRequest(http.MethodPost, "/shelves/3/entries",
+		strings.NewReader(`{"book_id":1,"started_at":"2024-03-02T00:00:00Z","finished_at":"2024-03-01T00:00:00Z"}`)), "3")
+	w := httptest.NewRecorder()
+	handler.AddShelfEntry(w, asUser(req, 7, models.RoleUser))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"finished_at is before started_at"}`, w.Body.String())
+	mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+}
+
+func TestMoveEntry(t *testing.T) {
+	entries := func() []models.ShelfEntry {
+		return []models.ShelfEntry{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+	}
+	assert.Equal(t, []int{3, 1, 2, 4}, entryIDs(moveEntry(entries(), 2, 1)))
+	assert.Equal(t, []int{2, 3, 1, 4}, entryIDs(moveEntry(entries(), 0, 3)))
+	assert.Equal(t, []int{2, 3, 4, 1}, entryIDs(moveEntry(entries(), 0, 99)))
+}
+
+func TestReorderEntries(t *testing.T) {
+	entries := []models.ShelfEntry{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	ordered, err := reorderEntries(entries, []int{3, 1, 2})
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 1, 2}, entryIDs(ordered))
+
+	for _, ids := range [][]int{{1, 2}, {1, 2, 2}, {1, 2, 4}, {1, 2, 3, 4}} {
+		_, err := reorderEntries(entries, ids)
+		assert.ErrorIs(t, err, errBadOrder, "%v", ids)
+	}
+}
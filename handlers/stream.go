@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"connection_to_pg/events"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHeartbeat is how often an idle event stream sends a comment to
+// keep proxies from closing it, unless Handler.Heartbeat says otherwise
+const defaultHeartbeat = 15 * time.Second
+
+// replayBatch is the number of events read from the outbox at a time when
+// a stream resumes
+const replayBatch = 500
+
+// eventFilter selects the events a stream client is interested in
+type eventFilter struct {
+	types   []string
+	bookIDs []string
+}
+
+// parseEventFilter reads the optional type and book_id parameters, both
+// comma separated lists
+func parseEventFilter(query url.Values) (eventFilter, error) {
+	var filter eventFilter
+	for _, field := range splitList(query.Get("type")) {
+		if !slices.Contains(models.EventTypes, field) {
+			return filter, fmt.Errorf("unknown event type %q", field)
+		}
+		filter.types = append(filter.types, field)
+	}
+	for _, field := range splitList(query.Get("book_id")) {
+		if _, err := strconv.Atoi(field); err != nil {
+			return filter, fmt.Errorf("invalid book_id %q", field)
+		}
+		filter.bookIDs = append(filter.bookIDs, field)
+	}
+	return filter, nil
+}
+
+// splitList splits a comma separated parameter, dropping empty fields
+func splitList(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (f eventFilter) match(event models.OutboxEvent) bool {
+	return (len(f.types) == 0 || slices.Contains(f.types, event.Type)) &&
+		(len(f.bookIDs) == 0 || slices.Contains(f.bookIDs, event.Subject))
+}
+
+// lastEventID returns the cursor of the event a reconnecting client saw
+// last, sent by browsers as the Last-Event-ID header or by hand as
+// ?last_event_id
+func lastEventID(r *http.Request) (events.Cursor, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return events.Cursor{}, false, nil
+	}
+	cursor, err := events.ParseCursor(value, time.Now())
+	if err != nil {
+		return events.Cursor{}, false, errors.New("invalid Last-Event-ID")
+	}
+	return cursor, true, nil
+}
+
+// writeEvent writes one event in Server-Sent Events format. The stream
+// cursor after the event is its id, which clients send back to resume: the
+// outbox sequence, followed by the lower ones still to commit if any.
+func writeEvent(w http.ResponseWriter, event models.OutboxEvent, cursor events.Cursor) error {
+	data, err := json.Marshal(event.CloudEvent())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", cursor, event.Type, data)
+	return err
+}
+
+// StreamBookEvents streams book changes as Server-Sent Events.
+//
+// Query parameters:
+//
+//	type      only these event types, e.g. "books.book.deleted"
+//	book_id   only events of these books, e.g. "1,2"
+//
+// A client resuming with Last-Event-ID first gets the events it missed
+// from the outbox, including those that committed after later ones it
+// saw, then the live ones. A client that can't keep up is
+// disconnected and catches up the same way when it reconnects.
+func (h *Handler) StreamBookEvents(w http.ResponseWriter, r *http.Request) {
+	if h.Events == nil {
+		renderError(w, r, http.StatusServiceUnavailable, "Event stream is not available")
+		return
+	}
+	filter, err := parseEventFilter(r.URL.Query())
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursor, resume, err := lastEventID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger := logging.FromContext(r.Context())
+
+	// Subscribe before reading the outbox so nothing falls between the
+	// replay and the live events; the cursor skips what was seen twice
+	subscription := h.Events.Subscribe()
+	defer h.Events.Unsubscribe(subscription)
+	if !resume {
+		cursor = h.Events.Cursor()
+	}
+	// send writes event unless the stream has seen it or doesn't want it
+	send := func(event models.OutboxEvent) error {
+		if !cursor.Advance(event.ID, time.Now()) || !filter.match(event) {
+			return nil
+		}
+		return writeEvent(w, event, cursor)
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if gaps := cursor.Pending(time.Now()); resume && len(gaps) > 0 {
+		var filled []models.OutboxEvent
+		if err := h.dbFor(r).Where("id IN ?", gaps).Order("id").Find(&filled).Error; err != nil {
+			logger.Error("error replaying events", "gaps", gaps, "error", err)
+			return
+		}
+		for _, event := range filled {
+			if send(event) != nil {
+				return
+			}
+		}
+	}
+	for resume {
+		var missed []models.OutboxEvent
+		if err := h.dbFor(r).Where("id > ?", cursor.Last).Order("id").Limit(replayBatch).Find(&missed).Error; err != nil {
+			logger.Error("error replaying events", "after", cursor.Last, "error", err)
+			return
+		}
+		for _, event := range missed {
+			if send(event) != nil {
+				return
+			}
+		}
+		resume = len(missed) == replayBatch
+	}
+	if controller.Flush() != nil {
+		return
+	}
+
+	heartbeat := h.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.C:
+			if !ok {
+				if subscription.Dropped() {
+					logger.Info("event stream fell behind, disconnecting", "last_event_id", cursor.String())
+				}
+				return
+			}
+			if send(event) != nil || controller.Flush() != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil || controller.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bufio"
+	"connection_to_pg/events"
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEventFilter(t *testing.T) {
+	filter, err := parseEventFilter(url.Values{"type": {"books.book.deleted, books.book.created"}, "book_id": {"3"}})
+	require.NoError(t, err)
+	assert.True(t, filter.match(models.OutboxEvent{Type: models.EventBookDeleted, Subject: "3"}))
+	assert.False(t, filter.match(models.OutboxEvent{Type: models.EventBookUpdated, Subject: "3"}))
+	assert.False(t, filter.match(models.OutboxEvent{Type: models.EventBookDeleted, Subject: "4"}))
+
+	all, err := parseEventFilter(url.Values{})
+	require.NoError(t, err)
+	assert.True(t, all.match(models.OutboxEvent{Type: models.EventBookUpdated, Subject: "9"}))
+
+	_, err = parseEventFilter(url.Values{"type": {"books.book.read"}})
+	assert.Error(t, err)
+	_, err = parseEventFilter(url.Values{"book_id": {"x"}})
+	assert.Error(t, err)
+}
+
+func TestLastEventID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/books/events?last_event_id=5", nil)
+	cursor, ok, err := lastEventID(req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), cursor.Last)
+
+	req.Header.Set("Last-Event-ID", "7:6")
+	cursor, _, _ = lastEventID(req)
+	assert.Equal(t, int64(7), cursor.Last)
+	assert.Equal(t, []int64{6}, cursor.Gaps())
+
+	req.Header.Set("Last-Event-ID", "-1")
+	_, _, err = lastEventID(req)
+	assert.Error(t, err)
+
+	_, ok, err = lastEventID(httptest.NewRequest(http.MethodGet, "/books/events", nil))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestStreamBookEvents_Unavailable(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Handler{}).StreamBookEvents(w, httptest.NewRequest(http.MethodGet, "/books/events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestStreamBookEvents(t *testing.T) {
+	broker := events.NewBroker(nil, time.Second, 8)
+	handler := &Handler{Events: broker, Heartbeat: 20 * time.Millisecond}
+	server := httptest.NewServer(http.HandlerFunc(handler.StreamBookEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?type=books.book.created")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		require.True(t, lines.Scan())
+		return lines.Text()
+	}
+	assert.Equal(t, "retry: 3000", next())
+	assert.Equal(t, "", next())
+
+	// The subscription is made before the headers are sent
+	for _, event := range []models.OutboxEvent{
+		{ID: 1, EventID: "a", Type: models.EventBookDeleted, Subject: "1", Data: []byte(`{"id":1}`)},
+		{ID: 2, EventID: "b", Type: models.EventBookCreated, Subject: "2", Data: []byte(`{"id":2}`)},
+	} {
+		broker.Publish(event)
+	}
+
+	line := next()
+	for strings.HasPrefix(line, ": heartbeat") || line == "" {
+		line = next()
+	}
+	assert.Equal(t, "id: 2", line)
+	assert.Equal(t, "event: books.book.created", next())
+	assert.Contains(t, next(), `"id":"b"`)
+}
+
+func TestStreamBookEvents_ResumeLateEvent(t *testing.T) {
+	outbox := &mocks.Outbox{}
+	database, err := outbox.Open()
+	require.NoError(t, err)
+	broker := events.NewBroker(nil, time.Second, 8)
+	handler := &Handler{DB: database, Events: broker, Heartbeat: time.Minute}
+	server := httptest.NewServer(http.HandlerFunc(handler.StreamBookEvents))
+	defer server.Close()
+
+	// The client saw 3 while 2 was still to commit, which it since did,
+	// along with 4
+	outbox.Commit(
+		models.OutboxEvent{ID: 1, EventID: "a", Type: models.EventBookCreated, Subject: "1", Data: []byte(`{"id":1}`)},
+		models.OutboxEvent{ID: 2, EventID: "b", Type: models.EventBookCreated, Subject: "2", Data: []byte(`{"id":2}`)},
+		models.OutboxEvent{ID: 3, EventID: "c", Type: models.EventBookCreated, Subject: "3", Data: []byte(`{"id":3}`)},
+		models.OutboxEvent{ID: 4, EventID: "d", Type: models.EventBookCreated, Subject: "4", Data: []byte(`{"id":4}`)},
+	)
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "3:2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	var ids []string
+	for len(ids) < 2 && lines.Scan() {
+		if id, ok := strings.CutPrefix(lines.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	assert.Equal(t, []string{"3", "4"}, ids)
+
+	// Live, 6 is published before 5
+	broker.Publish(models.OutboxEvent{ID: 6, EventID: "f", Type: models.EventBookCreated, Subject: "6", Data: []byte(`{"id":6}`)})
+	broker.Publish(models.OutboxEvent{ID: 5, EventID: "e", Type: models.EventBookCreated, Subject: "5", Data: []byte(`{"id":5}`)})
+	for len(ids) < 4 && lines.Scan() {
+		if id, ok := strings.CutPrefix(lines.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	assert.Equal(t, []string{"3", "4", "6:5", "6"}, ids)
+}
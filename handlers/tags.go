@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockForUpdate makes a query take row locks until the transaction ends
+var lockForUpdate = clause.Locking{Strength: "UPDATE"}
+
+// tagBody is the request payload for creating tags
+type tagBody struct {
+	Name string `json:"name" xml:"name"`
+}
+
+// normalizeTags normalises and de-duplicates tags, keeping their order
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range tags {
+		tag = models.NormalizeTag(tag)
+		if tag == "" {
+			return nil, errors.New("tags must not be empty")
+		}
+		if utf8.RuneCountInString(tag) > models.MaxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, models.MaxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+func (h *Handler) ListTags(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+
+	tags := []models.Tag{}
+	if err := h.dbFor(r).Find(&tags).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying tags table", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve tags")
+		return
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	render(w, r, http.StatusOK, tags)
+}
+
+func (h *Handler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+
+	var body tagBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	names, err := normalizeTags([]string{body.Name})
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tag := models.Tag{Name: names[0]}
+	if err := h.dbFor(r).Create(&tag).Error; err != nil {
+		if db.IsUniqueViolation(err) {
+			renderError(w, r, http.StatusConflict, "Tag already exists")
+			return
+		}
+		logging.FromContext(r.Context()).Error("error creating tag", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to create tag")
+		return
+	}
+
+	w.Header().Set("Location", "/tags/"+strconv.Itoa(tag.ID))
+	render(w, r, http.StatusCreated, tag)
+}
+
+// DeleteTag removes a tag from the catalogue and every book carrying it
+func (h *Handler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid tag ID")
+		return
+	}
+
+	var tag models.Tag
+	if err := h.dbFor(r).First(&tag, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Tag not found")
+			return
+		}
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if err := h.dbFor(r).Delete(&tag).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error deleting tag", "tag_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete tag")
+		return
+	}
+
+	renderMessage(w, r, http.StatusOK, "Tag deleted successfully")
+}
+
+// GetBookTags lists the tag names of a book
+func (h *Handler) GetBookTags(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	book, ok := h.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	var tags []models.Tag
+	if err := h.dbFor(r).Find(&tags, "id IN (SELECT tag_id FROM book_tags WHERE book_id = ?)", book.ID).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying book tags", "book_id", book.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve tags")
+		return
+	}
+
+	render(w, r, http.StatusOK, tagNames(tags))
+}
+
+func tagNames(tags []models.Tag) []string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetBookTags replaces the tags of a book with the names in the body,
+// creating tags that don't exist yet
+func (h *Handler) SetBookTags(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if !supportedRequestType(r) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	bookID, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	var body []string
+	if err := decodeBody(r, &body); err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request payload, expected a list of tags")
+		return
+	}
+	names, err := normalizeTags(body)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		var book models.Book
+		if err := tx.First(&book, bookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errBookNotFound
+			}
+			return err
+		}
+		if err := tx.Where("book_id = ?", bookID).Delete(&models.BookTag{}).Error; err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return nil
+		}
+
+		tags := make([]models.Tag, len(names))
+		for i, name := range names {
+			tags[i] = models.Tag{Name: name}
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error; err != nil {
+			return err
+		}
+		// Tags that already existed come back without an ID
+		if err := tx.Find(&tags, "name IN ?", names).Error; err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if err := tx.Create(&models.BookTag{BookID: bookID, TagID: tag.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBookNotFound):
+		renderError(w, r, http.StatusNotFound, "Book not found")
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("error tagging book", "book_id", bookID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update tags")
+		return
+	}
+
+	sort.Strings(names)
+	render(w, r, http.StatusOK, names)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBookFilters_Tags(t *testing.T) {
+	anyTag := parseBookFilters(url.Values{"tags": {"Sci-Fi, classic"}})
+	assert.Equal(t, []interface{}{
+		"id IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name IN ?)",
+		[]string{"sci-fi", "classic"},
+	}, anyTag.conds())
+
+	allTags := parseBookFilters(url.Values{"tags": {"sci-fi", "classic,sci-fi"}, "tag_match": {"all"}})
+	assert.Equal(t, []interface{}{
+		"id IN (SELECT bt.book_id FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE t.name IN ?" +
+			" GROUP BY bt.book_id HAVING COUNT(DISTINCT t.id) = ?)",
+		[]string{"sci-fi", "classic", "sci-fi"}, 2,
+	}, allTags.conds())
+
+	assert.Error(t, parseBookFilters(url.Values{"tag_match": {"some"}}).validate())
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{"Science  Fiction", "science fiction", "Classic"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"science fiction", "classic"}, tags)
+
+	_, err = normalizeTags([]string{" "})
+	assert.Error(t, err)
+	_, err = normalizeTags([]string{strings.Repeat("x", 51)})
+	assert.Error(t, err)
+}
+
+func TestSetBookTags_InvalidBody(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+
+	req := withID(httptest.NewRequest(http.MethodPut, "/books/1/tags", strings.NewReader(`{"tags":"classic"}`)), "1")
+	w := httptest.NewRecorder()
+	handler.SetBookTags(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertNotCalled(t, "Transaction", mock.Anything)
+}
+
+func TestSetBookTags(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Transaction", mock.Anything).Return(nil)
+
+	req := withID(httptest.NewRequest(http.MethodPut, "/books/1/tags", strings.NewReader(`["Classic","sci-fi","classic"]`)), "1")
+	w := httptest.NewRecorder()
+	handler.SetBookTags(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `["classic","sci-fi"]`, w.Body.String())
+}
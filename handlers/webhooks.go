@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"connection_to_pg/logging"
+	"connection_to_pg/models"
+	"connection_to_pg/webhooks"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// subscriptionBody is the request payload for creating and updating
+// webhook subscriptions
+type subscriptionBody struct {
+	URL         string   `json:"url" xml:"url"`
+	EventTypes  []string `json:"event_types" xml:"event_types>type"`
+	Description string   `json:"description" xml:"description"`
+	// Active pauses and resumes deliveries; subscriptions start active
+	Active *bool `json:"active" xml:"active"`
+}
+
+// validate checks the URL and event types of the body
+func (b *subscriptionBody) validate() error {
+	u, err := url.Parse(b.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	for _, eventType := range b.EventTypes {
+		if !slices.Contains(models.EventTypes, eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	if b.EventTypes == nil {
+		b.EventTypes = []string{}
+	}
+	return nil
+}
+
+// replayBody is the request payload of POST /webhooks/{id}/replay. Exactly
+// one of the fields selects the events to deliver again.
+type replayBody struct {
+	AfterSequence *int64     `json:"after_sequence" xml:"after_sequence"`
+	Since         *time.Time `json:"since" xml:"since"`
+}
+
+// replayResult reports how many deliveries a replay queued
+type replayResult struct {
+	Queued int64 `json:"queued" xml:"queued"`
+}
+
+var errNotDead = errors.New("only dead-lettered deliveries can be retried")
+
+func subscriptionLocation(id int) string {
+	return "/webhooks/" + strconv.Itoa(id)
+}
+
+// maxDeliveries caps the deliveries listed at once
+const maxDeliveries = 100
+
+// findSubscription loads the subscription named by the {id} parameter,
+// answering the request when there is none. The secret is not returned.
+func (h *Handler) findSubscription(w http.ResponseWriter, r *http.Request) (models.WebhookSubscription, bool) {
+	var subscription models.WebhookSubscription
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid subscription ID")
+		return subscription, false
+	}
+	if err := h.dbFor(r).First(&subscription, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			renderError(w, r, http.StatusNotFound, "Subscription not found")
+			return subscription, false
+		}
+		logging.FromContext(r.Context()).Error("error querying subscription", "subscription_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Database error")
+		return subscription, false
+	}
+	subscription.Secret = ""
+	return subscription, true
+}
+
+// decodeSubscriptionBody reads and validates a subscription payload,
+// answering the request when it is invalid
+func decodeSubscriptionBody(w http.ResponseWriter, r *http.Request) (subscriptionBody, bool) {
+	var body subscriptionBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return body, false
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return body, false
+	}
+	if err := body.validate(); err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return body, false
+	}
+	return body, true
+}
+
+// CreateSubscription registers a webhook. The response is the only place
+// the signing secret is shown.
+func (h *Handler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	body, ok := decodeSubscriptionBody(w, r)
+	if !ok {
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to create subscription")
+		return
+	}
+
+	subscription := models.WebhookSubscription{
+		URL:         body.URL,
+		Secret:      secret,
+		EventTypes:  body.EventTypes,
+		Description: body.Description,
+		Active:      body.Active == nil || *body.Active,
+	}
+	if err := h.dbFor(r).Create(&subscription).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error creating subscription", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to create subscription")
+		return
+	}
+
+	w.Header().Set("Location", subscriptionLocation(subscription.ID))
+	render(w, r, http.StatusCreated, subscription)
+}
+
+func (h *Handler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	subscriptions := []models.WebhookSubscription{}
+	if err := h.dbFor(r).Find(&subscriptions).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying subscriptions", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve subscriptions")
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	slices.SortFunc(subscriptions, func(a, b models.WebhookSubscription) int { return a.ID - b.ID })
+
+	render(w, r, http.StatusOK, subscriptions)
+}
+
+func (h *Handler) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	subscription, ok := h.findSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	render(w, r, http.StatusOK, subscription)
+}
+
+// UpdateSubscription changes the URL, event types, description and, when
+// given, the active flag of a subscription. The secret is kept.
+func (h *Handler) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	body, ok := decodeSubscriptionBody(w, r)
+	if !ok {
+		return
+	}
+	subscription, ok := h.findSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	subscription.URL = body.URL
+	subscription.EventTypes = body.EventTypes
+	subscription.Description = body.Description
+	if body.Active != nil {
+		subscription.Active = *body.Active
+	}
+	err := h.dbFor(r).Model(&subscription).Select("url", "event_types", "description", "active", "updated_at").Updates(&subscription).Error
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error updating subscription", "subscription_id", subscription.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to update subscription")
+		return
+	}
+
+	render(w, r, http.StatusOK, subscription)
+}
+
+// DeleteSubscription removes a subscription and its deliveries
+func (h *Handler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	subscription, ok := h.findSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.dbFor(r).Delete(&subscription).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error deleting subscription", "subscription_id", subscription.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete subscription")
+		return
+	}
+
+	renderMessage(w, r, http.StatusOK, "Subscription deleted successfully")
+}
+
+// ReplaySubscription queues the events after a sequence number or since a
+// time for delivery to the subscription again, whether or not they were
+// delivered before. Events the subscription doesn't want are skipped.
+func (h *Handler) ReplaySubscription(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	var body replayBody
+	err := decodeBody(r, &body)
+	if errors.Is(err, errUnsupportedMediaType) {
+		renderUnsupportedMediaType(w, r)
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if (body.AfterSequence == nil) == (body.Since == nil) {
+		renderError(w, r, http.StatusBadRequest, "Exactly one of after_sequence and since is required")
+		return
+	}
+	subscription, ok := h.findSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	var queued int64
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		events := tx.Model(&models.OutboxEvent{})
+		if body.AfterSequence != nil {
+			events = events.Where("id > ?", *body.AfterSequence)
+		} else {
+			events = events.Where("time >= ?", *body.Since)
+		}
+		if len(subscription.EventTypes) > 0 {
+			events = events.Where("type IN ?", subscription.EventTypes)
+		}
+		now := clock()
+		events = events.Select("?, id, ?, 0, ?, ?", subscription.ID, models.DeliveryPending, now, now)
+		result := tx.Exec("INSERT INTO webhook_deliveries (subscription_id, event_id, status, attempts, next_attempt_at, created_at) ?", events)
+		queued = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error replaying events", "subscription_id", subscription.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to replay events")
+		return
+	}
+
+	render(w, r, http.StatusAccepted, replayResult{Queued: queued})
+}
+
+// deliveryStatus parses the optional status parameter of delivery lists
+func deliveryStatus(r *http.Request) (string, error) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+		return status, nil
+	}
+	return "", errors.New("status must be pending, delivered or dead")
+}
+
+// GetSubscriptionDeliveries lists the latest deliveries to a subscription,
+// newest first, optionally only those with a ?status
+func (h *Handler) GetSubscriptionDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, true) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	status, err := deliveryStatus(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	subscription, ok := h.findSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.dbFor(r).Where("subscription_id = ?", subscription.ID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	deliveries := []models.WebhookDelivery{}
+	if err := query.Order("id DESC").Limit(maxDeliveries).Find(&deliveries).Error; err != nil {
+		logging.FromContext(r.Context()).Error("error querying deliveries", "subscription_id", subscription.ID, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve deliveries")
+		return
+	}
+
+	render(w, r, http.StatusOK, deliveries)
+}
+
+// GetDeadLetters lists the deliveries that ran out of attempts, across all
+// subscriptions and newest first, together with their events
+func (h *Handler) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	err := h.dbFor(r).Where("status = ?", models.DeliveryDead).Preload("Event").
+		Order("id DESC").Limit(maxDeliveries).Find(&deliveries).Error
+	if err != nil {
+		logging.FromContext(r.Context()).Error("error querying dead letters", "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retrieve dead letters")
+		return
+	}
+
+	render(w, r, http.StatusOK, deliveries)
+}
+
+// RetryDelivery puts a dead-lettered delivery back in the queue with a
+// fresh set of attempts
+func (h *Handler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	if !acceptable(w, r, false) {
+		return
+	}
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	id, err := pathID(r)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	err = h.dbFor(r).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(lockForUpdate).First(&delivery, id).Error; err != nil {
+			return err
+		}
+		if delivery.Status != models.DeliveryDead {
+			return errNotDead
+		}
+		delivery.Requeue(clock())
+		return tx.Save(&delivery).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		renderError(w, r, http.StatusNotFound, "Delivery not found")
+		return
+	case errors.Is(err, errNotDead):
+		renderError(w, r, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("error requeueing delivery", "delivery_id", id, "error", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to retry delivery")
+		return
+	}
+
+	render(w, r, http.StatusOK, delivery)
+}
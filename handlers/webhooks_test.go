@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestCreateSubscription(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("Create", mock.AnythingOfType("*models.WebhookSubscription")).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.WebhookSubscription).ID = 4
+	}).Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook","event_types":["books.book.deleted"]}`))
+	w := httptest.NewRecorder()
+	handler.CreateSubscription(w, asUser(req, 1, models.RoleAdmin))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/webhooks/4", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `"secret":"whsec_`)
+	assert.Contains(t, w.Body.String(), `"active":true`)
+}
+
+func TestCreateSubscription_Invalid(t *testing.T) {
+	for _, payload := range []string{
+		`{"url":"ftp://example.com/hook"}`,
+		`{"url":"/hook"}`,
+		`{"url":"https://example.com/hook","event_types":["books.book.read"]}`,
+	} {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(payload))
+		w := httptest.NewRecorder()
+		handler.CreateSubscription(w, asUser(req, 1, models.RoleAdmin))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, payload)
+		mockDB.AssertNotCalled(t, "Create", mock.Anything)
+	}
+}
+
+func TestCreateSubscription_AdminOnly(t *testing.T) {
+	handler := &Handler{DB: new(mocks.MockDB)}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook"}`))
+	w := httptest.NewRecorder()
+	handler.CreateSubscription(w, asUser(req, 2, models.RoleUser))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.CreateSubscription(w, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestGetSubscription_HidesSecret(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	handler := &Handler{DB: mockDB}
+	mockDB.On("First", mock.AnythingOfType("*models.WebhookSubscription"), 4).Run(func(args mock.Arguments) {
+		*args.Get(0).(*models.WebhookSubscription) = models.WebhookSubscription{ID: 4, URL: "https://example.com/hook", Secret: "whsec_x", Active: true}
+	}).Return(&gorm.DB{})
+
+	req := withID(httptest.NewRequest(http.MethodGet, "/webhooks/4", nil), "4")
+	w := httptest.NewRecorder()
+	handler.GetSubscription(w, asUser(req, 1, models.RoleAdmin))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "whsec_x")
+}
+
+func TestReplaySubscription_Invalid(t *testing.T) {
+	for _, payload := range []string{`{}`, `{"after_sequence":3,"since":"2024-03-01T00:00:00Z"}`} {
+		handler := &Handler{DB: new(mocks.MockDB)}
+
+		req := withID(httptest.NewRequest(http.MethodPost, "/webhooks/4/replay", strings.NewReader(payload)), "4")
+		w := httptest.NewRecorder()
+		handler.ReplaySubscription(w, asUser(req, 1, models.RoleAdmin))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, payload)
+	}
+}
+
+func TestRetryDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{nil, http.StatusOK},
+		{gorm.ErrRecordNotFound, http.StatusNotFound},
+		{errNotDead, http.StatusConflict},
+	} {
+		mockDB := new(mocks.MockDB)
+		handler := &Handler{DB: mockDB}
+		mockDB.On("Transaction", mock.Anything).Return(tc.err)
+
+		req := withID(httptest.NewRequest(http.MethodPost, "/webhooks/deliveries/5/retry", nil), "5")
+		w := httptest.NewRecorder()
+		handler.RetryDelivery(w, asUser(req, 1, models.RoleAdmin))
+
+		assert.Equal(t, tc.code, w.Code)
+	}
+}
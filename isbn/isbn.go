@@ -0,0 +1,116 @@
+// Package isbn validates and converts International Standard Book Numbers.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned for values that are not a valid ISBN-10 or ISBN-13
+var ErrInvalid = errors.New("invalid ISBN")
+
+// Clean removes the hyphens and spaces ISBNs are commonly printed with and
+// upper-cases an ISBN-10 "x" check digit
+func Clean(s string) string {
+	s = strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s))
+	return strings.ToUpper(s)
+}
+
+// Valid10 reports whether s is a hyphen-free ISBN-10 with a correct check
+// digit
+func Valid10(s string) bool {
+	if len(s) != 10 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var d int
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c == 'X' && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * d
+	}
+	return sum%11 == 0
+}
+
+// Valid13 reports whether s is a hyphen-free ISBN-13 with a correct check
+// digit
+func Valid13(s string) bool {
+	if len(s) != 13 || !(strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")) {
+		return false
+	}
+	for i := 0; i < 13; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return check13(s[:12]) == s[12]
+}
+
+// check13 computes the ISBN-13 check digit of the first 12 digits
+func check13(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(digits[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// check10 computes the ISBN-10 check digit of the first 9 digits
+func check10(digits string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(digits[i]-'0')
+	}
+	switch c := (11 - sum%11) % 11; c {
+	case 10:
+		return 'X'
+	default:
+		return byte('0' + c)
+	}
+}
+
+// To13 converts a valid ISBN-10 into the equivalent ISBN-13
+func To13(isbn10 string) (string, error) {
+	isbn10 = Clean(isbn10)
+	if !Valid10(isbn10) {
+		return "", ErrInvalid
+	}
+	prefix := "978" + isbn10[:9]
+	return prefix + string(check13(prefix)), nil
+}
+
+// To10 converts a valid ISBN-13 into an ISBN-10. Only 978 ISBNs have one;
+// ok is false for the 979 range.
+func To10(isbn13 string) (string, bool) {
+	isbn13 = Clean(isbn13)
+	if !Valid13(isbn13) || !strings.HasPrefix(isbn13, "978") {
+		return "", false
+	}
+	digits := isbn13[3:12]
+	return digits + string(check10(digits)), true
+}
+
+// Normalize accepts an ISBN-10 or ISBN-13, with or without hyphens, and
+// returns its hyphen-free ISBN-13 form
+func Normalize(s string) (string, error) {
+	s = Clean(s)
+	switch len(s) {
+	case 10:
+		return To13(s)
+	case 13:
+		if Valid13(s) {
+			return s, nil
+		}
+	}
+	return "", ErrInvalid
+}
@@ -0,0 +1,35 @@
+package isbn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"978-0-306-40615-7": "9780306406157",
+		"0-306-40615-2":     "9780306406157",
+		"0 8044 2957 x":     "9780804429573",
+		"979-10-90636-07-1": "9791090636071",
+	}
+	for input, want := range cases {
+		got, err := Normalize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	for _, input := range []string{"", "978-0-306-40615-8", "0-306-40615-3", "12345", "977-0-306-40615-7", "X306406152"} {
+		_, err := Normalize(input)
+		assert.ErrorIs(t, err, ErrInvalid, input)
+	}
+}
+
+func TestTo10(t *testing.T) {
+	isbn10, ok := To10("9780804429573")
+	assert.True(t, ok)
+	assert.Equal(t, "080442957X", isbn10)
+
+	_, ok = To10("9791090636071")
+	assert.False(t, ok)
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"connection_to_pg/tracing"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// SlowQueryThreshold is the duration above which queries are logged as
+// warnings
+const SlowQueryThreshold = 200 * time.Millisecond
+
+// GORMLogger sends GORM's log output to the logger in the query context.
+// Failed and slow queries are logged at warn/error level; every query is
+// logged at debug level. Literal values are stripped from the SQL.
+type GORMLogger struct{}
+
+// LogMode implements gorm's logger.Interface. The level is taken from the
+// slog handler instead.
+func (l GORMLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (GORMLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (GORMLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (GORMLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+// Trace logs a finished statement
+func (GORMLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	logger := FromContext(ctx)
+	elapsed := time.Since(begin)
+
+	level := slog.LevelDebug
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		level = slog.LevelError
+	case elapsed > SlowQueryThreshold:
+		level = slog.LevelWarn
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []slog.Attr{
+		slog.String("sql", tracing.SanitizeSQL(sql)),
+		slog.Int64("rows", rows),
+		slog.Duration("elapsed", elapsed),
+	}
+	msg := "query"
+	if level == slog.LevelError {
+		attrs = append(attrs, slog.Any("error", err))
+		msg = "query failed"
+	} else if level == slog.LevelWarn {
+		msg = "slow query"
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"connection_to_pg/requestid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// startCall puts a request logger, its route being the full gRPC method
+// name, into ctx and returns a function writing the access log record of
+// the call
+func startCall(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	info := &requestInfo{route: method}
+	logger := slog.New(&requestHandler{
+		Handler:   slog.Default().Handler(),
+		requestID: requestid.FromContext(ctx),
+		info:      info,
+	})
+	ctx = context.WithValue(ctx, requestInfoKey{}, info)
+	ctx = WithLogger(ctx, logger)
+
+	return ctx, func(err error) {
+		code := status.Code(err)
+		level := slog.LevelInfo
+		if isServerError(code) {
+			level = slog.LevelError
+		}
+		remote := ""
+		if p, ok := peer.FromContext(ctx); ok {
+			remote = p.Addr.String()
+		}
+		logger.LogAttrs(ctx, level, "rpc",
+			slog.String("method", method),
+			slog.String("code", code.String()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("remote_addr", remote),
+		)
+	}
+}
+
+// isServerError reports whether a gRPC status code blames the server
+func isServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// UnaryServerInterceptor is Middleware for unary gRPC calls. It must run
+// after requestid.UnaryServerInterceptor.
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, done := startCall(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	done(err)
+	return resp, err
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done := startCall(ss.Context(), info.FullMethod)
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	done(err)
+	return err
+}
+
+// serverStream is a server stream carrying the request logger
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
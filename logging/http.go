@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"connection_to_pg/requestid"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// requestInfo holds request details that are only known after the
+// request logger was created
+type requestInfo struct {
+	rctx *chi.Context
+	// route names requests chi doesn't route, such as gRPC calls
+	route     string
+	principal string
+}
+
+type requestInfoKey struct{}
+
+// requestHandler adds the request attributes to every record. They are
+// resolved when the record is written because the route and principal
+// are only known after routing and authentication.
+type requestHandler struct {
+	slog.Handler
+	requestID string
+	info      *requestInfo
+}
+
+func (h *requestHandler) Handle(ctx context.Context, record slog.Record) error {
+	route := h.info.route
+	if route == "" {
+		route = "unmatched"
+	}
+	if h.info.rctx != nil && h.info.rctx.RoutePattern() != "" {
+		route = h.info.rctx.RoutePattern()
+	}
+	principal := h.info.principal
+	if principal == "" {
+		principal = "anonymous"
+	}
+	record.AddAttrs(
+		slog.String("request_id", h.requestID),
+		slog.String("route", route),
+		slog.String("principal", principal),
+	)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &requestHandler{Handler: h.Handler.WithAttrs(attrs), requestID: h.requestID, info: h.info}
+}
+
+func (h *requestHandler) WithGroup(name string) slog.Handler {
+	return &requestHandler{Handler: h.Handler.WithGroup(name), requestID: h.requestID, info: h.info}
+}
+
+// SetPrincipal records the authenticated caller of the request in ctx on
+// the request logger. It does nothing outside Middleware.
+func SetPrincipal(ctx context.Context, name string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.principal = name
+	}
+}
+
+// Middleware puts a request logger carrying the request ID, route and
+// principal into the request context and writes one access log record per
+// request. It must be installed on a chi router after
+// requestid.Middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{rctx: chi.RouteContext(r.Context())}
+		logger := slog.New(&requestHandler{
+			Handler:   slog.Default().Handler(),
+			requestID: requestid.FromContext(r.Context()),
+			info:      info,
+		})
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx = WithLogger(ctx, logger)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(ctx, level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		}()
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connection_to_pg/models"
+	"connection_to_pg/requestid"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func useLogger(t *testing.T, cfg models.LoggingConfig) *bytes.Buffer {
+	var buf bytes.Buffer
+	logger, err := New(&buf, cfg)
+	require.NoError(t, err)
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &record), string(line))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRedactDSN(t *testing.T) {
+	cases := map[string]string{
+		"host=db user=app password=s3cret dbname=books":     "host=db user=app password=[REDACTED] dbname=books",
+		"host=db password='with space' sslmode=disable":     "host=db password=[REDACTED] sslmode=disable",
+		"postgres://app:s3cret@db:5432/books?sslmode=false": "postgres://app:[REDACTED]@db:5432/books?sslmode=false",
+		"host=db user=app": "host=db user=app",
+	}
+	for dsn, want := range cases {
+		assert.Equal(t, want, RedactDSN(dsn), dsn)
+	}
+}
+
+func TestNew_RedactsSecretsAndFiltersLevel(t *testing.T) {
+	buf := useLogger(t, models.LoggingConfig{Format: FormatJSON, Level: "info"})
+
+	slog.Debug("hidden")
+	slog.Info("connecting", "password", "s3cret", "error", errors.New("dial host=db password=s3cret sslmode=disable: refused"))
+
+	records := decodeLines(t, buf)
+	require.Len(t, records, 1)
+	assert.Equal(t, "[REDACTED]", records[0]["password"])
+	assert.Equal(t, "dial host=db password=[REDACTED] sslmode=disable: refused", records[0]["error"])
+}
+
+func TestNew_RejectsUnknownSettings(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, models.LoggingConfig{Format: "xml", Level: "info"})
+	assert.Error(t, err)
+	_, err = New(&bytes.Buffer{}, models.LoggingConfig{Format: FormatText, Level: "loud"})
+	assert.Error(t, err)
+}
+
+func TestMiddleware_RequestLoggerAndAccessLog(t *testing.T) {
+	buf := useLogger(t, models.LoggingConfig{Format: FormatJSON, Level: "info"})
+
+	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
+	r.Use(Middleware)
+	r.Get("/books/{query}", func(w http.ResponseWriter, r *http.Request) {
+		SetPrincipal(r.Context(), "alice")
+		FromContext(r.Context()).Info("looking up book")
+		w.Write([]byte("ok"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/books/7", nil)
+	req.Header.Set(requestid.Header, "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := decodeLines(t, buf)
+	require.Len(t, records, 2)
+	for _, record := range records {
+		assert.Equal(t, "req-1", record["request_id"])
+		assert.Equal(t, "/books/{query}", record["route"])
+		assert.Equal(t, "alice", record["principal"])
+	}
+	access := records[1]
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, float64(http.StatusOK), access["status"])
+	assert.Equal(t, float64(2), access["bytes"])
+	assert.Contains(t, access, "latency")
+}
+
+func TestUnaryServerInterceptor_LogsCallWithMethodAsRoute(t *testing.T) {
+	buf := useLogger(t, models.LoggingConfig{Format: FormatJSON, Level: "info"})
+
+	ctx := requestid.NewContext(context.Background(), "req-2")
+	info := &grpc.UnaryServerInfo{FullMethod: "/books.v1.BooksService/GetBook"}
+	_, err := UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		SetPrincipal(ctx, "alice")
+		return nil, status.Error(codes.Internal, "Database error")
+	})
+	require.Error(t, err)
+
+	records := decodeLines(t, buf)
+	require.Len(t, records, 1)
+	assert.Equal(t, "rpc", records[0]["msg"])
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, "req-2", records[0]["request_id"])
+	assert.Equal(t, "/books.v1.BooksService/GetBook", records[0]["route"])
+	assert.Equal(t, "alice", records[0]["principal"])
+	assert.Equal(t, "Internal", records[0]["code"])
+}
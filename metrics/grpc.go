@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// observeCall records a finished gRPC call
+func observeCall(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+// UnaryServerInterceptor records call counts, latency and in-flight calls
+// of unary gRPC methods
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	grpcInFlight.Inc()
+	defer grpcInFlight.Dec()
+
+	resp, err := handler(ctx, req)
+	observeCall(info.FullMethod, start, err)
+	return resp, err
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming methods;
+// their latency covers the whole stream
+func StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	grpcInFlight.Inc()
+	defer grpcInFlight.Dec()
+
+	err := handler(srv, ss)
+	observeCall(info.FullMethod, start, err)
+	return err
+}
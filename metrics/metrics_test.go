@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T) string {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMiddleware_LabelsByRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/widgets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, path := range []string{"/widgets/1", "/widgets/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t)
+	assert.Contains(t, body, `books_http_requests_total{method="GET",route="/widgets/{id}",status="418"} 2`)
+	assert.Contains(t, body, `books_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `books_http_request_duration_seconds_count{method="GET",route="/widgets/{id}",status="418"} 2`)
+	assert.Contains(t, body, "books_http_requests_in_flight 0")
+}
+
+func TestBusinessCounters(t *testing.T) {
+	BooksCreated.Inc()
+
+	body := scrape(t)
+	assert.Contains(t, body, "# TYPE books_created_total counter")
+	assert.Contains(t, body, "# TYPE books_deleted_total counter")
+}
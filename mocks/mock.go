@@ -1,6 +1,9 @@
 package mocks
 
 import (
+	"connection_to_pg/db"
+	"context"
+	"database/sql"
 	"fmt"
 
 	"github.com/stretchr/testify/mock"
@@ -81,3 +84,23 @@ func (m *MockDB) Delete(value interface{}) *gorm.DB {
 	}
 	return &gorm.DB{}
 }
+
+// Model returns the *gorm.DB query builder configured for the test
+func (m *MockDB) Model(value interface{}) *gorm.DB {
+	args := m.Called(value)
+	if db, ok := args.Get(0).(*gorm.DB); ok {
+		return db
+	}
+	return &gorm.DB{}
+}
+
+// Transaction returns the error configured for the test without running fc
+func (m *MockDB) Transaction(fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	args := m.Called(fc)
+	return args.Error(0)
+}
+
+// WithContext returns the mock itself; scoping needs no expectation
+func (m *MockDB) WithContext(ctx context.Context) db.Database {
+	return m
+}
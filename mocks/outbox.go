@@ -0,0 +1,115 @@
+package mocks
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/models"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Outbox is a database holding only the outbox. It answers the queries the
+// event broker and stream make of it from the events committed so far, in
+// whatever order they were committed.
+type Outbox struct {
+	mu     sync.Mutex
+	events []models.OutboxEvent
+}
+
+// Commit makes events visible to the queries that follow
+func (o *Outbox) Commit(events ...models.OutboxEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, events...)
+	slices.SortFunc(o.events, func(a, b models.OutboxEvent) int { return int(a.ID - b.ID) })
+}
+
+// Open returns a database reading from the outbox
+func (o *Outbox) Open() (db.Database, error) {
+	database, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(o)}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &db.DatabaseImpl{DB: database}, nil
+}
+
+func (o *Outbox) Connect(context.Context) (driver.Conn, error) { return outboxConn{o}, nil }
+func (o *Outbox) Driver() driver.Driver                        { return nil }
+
+// outboxConn answers the latest sequences, events by sequence and the
+// events after a sequence
+type outboxConn struct {
+	outbox *Outbox
+}
+
+func (c outboxConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c outboxConn) Close() error                        { return nil }
+func (c outboxConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c outboxConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.outbox.mu.Lock()
+	defer c.outbox.mu.Unlock()
+	var ids []int64
+	for _, arg := range args {
+		if id, ok := arg.Value.(int64); ok {
+			ids = append(ids, id)
+		}
+	}
+	rows := &outboxRows{}
+	switch {
+	case strings.Contains(query, "ORDER BY id DESC"):
+		rows.columns = []string{"id"}
+		for _, event := range slices.Backward(c.outbox.events) {
+			rows.rows = append(rows.rows, []driver.Value{event.ID})
+		}
+		return rows, nil
+	case strings.Contains(query, "id IN"):
+	case strings.Contains(query, "id > $1") && len(ids) > 0:
+		after := ids[0]
+		ids = nil
+		for _, event := range c.outbox.events {
+			if event.ID > after {
+				ids = append(ids, event.ID)
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query: " + query)
+	}
+	rows.columns = []string{"id", "event_id", "type", "subject", "data"}
+	for _, event := range c.outbox.events {
+		if slices.Contains(ids, event.ID) {
+			rows.rows = append(rows.rows, []driver.Value{event.ID, event.EventID, event.Type, event.Subject, []byte(event.Data)})
+		}
+	}
+	return rows, nil
+}
+
+type outboxRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *outboxRows) Columns() []string { return r.columns }
+func (r *outboxRows) Close() error      { return nil }
+
+func (r *outboxRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Author is a person credited on books
+type Author struct {
+	ID   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name" gorm:"not null"`
+	// NormalizedName is unique, so spellings such as "J.K. Rowling" and
+	// "JK Rowling" resolve to the same author
+	NormalizedName string    `json:"-" xml:"-" gorm:"uniqueIndex;not null"`
+	Bio            string    `json:"bio" xml:"bio"`
+	CreatedAt      time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// BookAuthor credits an author on a book in a given role. A person can hold
+// several roles on the same book.
+type BookAuthor struct {
+	BookID   int    `json:"book_id" xml:"book_id" gorm:"primaryKey"`
+	Book     Book   `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	AuthorID int    `json:"author_id" xml:"author_id" gorm:"primaryKey;index"`
+	Author   Author `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Role     string `json:"role" xml:"role" gorm:"primaryKey"`
+	// Position orders the credits of a book, starting at 0
+	Position int `json:"position" xml:"position"`
+}
+
+// Author roles
+const (
+	AuthorRoleAuthor     = "author"
+	AuthorRoleEditor     = "editor"
+	AuthorRoleTranslator = "translator"
+)
+
+// AuthorRoles lists the valid BookAuthor roles
+var AuthorRoles = []string{AuthorRoleAuthor, AuthorRoleEditor, AuthorRoleTranslator}
+
+// ValidAuthorRole reports whether role is one of AuthorRoles
+func ValidAuthorRole(role string) bool {
+	for _, r := range AuthorRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorSeparator separates the names of several authors in the free-text
+// Author of a book
+const AuthorSeparator = ", "
+
+// SplitAuthors returns the names a free-text Author lists, in order,
+// without blank or repeated ones
+func SplitAuthors(author string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(author, AuthorSeparator) {
+		normalized := NormalizeAuthorName(name)
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names
+}
+
+// NormalizeAuthorName folds case and drops everything but letters and
+// digits, so punctuation and spacing differences don't create duplicates
+func NormalizeAuthorName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// BeforeSave keeps NormalizedName in step with Name
+func (a *Author) BeforeSave(tx *gorm.DB) error {
+	a.Name = strings.TrimSpace(a.Name)
+	a.NormalizedName = NormalizeAuthorName(a.Name)
+	if a.NormalizedName == "" {
+		return errors.New("author name must contain a letter or digit")
+	}
+	return nil
+}
+
+// AfterSave keeps the author credits of a book in step with its free-text
+// Author, which lists names separated by AuthorSeparator. The first save
+// credits each of them, creating the Author records on first use; a save
+// that changes them replaces the credits in the author role with the new
+// names, from the place of the first of them. Editors and translators are
+// left alone, as are saves keeping the names of the credited authors,
+// which SetBookAuthors joins into Author.
+func (b *Book) AfterSave(tx *gorm.DB) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	var credited []struct {
+		Name     string
+		Position int
+	}
+	err := tx.Model(&BookAuthor{}).Select("authors.name, book_authors.position").
+		Joins("JOIN authors ON authors.id = book_authors.author_id").
+		Where("book_authors.book_id = ? AND book_authors.role = ?", b.ID, AuthorRoleAuthor).
+		Order("book_authors.position").Scan(&credited).Error
+	if err != nil {
+		return err
+	}
+	var names strings.Builder
+	for _, credit := range credited {
+		names.WriteString(credit.Name)
+	}
+	if NormalizeAuthorName(names.String()) == NormalizeAuthorName(b.Author) {
+		return nil
+	}
+
+	if len(credited) == 0 {
+		return CreditAuthors(tx, b.ID, b.Author)
+	}
+	err = tx.Where("book_id = ? AND role = ?", b.ID, AuthorRoleAuthor).Delete(&BookAuthor{}).Error
+	if err != nil {
+		return err
+	}
+	for i, name := range SplitAuthors(b.Author) {
+		if err := creditAuthorAt(tx, b.ID, name, credited[0].Position+i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreditAuthors finds or creates each author the free-text author lists
+// and credits them in the author role on the book, in order after its
+// other credits
+func CreditAuthors(tx *gorm.DB, bookID int, author string) error {
+	var position int64
+	if err := tx.Model(&BookAuthor{}).Where("book_id = ?", bookID).Count(&position).Error; err != nil {
+		return err
+	}
+	for i, name := range SplitAuthors(author) {
+		if err := creditAuthorAt(tx, bookID, name, int(position)+i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// creditAuthorAt finds or creates the author called name and credits them
+// in the author role on the book at the given position
+func creditAuthorAt(tx *gorm.DB, bookID int, name string, position int) error {
+	author, err := FindOrCreateAuthor(tx, name)
+	if err != nil {
+		return err
+	}
+	credit := BookAuthor{BookID: bookID, AuthorID: author.ID, Role: AuthorRoleAuthor, Position: position}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&credit).Error
+}
+
+// FindOrCreateAuthor returns the author whose normalised name matches name
+func FindOrCreateAuthor(tx *gorm.DB, name string) (Author, error) {
+	author := Author{Name: name}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "normalized_name"}},
+		DoNothing: true,
+	}).Create(&author).Error
+	if err != nil {
+		return author, err
+	}
+	if author.ID != 0 {
+		return author, nil
+	}
+	err = tx.Where("normalized_name = ?", NormalizeAuthorName(name)).First(&author).Error
+	return author, err
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestNormalizeAuthorName(t *testing.T) {
+	assert.Equal(t, "jkrowling", NormalizeAuthorName("J.K. Rowling"))
+	assert.Equal(t, "jkrowling", NormalizeAuthorName(" JK  Rowling "))
+	assert.Equal(t, "gabrielgarcíamárquez", NormalizeAuthorName("Gabriel García Márquez"))
+	assert.Equal(t, "", NormalizeAuthorName(" - "))
+}
+
+func TestSplitAuthors(t *testing.T) {
+	assert.Equal(t, []string{"A. Smith", "B. Jones"}, SplitAuthors("A. Smith, B. Jones"))
+	assert.Equal(t, []string{"Neil Gaiman"}, SplitAuthors(" Neil Gaiman , , neil gaiman"))
+	assert.Empty(t, SplitAuthors(""))
+}
+
+func TestValidAuthorRole(t *testing.T) {
+	assert.True(t, ValidAuthorRole(AuthorRoleTranslator))
+	assert.False(t, ValidAuthorRole("illustrator"))
+}
+
+// scriptedConn is a database connection that records the statements sent
+// to it and answers queries with the rows answer returns for them
+type scriptedConn struct {
+	answer     func(query string) (columns []string, rows [][]driver.Value)
+	statements []string
+}
+
+func (c *scriptedConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *scriptedConn) Driver() driver.Driver                        { return nil }
+func (c *scriptedConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *scriptedConn) Close() error                                 { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c *scriptedConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.statements = append(c.statements, query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *scriptedConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.statements = append(c.statements, query)
+	columns, rows := c.answer(query)
+	return &scriptedRows{columns: columns, rows: rows}, nil
+}
+
+type scriptedRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func openScripted(t *testing.T, conn *scriptedConn) *gorm.DB {
+	t.Helper()
+	database, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	require.NoError(t, err)
+	return database
+}
+
+// creditedAs answers the query of the author credits of a book with names
+// at positions 0, 1, ... and new authors with ID 9
+func creditedAs(names ...string) func(string) ([]string, [][]driver.Value) {
+	return func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.HasPrefix(query, "SELECT authors.name"):
+			rows := make([][]driver.Value, len(names))
+			for i, name := range names {
+				rows[i] = []driver.Value{name, int64(i)}
+			}
+			return []string{"name", "position"}, rows
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			return []string{"count"}, [][]driver.Value{{int64(len(names))}}
+		case strings.HasPrefix(query, "INSERT INTO \"authors\""):
+			return []string{"id"}, [][]driver.Value{{int64(9)}}
+		}
+		return nil, nil
+	}
+}
+
+func TestBook_AfterSaveCreditsAuthor(t *testing.T) {
+	conn := &scriptedConn{answer: creditedAs()}
+	book := &Book{ID: 1, Author: "Frank Herbert"}
+
+	require.NoError(t, book.AfterSave(openScripted(t, conn)))
+	require.Len(t, conn.statements, 4)
+	assert.Contains(t, conn.statements[2], `INSERT INTO "authors"`)
+	assert.Contains(t, conn.statements[3], `INSERT INTO "book_authors"`)
+}
+
+func TestBook_AfterSaveCreditsEachAuthor(t *testing.T) {
+	conn := &scriptedConn{answer: creditedAs()}
+	book := &Book{ID: 1, Author: "Neil Gaiman, Terry Pratchett"}
+
+	require.NoError(t, book.AfterSave(openScripted(t, conn)))
+	require.Len(t, conn.statements, 6)
+	assert.Contains(t, conn.statements[2], `INSERT INTO "authors"`)
+	assert.Contains(t, conn.statements[3], `INSERT INTO "book_authors"`)
+	assert.Contains(t, conn.statements[4], `INSERT INTO "authors"`)
+	assert.Contains(t, conn.statements[5], `INSERT INTO "book_authors"`)
+}
+
+func TestBook_AfterSaveKeepsMatchingCredits(t *testing.T) {
+	conn := &scriptedConn{answer: creditedAs("Neil Gaiman", "Terry Pratchett")}
+	book := &Book{ID: 1, Author: "Neil Gaiman, Terry Pratchett"}
+
+	require.NoError(t, book.AfterSave(openScripted(t, conn)))
+	assert.Len(t, conn.statements, 1)
+}
+
+func TestBook_AfterSaveReplacesChangedAuthor(t *testing.T) {
+	conn := &scriptedConn{answer: creditedAs("Frank Herbert")}
+	book := &Book{ID: 1, Author: "Brian Herbert"}
+
+	require.NoError(t, book.AfterSave(openScripted(t, conn)))
+	require.Len(t, conn.statements, 4)
+	assert.Contains(t, conn.statements[1], `DELETE FROM "book_authors" WHERE book_id = $1 AND role = $2`)
+	assert.Contains(t, conn.statements[2], `INSERT INTO "authors"`)
+	assert.Contains(t, conn.statements[3], `INSERT INTO "book_authors"`)
+}
+
+func TestBook_AfterSaveDropsClearedAuthor(t *testing.T) {
+	conn := &scriptedConn{answer: creditedAs("Frank Herbert")}
+	book := &Book{ID: 1}
+
+	require.NoError(t, book.AfterSave(openScripted(t, conn)))
+	require.Len(t, conn.statements, 2)
+	assert.Contains(t, conn.statements[1], `DELETE FROM "book_authors"`)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Circulation rules
+const (
+	// DefaultLoanLimit is the number of copies a member can hold at once
+	// unless their LoanLimit says otherwise
+	DefaultLoanLimit = 5
+	// LoanPeriod is how long a copy can be kept per checkout or renewal
+	LoanPeriod = 14 * 24 * time.Hour
+	// MaxRenewals is how often a loan can be extended
+	MaxRenewals = 2
+)
+
+// Member is a library patron who can borrow copies
+type Member struct {
+	ID   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name" gorm:"not null"`
+	// Email is stored lower-cased and identifies the member
+	Email string `json:"email" xml:"email" gorm:"uniqueIndex;not null"`
+	// LoanLimit caps the member's open loans
+	LoanLimit int       `json:"loan_limit" xml:"loan_limit" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// NormalizeEmail trims and lower-cases an email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Copy is a physical copy of a book that can be lent out
+type Copy struct {
+	ID     int  `json:"id" xml:"id"`
+	BookID int  `json:"book_id" xml:"book_id" gorm:"index;not null"`
+	Book   Book `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// Barcode is the label stuck on the copy
+	Barcode   string    `json:"barcode" xml:"barcode" gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// Loan records a copy lent to a member. A copy has at most one open loan,
+// one whose ReturnedAt is nil. Copies with loans can't be deleted, so the
+// lending history and the fines it led to keep their source.
+type Loan struct {
+	ID           int        `json:"id" xml:"id"`
+	CopyID       int        `json:"copy_id" xml:"copy_id" gorm:"not null;uniqueIndex:idx_loans_open_copy,where:returned_at IS NULL"`
+	Copy         Copy       `json:"-" xml:"-" gorm:"constraint:OnDelete:RESTRICT"`
+	MemberID     int        `json:"member_id" xml:"member_id" gorm:"index;not null"`
+	Member       Member     `json:"-" xml:"-" gorm:"constraint:OnDelete:RESTRICT"`
+	CheckedOutAt time.Time  `json:"checked_out_at" xml:"checked_out_at" gorm:"not null"`
+	DueAt        time.Time  `json:"due_at" xml:"due_at" gorm:"index;not null"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty" xml:"returned_at,omitempty"`
+	Renewals     int        `json:"renewals" xml:"renewals"`
+}
+
+// Open reports whether the copy is still out
+func (l *Loan) Open() bool {
+	return l.ReturnedAt == nil
+}
+
+// Overdue reports whether the loan is open past its due date
+func (l *Loan) Overdue(now time.Time) bool {
+	return l.Open() && now.After(l.DueAt)
+}
+
+// DaysOverdue returns the number of started days the loan is overdue
+func (l *Loan) DaysOverdue(now time.Time) int {
+	if !l.Open() {
+		return 0
+	}
+	return DaysLate(l.DueAt, now)
+}
+
+// DaysLate returns the number of started days at is past due
+func DaysLate(due, at time.Time) int {
+	if !at.After(due) {
+		return 0
+	}
+	return int((at.Sub(due) + 24*time.Hour - 1) / (24 * time.Hour))
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoan_Overdue(t *testing.T) {
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	loan := Loan{DueAt: due}
+
+	assert.False(t, loan.Overdue(due))
+	assert.Equal(t, 0, loan.DaysOverdue(due.Add(-time.Hour)))
+	assert.True(t, loan.Overdue(due.Add(time.Minute)))
+	assert.Equal(t, 1, loan.DaysOverdue(due.Add(time.Minute)))
+	assert.Equal(t, 3, loan.DaysOverdue(due.Add(48*time.Hour+time.Second)))
+
+	returned := due.Add(-time.Hour)
+	loan.ReturnedAt = &returned
+	assert.False(t, loan.Overdue(due.Add(72*time.Hour)))
+}
+
+func TestFinePolicy_Fine(t *testing.T) {
+	policy := FinePolicy{DailyRate: 25, GraceDays: 2, MaxFine: 200}
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, int64(0), policy.Fine(due, due.Add(-time.Hour)))
+	assert.Equal(t, int64(0), policy.Fine(due, due.Add(47*time.Hour)))
+	assert.Equal(t, int64(25), policy.Fine(due, due.Add(49*time.Hour)))
+	assert.Equal(t, int64(125), policy.Fine(due, due.Add(7*24*time.Hour)))
+	assert.Equal(t, int64(200), policy.Fine(due, due.Add(30*24*time.Hour)))
+
+	policy.MaxFine = 0
+	assert.Equal(t, int64(700), policy.Fine(due, due.Add(30*24*time.Hour)))
+}
+
+func TestFineEntry_AppendOnly(t *testing.T) {
+	entry := &FineEntry{}
+	assert.ErrorIs(t, entry.BeforeUpdate(nil), ErrLedgerAppendOnly)
+	assert.ErrorIs(t, entry.BeforeDelete(nil), ErrLedgerAppendOnly)
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// Cover describes the cover image of a book. The image data itself lives
+// in blob storage under CoverKey.
+type Cover struct {
+	BookID int  `json:"book_id" xml:"book_id" gorm:"primaryKey;autoIncrement:false"`
+	Book   Book `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// ContentType is the sniffed type of the original upload
+	ContentType string `json:"content_type" xml:"content_type" gorm:"not null"`
+	// ThumbnailType is the type the thumbnails were encoded in
+	ThumbnailType string `json:"thumbnail_type" xml:"thumbnail_type" gorm:"not null"`
+	Size          int64  `json:"size" xml:"size"`
+	Width         int    `json:"width" xml:"width"`
+	Height        int    `json:"height" xml:"height"`
+	// ETag is the hex SHA-256 of the original image
+	ETag      string    `json:"etag" xml:"etag" gorm:"not null"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// CoverSizes maps thumbnail names to the longest side they are scaled to
+var CoverSizes = map[string]int{
+	"small":  150,
+	"medium": 400,
+}
+
+// CoverKey returns the blob storage key of a book's cover in the given
+// size, "original" for the upload itself
+func CoverKey(bookID int, size string) string {
+	return "covers/" + strconv.Itoa(bookID) + "/" + size
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Book event types, as used in the CloudEvents type attribute
+const (
+	EventBookCreated = "books.book.created"
+	EventBookUpdated = "books.book.updated"
+	EventBookDeleted = "books.book.deleted"
+)
+
+// EventTypes lists every event type that is published
+var EventTypes = []string{EventBookCreated, EventBookUpdated, EventBookDeleted}
+
+// EventSource is the CloudEvents source of the events of this service
+const EventSource = "/books"
+
+// OutboxEvent is a change waiting in the transactional outbox. Events are
+// written in the transaction that makes the change, so an event exists
+// exactly when its change was committed. The ID grows with every event and
+// orders the outbox.
+type OutboxEvent struct {
+	ID int64 `json:"sequence" xml:"sequence"`
+	// EventID is the CloudEvents id, unique per event
+	EventID string    `json:"id" xml:"id" gorm:"uniqueIndex;not null"`
+	Type    string    `json:"type" xml:"type" gorm:"index;not null"`
+	Subject string    `json:"subject" xml:"subject" gorm:"not null"`
+	Time    time.Time `json:"time" xml:"time" gorm:"not null"`
+	// Data is the JSON payload: the book as written, or just its id once
+	// deleted
+	Data json.RawMessage `json:"data" xml:"-" gorm:"type:jsonb;not null"`
+	// DispatchedAt is set once deliveries to the webhook subscriptions
+	// have been queued
+	DispatchedAt *time.Time `json:"-" xml:"-" gorm:"index"`
+}
+
+// CloudEvent is an event in the CloudEvents 1.0 JSON format
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// CloudEvent returns the event in CloudEvents format
+func (e *OutboxEvent) CloudEvent() CloudEvent {
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              e.EventID,
+		Source:          EventSource,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		Time:            e.Time,
+		DataContentType: "application/json",
+		Data:            e.Data,
+	}
+}
+
+// NewBookEvent builds the outbox event for a change to book
+func NewBookEvent(eventType string, book *Book) (OutboxEvent, error) {
+	var payload any = book
+	if eventType == EventBookDeleted {
+		payload = struct {
+			ID int `json:"id"`
+		}{book.ID}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{
+		EventID: uuid.NewString(),
+		Type:    eventType,
+		Subject: strconv.Itoa(book.ID),
+		Time:    time.Now().UTC(),
+		Data:    data,
+	}, nil
+}
+
+// RecordBookEvent appends an event for a change to book to the outbox of
+// the transaction making the change. The Book hooks call it; changes that
+// bypass them, such as raw updates, call it themselves. Bulk updates
+// without a loaded book have no single book to report and are skipped.
+func RecordBookEvent(tx *gorm.DB, eventType string, book *Book) error {
+	if book.ID == 0 {
+		return nil
+	}
+	event, err := NewBookEvent(eventType, book)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&event).Error
+}
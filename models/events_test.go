@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBookEvent(t *testing.T) {
+	book := &Book{ID: 7, Name: "Dune", Author: "Frank Herbert"}
+
+	event, err := NewBookEvent(EventBookUpdated, book)
+	require.NoError(t, err)
+	assert.NotEmpty(t, event.EventID)
+	assert.Equal(t, "7", event.Subject)
+	assert.JSONEq(t, `{"id":7,"name":"Dune","description":"","author":"Frank Herbert"}`, string(event.Data))
+
+	body, err := json.Marshal(event.CloudEvent())
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &decoded))
+	assert.Equal(t, "1.0", decoded["specversion"])
+	assert.Equal(t, EventSource, decoded["source"])
+	assert.Equal(t, EventBookUpdated, decoded["type"])
+	assert.Equal(t, event.EventID, decoded["id"])
+
+	deleted, err := NewBookEvent(EventBookDeleted, book)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":7}`, string(deleted.Data))
+	assert.NotEqual(t, event.EventID, deleted.EventID)
+}
+
+func TestWebhookSubscription_Wants(t *testing.T) {
+	all := WebhookSubscription{Active: true}
+	assert.True(t, all.Wants(EventBookDeleted))
+
+	some := WebhookSubscription{Active: true, EventTypes: []string{EventBookCreated}}
+	assert.True(t, some.Wants(EventBookCreated))
+	assert.False(t, some.Wants(EventBookDeleted))
+
+	paused := WebhookSubscription{}
+	assert.False(t, paused.Wants(EventBookCreated))
+}
+
+func TestWebhookDelivery_Attempts(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	delivery := WebhookDelivery{Status: DeliveryPending, NextAttemptAt: now}
+
+	delivery.Failed(503, "endpoint answered 503", now, 2, time.Minute)
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Equal(t, now.Add(time.Minute), delivery.NextAttemptAt)
+	assert.Equal(t, 1, delivery.Attempts)
+
+	delivery.Failed(0, "connection refused", now, 2, time.Minute)
+	assert.Equal(t, DeliveryDead, delivery.Status)
+	assert.Equal(t, "connection refused", delivery.LastError)
+
+	delivery.Requeue(now)
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Equal(t, 0, delivery.Attempts)
+
+	delivery.Succeeded(204, now)
+	assert.Equal(t, DeliveryDelivered, delivery.Status)
+	assert.Equal(t, &now, delivery.DeliveredAt)
+	assert.Empty(t, delivery.LastError)
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FinePolicy sets how late returns are charged. Amounts are in cents.
+type FinePolicy struct {
+	// DailyRate is charged for every started day a copy is late
+	DailyRate int64
+	// GraceDays are late days that are not charged
+	GraceDays int
+	// MaxFine caps the fine for a single loan; 0 means no cap
+	MaxFine int64
+	// BlockThreshold is the balance above which checkouts are refused
+	BlockThreshold int64
+}
+
+// Fine returns the charge for a copy due at due and returned at returned
+func (p FinePolicy) Fine(due, returned time.Time) int64 {
+	days := DaysLate(due, returned) - p.GraceDays
+	if days <= 0 {
+		return 0
+	}
+	fine := int64(days) * p.DailyRate
+	if p.MaxFine > 0 && fine > p.MaxFine {
+		fine = p.MaxFine
+	}
+	return fine
+}
+
+// Ledger entry kinds
+const (
+	FineCharge  = "charge"
+	FinePayment = "payment"
+	FineWaiver  = "waiver"
+)
+
+// ErrLedgerAppendOnly is returned when a ledger entry would be changed
+var ErrLedgerAppendOnly = errors.New("fine ledger entries can't be changed or removed")
+
+// FineEntry is a line in a member's fine ledger. Charges are positive,
+// payments and waivers negative, so the balance is the sum of Amount.
+// Entries are never updated or deleted; mistakes are corrected with a
+// waiver.
+type FineEntry struct {
+	ID       int    `json:"id" xml:"id"`
+	MemberID int    `json:"member_id" xml:"member_id" gorm:"index;not null"`
+	Member   Member `json:"-" xml:"-" gorm:"constraint:OnDelete:RESTRICT"`
+	// LoanID is set on the charge for a late return
+	LoanID    *int      `json:"loan_id,omitempty" xml:"loan_id,omitempty" gorm:"index"`
+	Loan      *Loan     `json:"-" xml:"-" gorm:"constraint:OnDelete:SET NULL"`
+	Kind      string    `json:"kind" xml:"kind" gorm:"not null"`
+	Amount    int64     `json:"amount_cents" xml:"amount_cents" gorm:"not null"`
+	Note      string    `json:"note,omitempty" xml:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// BeforeUpdate keeps the ledger append-only
+func (*FineEntry) BeforeUpdate(*gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
+
+// BeforeDelete keeps the ledger append-only
+func (*FineEntry) BeforeDelete(*gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
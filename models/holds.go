@@ -0,0 +1,99 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// Hold statuses. Waiting and ready holds are active; the others are final.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// PickupWindow is how long a copy allocated to a hold is kept for the
+// member before the hold expires and the copy moves down the queue
+const PickupWindow = 3 * 24 * time.Hour
+
+// Hold queues a member for the next free copy of a book. Holds are served
+// first come, first served.
+type Hold struct {
+	ID     int  `json:"id" xml:"id"`
+	BookID int  `json:"book_id" xml:"book_id" gorm:"not null;index:idx_holds_queue,priority:1;uniqueIndex:idx_holds_active_member,priority:1,where:closed_at IS NULL"`
+	Book   Book `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// A member has at most one active hold per book
+	MemberID int    `json:"member_id" xml:"member_id" gorm:"not null;index;uniqueIndex:idx_holds_active_member,priority:2,where:closed_at IS NULL"`
+	Member   Member `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Status   string `json:"status" xml:"status" gorm:"not null;index"`
+	// CopyID is the copy set aside for the member once the hold is ready
+	CopyID    *int       `json:"copy_id,omitempty" xml:"copy_id,omitempty" gorm:"index"`
+	Copy      *Copy      `json:"-" xml:"-" gorm:"constraint:OnDelete:SET NULL"`
+	CreatedAt time.Time  `json:"created_at" xml:"created_at" gorm:"index:idx_holds_queue,priority:2"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty" xml:"ready_at,omitempty"`
+	// ExpiresAt ends the pickup window of a ready hold
+	ExpiresAt *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty" xml:"closed_at,omitempty"`
+
+	// Position is the place in the queue of an active hold, 1 for the next
+	// member to be served; ready holds have position 0
+	Position int `json:"position" xml:"position" gorm:"-"`
+	// EstimatedAvailableAt guesses when a waiting hold becomes ready
+	EstimatedAvailableAt *time.Time `json:"estimated_available_at,omitempty" xml:"estimated_available_at,omitempty" gorm:"-"`
+}
+
+// Active reports whether the hold is still queued or awaiting pickup
+func (h *Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
+
+// Allocate sets copyID aside for the hold and starts its pickup window
+func (h *Hold) Allocate(copyID int, now time.Time) {
+	expires := now.Add(PickupWindow)
+	h.Status = HoldReady
+	h.CopyID = &copyID
+	h.ReadyAt = &now
+	h.ExpiresAt = &expires
+}
+
+// Close ends an active hold with a final status
+func (h *Hold) Close(status string, now time.Time) {
+	h.Status = status
+	h.ClosedAt = &now
+}
+
+// SortHolds orders holds by queue position: first come, first served
+func SortHolds(holds []Hold) {
+	sort.SliceStable(holds, func(i, j int) bool {
+		if !holds[i].CreatedAt.Equal(holds[j].CreatedAt) {
+			return holds[i].CreatedAt.Before(holds[j].CreatedAt)
+		}
+		return holds[i].ID < holds[j].ID
+	})
+}
+
+// EstimateAvailability guesses when the copy for each queue position frees
+// up. Copies come back when their loans are due and every copy is assumed
+// to be borrowed for a full LoanPeriod by each member ahead in the queue.
+// dueDates are the due dates of the open loans, available the number of
+// copies free right now. It returns nil when no copy can ever serve the
+// queue.
+func EstimateAvailability(position int, dueDates []time.Time, available int, now time.Time) *time.Time {
+	if position <= available {
+		return &now
+	}
+	if len(dueDates) == 0 {
+		return nil
+	}
+	sorted := append([]time.Time(nil), dueDates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	n := position - available - 1
+	at := sorted[n%len(sorted)].Add(time.Duration(n/len(sorted)) * LoanPeriod)
+	if at.Before(now) {
+		at = now
+	}
+	return &at
+}
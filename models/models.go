@@ -1,16 +1,85 @@
 package models
 
+import (
+	"connection_to_pg/isbn"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
 type Book struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Author      string `json:"author"`
+	ID          int    `json:"id" xml:"id"`
+	Name        string `json:"name" xml:"name"`
+	Description string `json:"description" xml:"description"`
+	Author      string `json:"author" xml:"author"`
+	// ISBN13 is stored hyphen-free and identifies the book uniquely.
+	// ISBN10 is derived from it for 978 ISBNs.
+	ISBN13 *string `json:"isbn13,omitempty" xml:"isbn13,omitempty" gorm:"uniqueIndex;size:13"`
+	ISBN10 *string `json:"isbn10,omitempty" xml:"isbn10,omitempty" gorm:"size:10"`
+	// The rating aggregates are maintained by the reviews endpoints in the
+	// same transaction as the review itself; GORM never writes them.
+	RatingAverage float64 `json:"rating_average,omitempty" xml:"rating_average,omitempty" gorm:"<-:false;not null;default:0;index"`
+	RatingCount   int     `json:"rating_count,omitempty" xml:"rating_count,omitempty" gorm:"<-:false;not null;default:0;index"`
+	RatingSum     int     `json:"-" xml:"-" gorm:"<-:false;not null;default:0"`
+}
+
+// NormalizeISBN validates the ISBNs of a book and stores them in canonical
+// form. Either field may hold an ISBN-10 or ISBN-13 with or without
+// hyphens; when both are given they must denote the same book.
+func (b *Book) NormalizeISBN() error {
+	var canonical string
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{{"isbn13", b.ISBN13}, {"isbn10", b.ISBN10}} {
+		if field.value == nil || *field.value == "" {
+			continue
+		}
+		normalized, err := isbn.Normalize(*field.value)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", field.name, *field.value, err)
+		}
+		if canonical != "" && canonical != normalized {
+			return fmt.Errorf("isbn10 and isbn13 denote different books: %w", isbn.ErrInvalid)
+		}
+		canonical = normalized
+	}
+
+	b.ISBN13, b.ISBN10 = nil, nil
+	if canonical == "" {
+		return nil
+	}
+	b.ISBN13 = &canonical
+	if isbn10, ok := isbn.To10(canonical); ok {
+		b.ISBN10 = &isbn10
+	}
+	return nil
+}
+
+// BeforeSave keeps the ISBNs normalised however the book is written
+func (b *Book) BeforeSave(tx *gorm.DB) error {
+	return b.NormalizeISBN()
+}
+
+// AfterCreate, AfterUpdate and AfterDelete record every change to a book in
+// the outbox, inside the transaction GORM runs the change in
+func (b *Book) AfterCreate(tx *gorm.DB) error {
+	return RecordBookEvent(tx, EventBookCreated, b)
+}
+
+func (b *Book) AfterUpdate(tx *gorm.DB) error {
+	return RecordBookEvent(tx, EventBookUpdated, b)
+}
+
+func (b *Book) AfterDelete(tx *gorm.DB) error {
+	return RecordBookEvent(tx, EventBookDeleted, b)
 }
 
 type CreateBookBody struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Author      string `json:"author"`
+	Name        string `json:"name" xml:"name"`
+	Description string `json:"description" xml:"description"`
+	Author      string `json:"author" xml:"author"`
 }
 
 type DatabaseConfig struct {
@@ -20,4 +89,107 @@ type DatabaseConfig struct {
 	SSLMode  string
 	Host     string
 	Port     string
+	// Replicas are the DSNs of read replicas of the database above, which
+	// is the primary
+	Replicas []string
+	// ReplicaCheckInterval is how often the replicas are health checked
+	ReplicaCheckInterval time.Duration
+	// ReadYourWrites is how long the reads of a client go to the primary
+	// after it wrote, zero sending them to the replicas right away
+	ReadYourWrites time.Duration
+	// ConnectAttempts is how many times the database is tried at start
+	// before giving up, ConnectBackoff the wait after the first failure,
+	// which doubles after every further one
+	ConnectAttempts int
+	ConnectBackoff  time.Duration
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime size the connection
+	// pools of the primary and of each replica. Zero leaves the number of
+	// open connections and their lifetime unlimited.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// QueryAttempts is how many times an idempotent statement is tried
+	// when it fails with a transient error, one disabling retries
+	QueryAttempts int
+}
+
+type ServerConfig struct {
+	Addr string
+	// GRPCAddr is where the gRPC server listens, disabled when empty
+	GRPCAddr string
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported to
+type TracingConfig struct {
+	// Exporter is one of none, stdout, otlp or otlpfile
+	Exporter    string
+	ServiceName string
+	// File is the OTLP/JSON output path used by the otlpfile exporter
+	File        string
+	SampleRatio float64
+}
+
+// StorageConfig configures where uploaded files such as cover images are
+// kept
+type StorageConfig struct {
+	// Dir is the root directory of the local blob store
+	Dir string
+	// MaxCoverBytes limits the size of a cover upload
+	MaxCoverBytes int64
+}
+
+// WebhookConfig tunes the delivery of events to webhook subscriptions
+type WebhookConfig struct {
+	// PollInterval is how often the outbox and due deliveries are checked
+	PollInterval time.Duration
+	// Timeout limits a single delivery request
+	Timeout time.Duration
+	// MaxAttempts is how often a delivery is tried before it is
+	// dead-lettered
+	MaxAttempts int
+	// BatchSize caps the events and deliveries handled per poll
+	BatchSize int
+}
+
+// EventsConfig tunes the live event stream
+type EventsConfig struct {
+	// PollInterval is how often the outbox is checked for new events
+	PollInterval time.Duration
+	// Buffer is the number of events a slow stream client may fall behind
+	// before it is disconnected
+	Buffer int
+	// Heartbeat is how often idle streams send a keep-alive comment
+	Heartbeat time.Duration
+}
+
+// GraphQLConfig limits the queries the GraphQL endpoint runs
+type GraphQLConfig struct {
+	// MaxDepth is how deeply selections may nest
+	MaxDepth int
+	// MaxComplexity caps the estimated number of fields resolved, with
+	// list fields counted once per item they may return
+	MaxComplexity int
+}
+
+// CacheConfig tunes the cache in front of book reads. Each operation is
+// cached for its own TTL, zero leaving it uncached.
+type CacheConfig struct {
+	// Size is the number of entries the in-process cache holds, zero
+	// disabling the cache
+	Size int
+	// BookTTL caches lookups of a book by id
+	BookTTL time.Duration
+	// ISBNTTL caches lookups of a book by ISBN
+	ISBNTTL time.Duration
+	// ListTTL caches book lists. Lists filtered by tag or category may
+	// miss changes to those links for up to the TTL.
+	ListTTL time.Duration
+}
+
+// LoggingConfig selects the format and minimum level of log output
+type LoggingConfig struct {
+	// Format is text or json
+	Format string
+	// Level is debug, info, warn or error
+	Level string
 }
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Rating bounds
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Review is a user's star rating and review of a book. A user reviews a
+// book at most once.
+type Review struct {
+	ID     int  `json:"id" xml:"id"`
+	BookID int  `json:"book_id" xml:"book_id" gorm:"not null;uniqueIndex:idx_reviews_book_user,priority:1"`
+	Book   Book `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// UserID is cleared when the user is deleted; the review and its
+	// share of the book's rating are kept
+	UserID *int  `json:"user_id,omitempty" xml:"user_id,omitempty" gorm:"uniqueIndex:idx_reviews_book_user,priority:2;index"`
+	User   *User `json:"-" xml:"-" gorm:"constraint:OnDelete:SET NULL"`
+	// Author is the username of the reviewer when the review was written
+	Author    string    `json:"author" xml:"author" gorm:"not null"`
+	Rating    int       `json:"rating" xml:"rating" gorm:"not null;check:chk_reviews_rating,rating BETWEEN 1 AND 5"`
+	Text      string    `json:"text" xml:"text"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// ValidRating reports whether rating is a whole number of stars in range
+func ValidRating(rating int) bool {
+	return rating >= MinRating && rating <= MaxRating
+}
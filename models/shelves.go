@@ -0,0 +1,74 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxShelfNameLength limits shelf names, in characters
+const MaxShelfNameLength = 100
+
+// Shelf is a user's reading list, such as "to read" or "finished". Shelves
+// are private to their owner unless shared by public link.
+type Shelf struct {
+	ID     int  `json:"id" xml:"id"`
+	UserID int  `json:"user_id" xml:"user_id" gorm:"not null;uniqueIndex:idx_shelves_user_name,priority:1"`
+	User   User `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// Name is unique among the shelves of a user
+	Name        string `json:"name" xml:"name" gorm:"not null;uniqueIndex:idx_shelves_user_name,priority:2"`
+	Description string `json:"description" xml:"description"`
+	// ShareToken is the secret part of the public link of a shared shelf,
+	// nil while the shelf is private
+	ShareToken *string   `json:"-" xml:"-" gorm:"uniqueIndex;size:32"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" xml:"updated_at"`
+
+	// ShareURL is the public link of a shared shelf, shown to its owner
+	ShareURL string `json:"share_url,omitempty" xml:"share_url,omitempty" gorm:"-"`
+
+	// Entries are the books on the shelf in order; they are loaded
+	// separately and not written with the shelf
+	Entries []ShelfEntry `json:"entries,omitempty" xml:"entries>entry,omitempty" gorm:"-"`
+}
+
+// NormalizeShelfName trims a shelf name
+func NormalizeShelfName(name string) string {
+	return strings.TrimSpace(name)
+}
+
+// Shared reports whether the shelf can be read through its public link
+func (s *Shelf) Shared() bool {
+	return s.ShareToken != nil
+}
+
+// ShelfEntry places a book on a shelf. A book is on a shelf at most once;
+// entries are removed with the book when it leaves the catalogue.
+type ShelfEntry struct {
+	ID      int   `json:"id" xml:"id"`
+	ShelfID int   `json:"shelf_id" xml:"shelf_id" gorm:"not null;uniqueIndex:idx_shelf_entries_book,priority:1"`
+	Shelf   Shelf `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	BookID  int   `json:"book_id" xml:"book_id" gorm:"not null;index;uniqueIndex:idx_shelf_entries_book,priority:2"`
+	// Book is filled in for display only; it is never written with the
+	// entry
+	Book *Book `json:"book,omitempty" xml:"book,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	// Position orders the entries of a shelf, starting at 1. Deleting a
+	// book from the catalogue leaves a gap in the stored positions that is
+	// closed when the shelf is read or next changed.
+	Position   int        `json:"position" xml:"position" gorm:"not null"`
+	Note       string     `json:"note" xml:"note"`
+	StartedAt  *time.Time `json:"started_at,omitempty" xml:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" xml:"finished_at,omitempty"`
+	AddedAt    time.Time  `json:"added_at" xml:"added_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `json:"updated_at" xml:"updated_at"`
+}
+
+// SortShelfEntries orders entries by their stored position
+func SortShelfEntries(entries []ShelfEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Position != entries[j].Position {
+			return entries[i].Position < entries[j].Position
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
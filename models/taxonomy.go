@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Category is a node in the category tree. Path is the materialised path
+// of IDs from the root down to the category itself, e.g. "/1/4/9/", so a
+// subtree is every category whose path starts with its root's path.
+type Category struct {
+	ID        int       `json:"id" xml:"id"`
+	Name      string    `json:"name" xml:"name" gorm:"not null"`
+	ParentID  *int      `json:"parent_id" xml:"parent_id" gorm:"index"`
+	Parent    *Category `json:"-" xml:"-" gorm:"constraint:OnDelete:RESTRICT"`
+	Path      string    `json:"path" xml:"path" gorm:"not null;index:idx_categories_path,class:text_pattern_ops"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// CategoryPath returns the path of a category with the given ID below the
+// parent path, which is "/" for root categories
+func CategoryPath(parentPath string, id int) string {
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	return parentPath + strconv.Itoa(id) + "/"
+}
+
+// Depth returns the level of the category in the tree, 0 for roots
+func (c Category) Depth() int {
+	return strings.Count(c.Path, "/") - 2
+}
+
+// BookCategory files a book under a category
+type BookCategory struct {
+	BookID     int      `json:"book_id" xml:"book_id" gorm:"primaryKey"`
+	Book       Book     `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	CategoryID int      `json:"category_id" xml:"category_id" gorm:"primaryKey;index"`
+	Category   Category `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// Tag is a free-form label. Names are stored normalised by NormalizeTag.
+type Tag struct {
+	ID   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name" gorm:"uniqueIndex;not null"`
+}
+
+// BookTag links a tag to a book
+type BookTag struct {
+	BookID int  `json:"book_id" xml:"book_id" gorm:"primaryKey"`
+	Book   Book `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	TagID  int  `json:"tag_id" xml:"tag_id" gorm:"primaryKey;index"`
+	Tag    Tag  `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// MaxTagLength is the longest tag accepted, in runes
+const MaxTagLength = 50
+
+// NormalizeTag lower-cases a tag and collapses its whitespace, so "Science
+// Fiction" and "science  fiction" are the same tag
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
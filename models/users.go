@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// User is an operator or client account that owns API keys
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username" gorm:"uniqueIndex;not null"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role" gorm:"not null;default:user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey is a credential issued to a user. Only the SHA-256 hash of the key
+// is stored; the prefix is kept so keys can be told apart in listings.
+type APIKey struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id" gorm:"index;not null"`
+	User      User       `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix" gorm:"index"`
+	Hash      string     `json:"-" gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// User roles
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// WebhookSubscription registers a URL that book events are posted to
+type WebhookSubscription struct {
+	ID  int    `json:"id" xml:"id"`
+	URL string `json:"url" xml:"url" gorm:"not null"`
+	// Secret keys the HMAC-SHA256 signature of every delivery. It is only
+	// shown when the subscription is created.
+	Secret string `json:"secret,omitempty" xml:"secret,omitempty" gorm:"not null"`
+	// EventTypes limits the events delivered; empty means all of them
+	EventTypes  []string  `json:"event_types" xml:"event_types>type" gorm:"serializer:json;not null"`
+	Description string    `json:"description" xml:"description"`
+	Active      bool      `json:"active" xml:"active" gorm:"not null;default:true"`
+	CreatedAt   time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Wants reports whether events of eventType are delivered to the
+// subscription
+func (s *WebhookSubscription) Wants(eventType string) bool {
+	return s.Active && (len(s.EventTypes) == 0 || slices.Contains(s.EventTypes, eventType))
+}
+
+// Delivery statuses. Pending deliveries are retried until they succeed or
+// run out of attempts and are dead-lettered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is the delivery of one event to one subscription
+type WebhookDelivery struct {
+	ID             int                  `json:"id" xml:"id"`
+	SubscriptionID int                  `json:"subscription_id" xml:"subscription_id" gorm:"not null;index"`
+	Subscription   *WebhookSubscription `json:"-" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	EventID        int64                `json:"event_sequence" xml:"event_sequence" gorm:"not null;index"`
+	Event          *OutboxEvent         `json:"event,omitempty" xml:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Status         string               `json:"status" xml:"status" gorm:"not null;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts       int                  `json:"attempts" xml:"attempts"`
+	// NextAttemptAt is when a pending delivery is due
+	NextAttemptAt time.Time  `json:"next_attempt_at" xml:"next_attempt_at" gorm:"not null;index:idx_webhook_deliveries_due,priority:2"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty" xml:"last_attempt_at,omitempty"`
+	// ResponseStatus is the HTTP status of the last attempt, 0 when the
+	// request failed before a response
+	ResponseStatus int        `json:"response_status,omitempty" xml:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" xml:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" xml:"created_at"`
+}
+
+// Succeeded records a successful attempt
+func (d *WebhookDelivery) Succeeded(status int, now time.Time) {
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = status
+	d.LastError = ""
+	d.Status = DeliveryDelivered
+	d.DeliveredAt = &now
+}
+
+// Failed records a failed attempt. The delivery is retried after backoff
+// or, once maxAttempts are used up, dead-lettered.
+func (d *WebhookDelivery) Failed(status int, reason string, now time.Time, maxAttempts int, backoff time.Duration) {
+	d.Attempts++
+	d.LastAttemptAt = &now
+	d.ResponseStatus = status
+	d.LastError = reason
+	if d.Attempts >= maxAttempts {
+		d.Status = DeliveryDead
+		return
+	}
+	d.NextAttemptAt = now.Add(backoff)
+}
+
+// Requeue makes a dead delivery pending again with a fresh set of attempts
+func (d *WebhookDelivery) Requeue(now time.Time) {
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = now
+}
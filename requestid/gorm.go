@@ -0,0 +1,59 @@
+package requestid
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InstrumentGORM prefixes every statement built for a request with a
+// comment naming its request ID, e.g.
+//
+//	/* request_id='3f2a…' */ SELECT * FROM "books" WHERE ...
+//
+// so Postgres logs and pg_stat_activity can be matched to API calls.
+// Statements only carry the comment when built with WithContext. Raw SQL,
+// run with Exec or read with Raw, is tagged the same way.
+//
+// The comment makes the text of every request's statements unique, so the
+// pools of the database package run statements unprepared instead of
+// caching prepared statements by their text.
+func InstrumentGORM(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("requestid:create", comment("INSERT")),
+		cb.Query().Before("gorm:query").Register("requestid:query", comment("SELECT")),
+		cb.Update().Before("gorm:update").Register("requestid:update", comment("UPDATE")),
+		cb.Delete().Before("gorm:delete").Register("requestid:delete", comment("DELETE")),
+		cb.Row().Before("gorm:row").Register("requestid:row", comment("SELECT")),
+		cb.Raw().Before("gorm:raw").Register("requestid:raw", comment("")),
+	)
+}
+
+// comment tags the statement of tx. Statements gorm builds get the comment
+// before their leading clause; raw SQL is already written, so it is prefixed.
+func comment(leadingClause string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		id := FromContext(tx.Statement.Context)
+		// IDs are validated on the way in; never put anything else in a comment
+		if id == "" || !Valid(id) {
+			return
+		}
+		tag := "/* request_id='" + id + "' */"
+		if raw := tx.Statement.SQL.String(); raw != "" {
+			if !strings.HasPrefix(raw, tag) {
+				tx.Statement.SQL.Reset()
+				tx.Statement.SQL.WriteString(tag + " " + raw)
+			}
+			return
+		}
+		if leadingClause == "" {
+			return
+		}
+		c := tx.Statement.Clauses[leadingClause]
+		c.BeforeExpression = clause.Expr{SQL: tag}
+		tx.Statement.Clauses[leadingClause] = c
+	}
+}
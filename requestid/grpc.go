@@ -0,0 +1,45 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fromMetadata returns the request ID of an incoming call, generating one
+// when it is missing or unusable, and sends it back in the response header
+func fromMetadata(ctx context.Context) context.Context {
+	key := strings.ToLower(Header)
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(key)) > 0 {
+		id = md.Get(key)[0]
+	}
+	if !Valid(id) {
+		id = New()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(key, id))
+	return NewContext(ctx, id)
+}
+
+// UnaryServerInterceptor is Middleware for unary gRPC calls, taking the ID
+// from the x-request-id metadata
+func UnaryServerInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(fromMetadata(ctx), req)
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &serverStream{ServerStream: ss, ctx: fromMetadata(ss.Context())})
+}
+
+// serverStream is a server stream carrying the request ID
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
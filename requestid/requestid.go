@@ -0,0 +1,62 @@
+// Package requestid assigns every HTTP request a correlation ID that
+// follows it into responses, logs and the SQL it runs.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header carries the request ID in requests and responses
+const Header = "X-Request-ID"
+
+// maxLength bounds client supplied IDs
+const maxLength = 128
+
+// validID restricts client supplied IDs to characters that are safe in
+// headers, log lines and SQL comments
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or "" outside a request
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Valid reports whether id can be used as a request ID as is
+func Valid(id string) bool {
+	return len(id) <= maxLength && validID.MatchString(id)
+}
+
+// New generates a random request ID
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware takes the request ID from the X-Request-ID header, or
+// generates one when it is missing or unusable, stores it in the request
+// context and echoes it in the response header
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
@@ -0,0 +1,91 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type book struct {
+	ID   int
+	Name string
+}
+
+func serve(header string) (seen string, w *httptest.ResponseRecorder) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	if header != "" {
+		req.Header.Set(Header, header)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return seen, w
+}
+
+func TestMiddleware_KeepsClientID(t *testing.T) {
+	seen, w := serve("checkout-42")
+
+	assert.Equal(t, "checkout-42", seen)
+	assert.Equal(t, "checkout-42", w.Header().Get(Header))
+}
+
+func TestMiddleware_ReplacesMissingOrUnsafeID(t *testing.T) {
+	for _, header := range []string{"", "x' */ DROP TABLE books; --", strings.Repeat("a", 129)} {
+		seen, w := serve(header)
+
+		assert.Len(t, seen, 32, header)
+		assert.True(t, Valid(seen), header)
+		assert.Equal(t, seen, w.Header().Get(Header), header)
+	}
+}
+
+func TestInstrumentGORM_AddsComment(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, InstrumentGORM(db))
+
+	ctx := NewContext(context.Background(), "req-1")
+	var found book
+	stmt := db.WithContext(ctx).First(&found, 7).Statement
+	assert.Equal(t, `/* request_id='req-1' */ SELECT * FROM "books" WHERE "books"."id" = $1 ORDER BY "books"."id" LIMIT $2`, stmt.SQL.String())
+
+	stmt = db.WithContext(ctx).Create(&book{Name: "Dune"}).Statement
+	assert.True(t, strings.HasPrefix(stmt.SQL.String(), `/* request_id='req-1' */ INSERT INTO "books"`), stmt.SQL.String())
+
+	stmt = db.First(&found, 7).Statement
+	assert.True(t, strings.HasPrefix(stmt.SQL.String(), "SELECT"), "%q", stmt.SQL.String())
+}
+
+func TestInstrumentGORM_AddsCommentToRawSQL(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, InstrumentGORM(db))
+
+	ctx := NewContext(context.Background(), "req-1")
+	stmt := db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", "books", "{}").Statement
+	assert.Equal(t, `/* request_id='req-1' */ SELECT pg_notify($1, $2)`, stmt.SQL.String())
+
+	var count int64
+	stmt = db.WithContext(ctx).Raw("UPDATE books SET rating_count = rating_count + ? RETURNING rating_count", 1).Scan(&count).Statement
+	assert.Equal(t, `/* request_id='req-1' */ UPDATE books SET rating_count = rating_count + $1 RETURNING rating_count`, stmt.SQL.String())
+
+	stmt = db.Exec("SELECT 1").Statement
+	assert.Equal(t, "SELECT 1", stmt.SQL.String())
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"connection_to_pg/handlers"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	booksv1 "connection_to_pg/proto/books/v1"
+	"connection_to_pg/requestid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// SetupGRPC returns a gRPC server offering the BooksService of handler,
+// the standard health service and server reflection. Calls pass the same
+// middleware as HTTP requests do, in the same order.
+func SetupGRPC(handler *handlers.Handler) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			requestid.UnaryServerInterceptor,
+			logging.UnaryServerInterceptor,
+			metrics.UnaryServerInterceptor,
+			handler.AuthenticateUnary,
+		),
+		grpc.ChainStreamInterceptor(
+			requestid.StreamServerInterceptor,
+			logging.StreamServerInterceptor,
+			metrics.StreamServerInterceptor,
+			handler.AuthenticateStream,
+		),
+	)
+	booksv1.RegisterBooksServiceServer(server, handler.BooksService())
+
+	// The health service answers for the server as a whole, the empty
+	// service name, and for the books service
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(booksv1.BooksService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+	return server
+}
@@ -3,21 +3,106 @@ package routes
 
 import (
 	"connection_to_pg/handlers"
+	"connection_to_pg/logging"
+	"connection_to_pg/metrics"
+	"connection_to_pg/requestid"
+	"connection_to_pg/tracing"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
 // SetupRoutes initializes the router with all routes
 func SetupRoutes(handler *handlers.Handler) *chi.Mux {
 	r := chi.NewRouter()
 
-	r.Use(middleware.Logger)
-	r.Post("/books", handler.Create)
-	r.Get("/books", handler.GetAll)
-	r.Get("/books/{query}", handler.Get)
-	r.Put("/books/{bookID}", handler.Update)
-	r.Delete("/books/{bookID}", handler.Delete)
+	r.Use(requestid.Middleware)
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(handler.Authenticate)
+	r.NotFound(handlers.NotFound)
+	r.MethodNotAllowed(handlers.MethodNotAllowed)
+	r.Handle("/metrics", metrics.Handler())
+	r.Post("/books", tracing.HandlerFunc("Handler.Create", handler.Create))
+	r.Post("/books/import", tracing.HandlerFunc("Handler.Import", handler.Import))
+	r.Get("/books", tracing.HandlerFunc("Handler.GetAll", handler.GetAll))
+	r.Get("/books/events", tracing.HandlerFunc("Handler.StreamBookEvents", handler.StreamBookEvents))
+	r.Get("/books/export", tracing.HandlerFunc("Handler.Export", handler.Export))
+	r.Get("/books/isbn/{isbn}", tracing.HandlerFunc("Handler.GetByISBN", handler.GetByISBN))
+	r.Get("/books/{query}", tracing.HandlerFunc("Handler.Get", handler.Get))
+	r.Put("/books/{id}", tracing.HandlerFunc("Handler.Update", handler.Update))
+	r.Delete("/books/{id}", tracing.HandlerFunc("Handler.Delete", handler.Delete))
+	r.Get("/books/{id}/authors", tracing.HandlerFunc("Handler.GetBookAuthors", handler.GetBookAuthors))
+	r.Put("/books/{id}/authors", tracing.HandlerFunc("Handler.SetBookAuthors", handler.SetBookAuthors))
+	r.Get("/books/{id}/categories", tracing.HandlerFunc("Handler.GetBookCategories", handler.GetBookCategories))
+	r.Put("/books/{id}/categories", tracing.HandlerFunc("Handler.SetBookCategories", handler.SetBookCategories))
+	r.Get("/books/{id}/tags", tracing.HandlerFunc("Handler.GetBookTags", handler.GetBookTags))
+	r.Put("/books/{id}/tags", tracing.HandlerFunc("Handler.SetBookTags", handler.SetBookTags))
+	r.Put("/books/{id}/cover", tracing.HandlerFunc("Handler.PutCover", handler.PutCover))
+	r.Get("/books/{id}/cover", tracing.HandlerFunc("Handler.GetCover", handler.GetCover))
+	r.Get("/books/{id}/cover/{size}", tracing.HandlerFunc("Handler.GetCoverThumbnail", handler.GetCoverThumbnail))
+	r.Delete("/books/{id}/cover", tracing.HandlerFunc("Handler.DeleteCover", handler.DeleteCover))
+	r.Post("/books/{id}/copies", tracing.HandlerFunc("Handler.AddCopy", handler.AddCopy))
+	r.Get("/books/{id}/copies", tracing.HandlerFunc("Handler.GetBookCopies", handler.GetBookCopies))
+	r.Post("/books/{id}/holds", tracing.HandlerFunc("Handler.PlaceHold", handler.PlaceHold))
+	r.Get("/books/{id}/holds", tracing.HandlerFunc("Handler.GetBookHolds", handler.GetBookHolds))
+	r.Get("/books/{id}/reviews", tracing.HandlerFunc("Handler.ListReviews", handler.ListReviews))
+	r.Post("/books/{id}/reviews", tracing.HandlerFunc("Handler.CreateReview", handler.CreateReview))
+	r.Get("/books/{id}/reviews/{reviewID}", tracing.HandlerFunc("Handler.GetReview", handler.GetReview))
+	r.Put("/books/{id}/reviews/{reviewID}", tracing.HandlerFunc("Handler.UpdateReview", handler.UpdateReview))
+	r.Delete("/books/{id}/reviews/{reviewID}", tracing.HandlerFunc("Handler.DeleteReview", handler.DeleteReview))
+	r.Post("/authors", tracing.HandlerFunc("Handler.CreateAuthor", handler.CreateAuthor))
+	r.Get("/authors", tracing.HandlerFunc("Handler.ListAuthors", handler.ListAuthors))
+	r.Get("/authors/{id}", tracing.HandlerFunc("Handler.GetAuthor", handler.GetAuthor))
+	r.Put("/authors/{id}", tracing.HandlerFunc("Handler.UpdateAuthor", handler.UpdateAuthor))
+	r.Delete("/authors/{id}", tracing.HandlerFunc("Handler.DeleteAuthor", handler.DeleteAuthor))
+	r.Get("/authors/{id}/books", tracing.HandlerFunc("Handler.GetAuthorBooks", handler.GetAuthorBooks))
+	r.Post("/categories", tracing.HandlerFunc("Handler.CreateCategory", handler.CreateCategory))
+	r.Get("/categories", tracing.HandlerFunc("Handler.ListCategories", handler.ListCategories))
+	r.Get("/categories/{id}", tracing.HandlerFunc("Handler.GetCategory", handler.GetCategory))
+	r.Put("/categories/{id}", tracing.HandlerFunc("Handler.UpdateCategory", handler.UpdateCategory))
+	r.Delete("/categories/{id}", tracing.HandlerFunc("Handler.DeleteCategory", handler.DeleteCategory))
+	r.Post("/tags", tracing.HandlerFunc("Handler.CreateTag", handler.CreateTag))
+	r.Get("/tags", tracing.HandlerFunc("Handler.ListTags", handler.ListTags))
+	r.Delete("/tags/{id}", tracing.HandlerFunc("Handler.DeleteTag", handler.DeleteTag))
+	r.Post("/members", tracing.HandlerFunc("Handler.CreateMember", handler.CreateMember))
+	r.Get("/members", tracing.HandlerFunc("Handler.ListMembers", handler.ListMembers))
+	r.Get("/members/{id}", tracing.HandlerFunc("Handler.GetMember", handler.GetMember))
+	r.Put("/members/{id}", tracing.HandlerFunc("Handler.UpdateMember", handler.UpdateMember))
+	r.Get("/members/{id}/loans", tracing.HandlerFunc("Handler.GetMemberLoans", handler.GetMemberLoans))
+	r.Get("/members/{id}/fines", tracing.HandlerFunc("Handler.GetMemberFines", handler.GetMemberFines))
+	r.Post("/members/{id}/payments", tracing.HandlerFunc("Handler.RecordPayment", handler.RecordPayment))
+	r.Post("/members/{id}/waivers", tracing.HandlerFunc("Handler.RecordWaiver", handler.RecordWaiver))
+	r.Post("/loans", tracing.HandlerFunc("Handler.Checkout", handler.Checkout))
+	r.Get("/loans/overdue", tracing.HandlerFunc("Handler.GetOverdueLoans", handler.GetOverdueLoans))
+	r.Get("/loans/{id}", tracing.HandlerFunc("Handler.GetLoan", handler.GetLoan))
+	r.Post("/loans/{id}/renew", tracing.HandlerFunc("Handler.RenewLoan", handler.RenewLoan))
+	r.Post("/loans/{id}/return", tracing.HandlerFunc("Handler.ReturnLoan", handler.ReturnLoan))
+	r.Get("/shelves", tracing.HandlerFunc("Handler.ListShelves", handler.ListShelves))
+	r.Post("/shelves", tracing.HandlerFunc("Handler.CreateShelf", handler.CreateShelf))
+	r.Get("/shelves/{id}", tracing.HandlerFunc("Handler.GetShelf", handler.GetShelf))
+	r.Put("/shelves/{id}", tracing.HandlerFunc("Handler.UpdateShelf", handler.UpdateShelf))
+	r.Delete("/shelves/{id}", tracing.HandlerFunc("Handler.DeleteShelf", handler.DeleteShelf))
+	r.Post("/shelves/{id}/entries", tracing.HandlerFunc("Handler.AddShelfEntry", handler.AddShelfEntry))
+	r.Put("/shelves/{id}/entries/{entryID}", tracing.HandlerFunc("Handler.UpdateShelfEntry", handler.UpdateShelfEntry))
+	r.Delete("/shelves/{id}/entries/{entryID}", tracing.HandlerFunc("Handler.DeleteShelfEntry", handler.DeleteShelfEntry))
+	r.Put("/shelves/{id}/order", tracing.HandlerFunc("Handler.ReorderShelf", handler.ReorderShelf))
+	r.Post("/shelves/{id}/share", tracing.HandlerFunc("Handler.ShareShelf", handler.ShareShelf))
+	r.Delete("/shelves/{id}/share", tracing.HandlerFunc("Handler.UnshareShelf", handler.UnshareShelf))
+	r.Get("/shared/shelves/{token}", tracing.HandlerFunc("Handler.GetSharedShelf", handler.GetSharedShelf))
+	r.Post("/webhooks", tracing.HandlerFunc("Handler.CreateSubscription", handler.CreateSubscription))
+	r.Get("/webhooks", tracing.HandlerFunc("Handler.ListSubscriptions", handler.ListSubscriptions))
+	r.Get("/webhooks/dead-letters", tracing.HandlerFunc("Handler.GetDeadLetters", handler.GetDeadLetters))
+	r.Post("/webhooks/deliveries/{id}/retry", tracing.HandlerFunc("Handler.RetryDelivery", handler.RetryDelivery))
+	r.Get("/webhooks/{id}", tracing.HandlerFunc("Handler.GetSubscription", handler.GetSubscription))
+	r.Put("/webhooks/{id}", tracing.HandlerFunc("Handler.UpdateSubscription", handler.UpdateSubscription))
+	r.Delete("/webhooks/{id}", tracing.HandlerFunc("Handler.DeleteSubscription", handler.DeleteSubscription))
+	r.Get("/webhooks/{id}/deliveries", tracing.HandlerFunc("Handler.GetSubscriptionDeliveries", handler.GetSubscriptionDeliveries))
+	r.Post("/webhooks/{id}/replay", tracing.HandlerFunc("Handler.ReplaySubscription", handler.ReplaySubscription))
+	r.Get("/graphql", tracing.HandlerFunc("Handler.GraphQL", handler.GraphQL))
+	r.Post("/graphql", tracing.HandlerFunc("Handler.GraphQL", handler.GraphQL))
+	r.Get("/holds/{id}", tracing.HandlerFunc("Handler.GetHold", handler.GetHold))
+	r.Delete("/holds/{id}", tracing.HandlerFunc("Handler.CancelHold", handler.CancelHold))
 
 	return r
 }
@@ -0,0 +1,24 @@
+// Package storage keeps binary objects such as cover images outside the
+// database behind a small blob store interface.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when no object is stored under a key
+var ErrNotFound = errors.New("object not found")
+
+// Store is a flat key/value store for binary objects. Keys are slash
+// separated paths such as "covers/12/original".
+type Store interface {
+	// Put stores the content of r under key, replacing any previous object
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns a reader for the object stored under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key. Deleting a missing key is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
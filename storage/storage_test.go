@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFS(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "covers/1/original", strings.NewReader("first")))
+	require.NoError(t, store.Put(ctx, "covers/1/original", strings.NewReader("second")))
+
+	r, err := store.Open(ctx, "covers/1/original")
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "second", string(content))
+
+	require.NoError(t, store.Delete(ctx, "covers/1/original"))
+	require.NoError(t, store.Delete(ctx, "covers/1/original"))
+	_, err = store.Open(ctx, "covers/1/original")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalFS_RejectsEscapingKeys(t *testing.T) {
+	store, err := NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../outside", "/etc/passwd", `covers\..\x`} {
+		assert.Error(t, store.Put(context.Background(), key, strings.NewReader("x")), key)
+	}
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestSanitizeSQL(t *testing.T) {
+	cases := map[string]string{
+		`SELECT * FROM "books" WHERE "books"."id" = 42 LIMIT 1`: `SELECT * FROM "books" WHERE "books"."id" = ? LIMIT ?`,
+		`SELECT * FROM "books" WHERE author = $1 AND name = $2`: `SELECT * FROM "books" WHERE author = $1 AND name = $2`,
+		`UPDATE "books" SET "name"='O''Brien',"price"=9.99`:     `UPDATE "books" SET "name"=?,"price"=?`,
+		`SELECT * FROM table1`:                                  `SELECT * FROM table1`,
+	}
+	for query, want := range cases {
+		assert.Equal(t, want, SanitizeSQL(query), query)
+	}
+}
+
+func TestMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	recorder := useRecorder(t)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/books/{query}", HandlerFunc("Handler.Get", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/books/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	handler, server := spans[0], spans[1]
+
+	assert.Equal(t, "GET /books/{query}", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, codes.Error, server.Status().Code)
+	assert.Contains(t, server.Attributes(), attribute.Int("http.response.status_code", http.StatusInternalServerError))
+
+	assert.Equal(t, "Handler.Get", handler.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), handler.Parent().SpanID())
+
+	assert.Contains(t, w.Header().Get("traceparent"), server.SpanContext().SpanID().String())
+}
+
+func TestFileExporter_WritesOTLPJSON(t *testing.T) {
+	var buf bytes.Buffer
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(NewFileExporter(&buf)))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	_, child := provider.Tracer("test").Start(ctx, "child")
+	child.SetAttributes(attribute.Int("rows", 3), attribute.StringSlice("tags", []string{"a"}))
+	child.SetStatus(codes.Error, "boom")
+	child.End()
+	parent.End()
+	require.NoError(t, provider.Shutdown(context.Background()))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Scope struct{ Name string }
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string
+					Attributes   []map[string]interface{}
+					Status       struct{ Code int }
+				}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(lines[0], &request))
+	span := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	assert.Equal(t, "test", request.ResourceSpans[0].ScopeSpans[0].Scope.Name)
+	assert.Equal(t, "child", span.Name)
+	assert.Equal(t, parent.SpanContext().SpanID().String(), span.ParentSpanID)
+	assert.Equal(t, 2, span.Status.Code)
+	assert.Equal(t, map[string]interface{}{"key": "rows", "value": map[string]interface{}{"intValue": "3"}}, span.Attributes[0])
+}
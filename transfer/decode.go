@@ -0,0 +1,250 @@
+// Package transfer streams books in and out of the service in bulk formats.
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Supported bulk formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// Fields lists the book fields a record can be mapped onto
+var Fields = []string{"name", "description", "author"}
+
+// maxLineSize bounds a single NDJSON line so a broken upload can't exhaust memory
+const maxLineSize = 1024 * 1024
+
+// Record is one decoded row of input, keyed by book field name
+type Record struct {
+	Row    int
+	Fields map[string]string
+	// Err is set when this row could not be decoded; later rows may still be fine
+	Err error
+}
+
+// Decoder yields records one at a time and returns io.EOF when done
+type Decoder interface {
+	Next() (Record, error)
+}
+
+// FormatFromContentType maps a MIME type onto a bulk format
+func FormatFromContentType(contentType string) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return FormatNDJSON
+	case "application/json":
+		return FormatJSON
+	}
+	return ""
+}
+
+// FormatFromFilename maps a file extension onto a bulk format
+func FormatFromFilename(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".json":
+		return FormatJSON
+	}
+	return ""
+}
+
+// ParseMapping parses a column mapping of the form "source:field,source:field"
+func ParseMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		source, field, ok := strings.Cut(pair, ":")
+		source, field = strings.TrimSpace(source), strings.ToLower(strings.TrimSpace(field))
+		if !ok || source == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected source:field", pair)
+		}
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown field %q in mapping, expected one of %s", field, strings.Join(Fields, ", "))
+		}
+		mapping[strings.ToLower(source)] = field
+	}
+	return mapping, nil
+}
+
+func isField(name string) bool {
+	for _, f := range Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldFor resolves the book field a source column maps onto. Columns that
+// are not mapped explicitly match a field of the same name.
+func fieldFor(mapping map[string]string, column string) string {
+	column = strings.ToLower(strings.TrimSpace(column))
+	if field, ok := mapping[column]; ok {
+		return field
+	}
+	if isField(column) {
+		return column
+	}
+	return ""
+}
+
+// NewDecoder returns a streaming decoder for format reading from r
+func NewDecoder(r io.Reader, format string, mapping map[string]string) (Decoder, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVDecoder(r, mapping)
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &ndjsonDecoder{scanner: scanner, mapping: mapping}, nil
+	case FormatJSON:
+		return newJSONDecoder(r, mapping)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+type csvDecoder struct {
+	reader  *csv.Reader
+	columns []string
+	row     int
+}
+
+func newCSVDecoder(r io.Reader, mapping map[string]string) (*csvDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv input has no header row")
+		}
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	mapped := false
+	for i, column := range header {
+		columns[i] = fieldFor(mapping, strings.TrimPrefix(column, "\ufeff"))
+		mapped = mapped || columns[i] != ""
+	}
+	if !mapped {
+		return nil, fmt.Errorf("csv header %q has no columns mapping onto %s", strings.Join(header, ","), strings.Join(Fields, ", "))
+	}
+	return &csvDecoder{reader: reader, columns: columns, row: 1}, nil
+}
+
+func (d *csvDecoder) Next() (Record, error) {
+	values, err := d.reader.Read()
+	d.row++
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Record{Row: d.row, Err: parseErr.Err}, nil
+		}
+		return Record{}, err
+	}
+
+	fields := make(map[string]string, len(Fields))
+	for i, value := range values {
+		if i < len(d.columns) && d.columns[i] != "" {
+			fields[d.columns[i]] = value
+		}
+	}
+	return Record{Row: d.row, Fields: fields}, nil
+}
+
+type ndjsonDecoder struct {
+	scanner *bufio.Scanner
+	mapping map[string]string
+	row     int
+}
+
+func (d *ndjsonDecoder) Next() (Record, error) {
+	for d.scanner.Scan() {
+		d.row++
+		line := strings.TrimSpace(d.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &object); err != nil {
+			return Record{Row: d.row, Err: fmt.Errorf("invalid JSON: %w", err)}, nil
+		}
+		return objectRecord(d.row, object, d.mapping), nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+// jsonDecoder streams the elements of a top level JSON array
+type jsonDecoder struct {
+	decoder *json.Decoder
+	mapping map[string]string
+	row     int
+}
+
+func newJSONDecoder(r io.Reader, mapping map[string]string) (*jsonDecoder, error) {
+	decoder := json.NewDecoder(r)
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("read json: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("json input must be an array of objects")
+	}
+	return &jsonDecoder{decoder: decoder, mapping: mapping}, nil
+}
+
+func (d *jsonDecoder) Next() (Record, error) {
+	if !d.decoder.More() {
+		return Record{}, io.EOF
+	}
+	d.row++
+	var object map[string]json.RawMessage
+	if err := d.decoder.Decode(&object); err != nil {
+		// The decoder can't resynchronise inside a malformed array
+		return Record{}, fmt.Errorf("element %d: %w", d.row, err)
+	}
+	return objectRecord(d.row, object, d.mapping), nil
+}
+
+func objectRecord(row int, object map[string]json.RawMessage, mapping map[string]string) Record {
+	fields := make(map[string]string, len(Fields))
+	for key, raw := range object {
+		field := fieldFor(mapping, key)
+		if field == "" {
+			continue
+		}
+		var value *string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return Record{Row: row, Err: fmt.Errorf("field %q must be a string", key)}
+		}
+		if value != nil {
+			fields[field] = *value
+		}
+	}
+	return Record{Row: row, Fields: fields}
+}
@@ -0,0 +1,48 @@
+package transfer
+
+import (
+	"connection_to_pg/models"
+
+	"gorm.io/gorm"
+)
+
+// DefaultBatchSize is the number of rows written between flushes
+const DefaultBatchSize = 500
+
+// Export walks the rows selected by query through a database cursor, in
+// the order query sets or by ID, and hands each book to enc. After every
+// batch of rows flush is called so the output leaves the process
+// incrementally; flush may be nil.
+func Export(query *gorm.DB, enc Encoder, batchSize int, flush func()) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	if _, ordered := query.Statement.Clauses["ORDER BY"]; !ordered {
+		query = query.Order("id")
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var book models.Book
+		if err := query.ScanRows(rows, &book); err != nil {
+			return count, err
+		}
+		if err := enc.Encode(book); err != nil {
+			return count, err
+		}
+		count++
+		if count%batchSize == 0 && flush != nil {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, enc.Close()
+}
@@ -0,0 +1,216 @@
+package transfer
+
+import (
+	"connection_to_pg/models"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Store is the subset of database operations an import needs
+type Store interface {
+	Create(value interface{}) *gorm.DB
+	First(dest interface{}, conds ...interface{}) *gorm.DB
+	Save(value interface{}) *gorm.DB
+}
+
+// maxReportedErrors caps the error list so huge broken uploads stay cheap
+const maxReportedErrors = 1000
+
+// maxFieldLength is the longest value accepted per field, in runes
+var maxFieldLength = map[string]int{
+	"name":        255,
+	"author":      255,
+	"description": 10000,
+}
+
+// ImportOptions controls how records are written
+type ImportOptions struct {
+	// Key names the natural key fields used to find an existing book to
+	// update. Without a key every record creates a new book.
+	Key []string
+	// DryRun validates and resolves every record without writing anything
+	DryRun bool
+	// AbortOnError stops at the first failing record. Records written
+	// before it are kept.
+	AbortOnError bool
+}
+
+// RowError describes why a single record was rejected
+type RowError struct {
+	Row   int    `json:"row" xml:"row"`
+	Field string `json:"field,omitempty" xml:"field,omitempty"`
+	Error string `json:"error" xml:"error"`
+}
+
+// ImportReport summarises an import run
+type ImportReport struct {
+	DryRun          bool       `json:"dry_run" xml:"dry_run"`
+	Processed       int        `json:"processed" xml:"processed"`
+	Created         int        `json:"created" xml:"created"`
+	Updated         int        `json:"updated" xml:"updated"`
+	Failed          int        `json:"failed" xml:"failed"`
+	Aborted         bool       `json:"aborted" xml:"aborted"`
+	Errors          []RowError `json:"errors" xml:"errors>error"`
+	ErrorsTruncated bool       `json:"errors_truncated,omitempty" xml:"errors_truncated,omitempty"`
+}
+
+// KeyFields lists the fields that can form a natural key
+var KeyFields = []string{"name", "author"}
+
+// ParseKey parses a comma separated natural key such as "name,author"
+func ParseKey(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var key []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		valid := false
+		for _, k := range KeyFields {
+			valid = valid || k == field
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown key field %q, expected any of %s", field, strings.Join(KeyFields, ", "))
+		}
+		key = append(key, field)
+	}
+	return key, nil
+}
+
+// Validate checks the fields of a record before it is written
+func Validate(fields map[string]string) []RowError {
+	var errs []RowError
+	if strings.TrimSpace(fields["name"]) == "" {
+		errs = append(errs, RowError{Field: "name", Error: "name is required"})
+	}
+	for _, field := range Fields {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+		if !utf8.ValidString(value) {
+			errs = append(errs, RowError{Field: field, Error: "value is not valid UTF-8"})
+		} else if n := utf8.RuneCountInString(value); n > maxFieldLength[field] {
+			errs = append(errs, RowError{Field: field, Error: fmt.Sprintf("value is %d characters long, at most %d allowed", n, maxFieldLength[field])})
+		}
+	}
+	return errs
+}
+
+// Import reads every record from dec and creates or updates the matching
+// books. The returned error is only set when the input stream itself
+// failed; per record problems are collected in the report.
+func Import(store Store, dec Decoder, opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{DryRun: opts.DryRun, Errors: []RowError{}}
+
+	for {
+		record, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return report, nil
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Processed++
+
+		errs := importRecord(store, record, opts, &report)
+		if len(errs) == 0 {
+			continue
+		}
+
+		report.Failed++
+		for _, e := range errs {
+			if len(report.Errors) >= maxReportedErrors {
+				report.ErrorsTruncated = true
+				break
+			}
+			e.Row = record.Row
+			report.Errors = append(report.Errors, e)
+		}
+		if opts.AbortOnError {
+			report.Aborted = true
+			return report, nil
+		}
+	}
+}
+
+func importRecord(store Store, record Record, opts ImportOptions, report *ImportReport) []RowError {
+	if record.Err != nil {
+		return []RowError{{Error: record.Err.Error()}}
+	}
+	if errs := Validate(record.Fields); len(errs) > 0 {
+		return errs
+	}
+
+	var book models.Book
+	found := false
+	if len(opts.Key) > 0 {
+		var err error
+		found, err = findByKey(store, &book, record.Fields, opts.Key)
+		if err != nil {
+			return []RowError{{Error: err.Error()}}
+		}
+	}
+	applyFields(&book, record.Fields)
+
+	if found {
+		if !opts.DryRun {
+			if err := store.Save(&book).Error; err != nil {
+				return []RowError{{Error: fmt.Sprintf("update book %d: %v", book.ID, err)}}
+			}
+		}
+		report.Updated++
+		return nil
+	}
+
+	if !opts.DryRun {
+		if err := store.Create(&book).Error; err != nil {
+			return []RowError{{Error: fmt.Sprintf("create book: %v", err)}}
+		}
+	}
+	report.Created++
+	return nil
+}
+
+// findByKey loads the book whose key fields equal the record's values
+func findByKey(store Store, book *models.Book, fields map[string]string, key []string) (bool, error) {
+	conditions := make([]string, len(key))
+	args := make([]interface{}, len(key))
+	for i, field := range key {
+		value, ok := fields[field]
+		if !ok {
+			return false, fmt.Errorf("key field %q is missing", field)
+		}
+		// Key fields come from the KeyFields allow list, never from input
+		conditions[i] = field + " = ?"
+		args[i] = value
+	}
+
+	conds := append([]interface{}{strings.Join(conditions, " AND ")}, args...)
+	err := store.First(book, conds...).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("look up existing book: %v", err)
+	}
+	return true, nil
+}
+
+// applyFields copies the values present in a record onto book
+func applyFields(book *models.Book, fields map[string]string) {
+	if v, ok := fields["name"]; ok {
+		book.Name = v
+	}
+	if v, ok := fields["description"]; ok {
+		book.Description = v
+	}
+	if v, ok := fields["author"]; ok {
+		book.Author = v
+	}
+}
@@ -0,0 +1,163 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"connection_to_pg/mocks"
+	"connection_to_pg/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func readAll(t *testing.T, dec Decoder) []Record {
+	var records []Record
+	for {
+		record, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestCSVDecoder_MapsColumns(t *testing.T) {
+	input := "Title,Writer,Pages\nDune,Frank Herbert,412\n\"Hyperion, Vol. 1\",Dan Simmons,482\n"
+	mapping, err := ParseMapping("title:name,writer:author")
+	require.NoError(t, err)
+
+	dec, err := NewDecoder(strings.NewReader(input), FormatCSV, mapping)
+	require.NoError(t, err)
+	records := readAll(t, dec)
+
+	require.Len(t, records, 2)
+	assert.Equal(t, Record{Row: 2, Fields: map[string]string{"name": "Dune", "author": "Frank Herbert"}}, records[0])
+	assert.Equal(t, "Hyperion, Vol. 1", records[1].Fields["name"])
+}
+
+func TestCSVDecoder_HeaderWithoutKnownColumns(t *testing.T) {
+	_, err := NewDecoder(strings.NewReader("a,b\n1,2\n"), FormatCSV, nil)
+
+	assert.ErrorContains(t, err, "has no columns mapping onto")
+}
+
+func TestNDJSONDecoder_SkipsBlankLinesAndReportsBadRows(t *testing.T) {
+	input := "{\"name\":\"One\",\"author\":\"A\"}\n\nnot json\n{\"name\":42}\n"
+
+	dec, err := NewDecoder(strings.NewReader(input), FormatNDJSON, nil)
+	require.NoError(t, err)
+	records := readAll(t, dec)
+
+	require.Len(t, records, 3)
+	assert.Equal(t, map[string]string{"name": "One", "author": "A"}, records[0].Fields)
+	assert.Equal(t, 3, records[1].Row)
+	assert.Error(t, records[1].Err)
+	assert.EqualError(t, records[2].Err, `field "name" must be a string`)
+}
+
+func TestJSONDecoder_RequiresArray(t *testing.T) {
+	_, err := NewDecoder(strings.NewReader(`{"name":"x"}`), FormatJSON, nil)
+
+	assert.EqualError(t, err, "json input must be an array of objects")
+}
+
+func TestParseMapping_UnknownField(t *testing.T) {
+	_, err := ParseMapping("title:headline")
+
+	assert.ErrorContains(t, err, `unknown field "headline"`)
+}
+
+func TestImport_UpsertsByKey(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	input := "name,author,description\nDune,Frank Herbert,updated\nEmma,Jane Austen,new\n"
+	dec, err := NewDecoder(strings.NewReader(input), FormatCSV, nil)
+	require.NoError(t, err)
+
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), "name = ?").
+		Run(func(args mock.Arguments) {
+			// Only "Dune" exists already
+			*args.Get(0).(*models.Book) = models.Book{ID: 5, Name: "Dune", Author: "Frank Herbert"}
+		}).Return(&gorm.DB{}).Once()
+	mockDB.On("First", mock.AnythingOfType("*models.Book"), "name = ?").
+		Return(&gorm.DB{Error: gorm.ErrRecordNotFound}).Once()
+	mockDB.On("Save", mock.MatchedBy(func(b *models.Book) bool { return b.ID == 5 && b.Description == "updated" })).Return(&gorm.DB{})
+	mockDB.On("Create", mock.MatchedBy(func(b *models.Book) bool { return b.Name == "Emma" })).Return(nil)
+
+	report, err := Import(mockDB, dec, ImportOptions{Key: []string{"name"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Processed)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 1, report.Created)
+	assert.Empty(t, report.Errors)
+	mockDB.AssertExpectations(t)
+}
+
+func TestImport_CollectsRowErrors(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	input := "name,author\n,Nobody\nValid,Someone\n"
+	dec, err := NewDecoder(strings.NewReader(input), FormatCSV, nil)
+	require.NoError(t, err)
+
+	mockDB.On("Create", mock.AnythingOfType("*models.Book")).Return(nil)
+
+	report, err := Import(mockDB, dec, ImportOptions{})
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Processed)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, []RowError{{Row: 2, Field: "name", Error: "name is required"}}, report.Errors)
+}
+
+func TestImport_AbortOnFirstError(t *testing.T) {
+	mockDB := new(mocks.MockDB)
+	input := "name\nFirst\n\nThird\n"
+	dec, err := NewDecoder(strings.NewReader(input), FormatNDJSON, nil)
+	require.NoError(t, err)
+
+	report, err := Import(mockDB, dec, ImportOptions{AbortOnError: true})
+
+	require.NoError(t, err)
+	assert.True(t, report.Aborted)
+	assert.Equal(t, 1, report.Processed)
+	mockDB.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func encodeAll(t *testing.T, format string, books []models.Book) string {
+	var out strings.Builder
+	enc, err := NewEncoder(&out, format)
+	require.NoError(t, err)
+	for _, book := range books {
+		require.NoError(t, enc.Encode(book))
+	}
+	require.NoError(t, enc.Close())
+	return out.String()
+}
+
+func TestEncoders(t *testing.T) {
+	books := []models.Book{
+		{ID: 1, Name: "Dune", Author: "Frank Herbert"},
+		{ID: 2, Name: "Hyperion, Vol. 1", Description: "Pilgrims", Author: "Dan Simmons"},
+	}
+
+	assert.Equal(t, "id,name,description,author\n1,Dune,,Frank Herbert\n2,\"Hyperion, Vol. 1\",Pilgrims,Dan Simmons\n", encodeAll(t, FormatCSV, books))
+	assert.Equal(t, "{\"id\":1,\"name\":\"Dune\",\"description\":\"\",\"author\":\"Frank Herbert\"}\n{\"id\":2,\"name\":\"Hyperion, Vol. 1\",\"description\":\"Pilgrims\",\"author\":\"Dan Simmons\"}\n", encodeAll(t, FormatNDJSON, books))
+
+	var decoded []models.Book
+	require.NoError(t, json.Unmarshal([]byte(encodeAll(t, FormatJSON, books)), &decoded))
+	assert.Equal(t, books, decoded)
+}
+
+func TestEncoders_Empty(t *testing.T) {
+	assert.Equal(t, "id,name,description,author\n", encodeAll(t, FormatCSV, nil))
+	assert.Equal(t, "", encodeAll(t, FormatNDJSON, nil))
+	assert.Equal(t, "[]\n", encodeAll(t, FormatJSON, nil))
+}
@@ -0,0 +1,235 @@
+package webhooks
+
+import (
+	"bytes"
+	"connection_to_pg/db"
+	"connection_to_pg/metrics"
+	"connection_to_pg/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// skipLocked locks the selected rows and skips rows other dispatchers hold,
+// so several instances can share the work
+var skipLocked = clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}
+
+// Dispatcher moves events from the outbox to the webhook subscriptions. It
+// polls in two steps: new events are fanned out into one pending delivery
+// per interested subscription, then due deliveries are posted. A delivery
+// is claimed before it is posted, so it is not sent twice concurrently,
+// but a crash after posting can repeat it; receivers should deduplicate on
+// the CloudEvents id.
+type Dispatcher struct {
+	db     db.Database
+	client *http.Client
+	config models.WebhookConfig
+	now    func() time.Time
+}
+
+// NewDispatcher returns a dispatcher working on database
+func NewDispatcher(database db.Database, config models.WebhookConfig) *Dispatcher {
+	return &Dispatcher{
+		db:     database,
+		client: &http.Client{Timeout: config.Timeout},
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Run polls until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.Poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("error dispatching webhooks", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fans out new events and posts the deliveries that are due
+func (d *Dispatcher) Poll(ctx context.Context) error {
+	if err := d.fanOut(ctx); err != nil {
+		return fmt.Errorf("fan out events: %w", err)
+	}
+	deliveries, lease, err := d.claimDue(ctx)
+	if err != nil {
+		return fmt.Errorf("claim deliveries: %w", err)
+	}
+	for i := range deliveries {
+		// A post that can't finish before the lease runs out is left to
+		// whichever dispatcher claims the delivery next
+		if ctx.Err() != nil || d.now().Add(d.config.Timeout).After(lease) {
+			return nil
+		}
+		d.attempt(ctx, &deliveries[i])
+		if err := d.record(ctx, &deliveries[i], lease); err != nil {
+			return fmt.Errorf("record delivery %d: %w", deliveries[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// fanOut queues a delivery of every undispatched event to each active
+// subscription that wants it
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	return db.Transaction(ctx, d.db, func(tx *gorm.DB) error {
+		var events []models.OutboxEvent
+		if err := tx.Clauses(skipLocked).Where("dispatched_at IS NULL").Order("id").Limit(d.config.BatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		var subscriptions []models.WebhookSubscription
+		if err := tx.Find(&subscriptions, "active").Error; err != nil {
+			return err
+		}
+
+		now := d.now()
+		var deliveries []models.WebhookDelivery
+		ids := make([]int64, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+			for _, subscription := range subscriptions {
+				if subscription.Wants(event.Type) {
+					deliveries = append(deliveries, models.WebhookDelivery{
+						SubscriptionID: subscription.ID,
+						EventID:        event.ID,
+						Status:         models.DeliveryPending,
+						NextAttemptAt:  now,
+					})
+				}
+			}
+		}
+		if len(deliveries) > 0 {
+			if err := tx.Create(&deliveries).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.OutboxEvent{}).Where("id IN ?", ids).Update("dispatched_at", now).Error
+	})
+}
+
+// claimDue loads the pending deliveries that are due together with their
+// event and subscription and pushes their next attempt to the returned
+// lease, which keeps other dispatchers off them while they are posted
+func (d *Dispatcher) claimDue(ctx context.Context) ([]models.WebhookDelivery, time.Time, error) {
+	var deliveries []models.WebhookDelivery
+	var lease time.Time
+	err := db.Transaction(ctx, d.db, func(tx *gorm.DB) error {
+		deliveries = nil
+		now := d.now()
+		err := tx.Clauses(skipLocked).Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at").Limit(d.config.BatchSize).Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		ids := make([]int, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		lease = d.lease(now, len(deliveries))
+		if err := tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error; err != nil {
+			return err
+		}
+		return tx.Preload("Event").Preload("Subscription").Find(&deliveries, ids).Error
+	})
+	return deliveries, lease, err
+}
+
+// lease returns until when claiming n deliveries at now holds them: long
+// enough to post them one after another, each up to the request timeout,
+// plus one timeout to spare. It is kept to the microsecond the database
+// stores, so that record can match it.
+func (d *Dispatcher) lease(now time.Time, n int) time.Time {
+	return now.Add(time.Duration(n+1) * d.config.Timeout).Truncate(time.Microsecond)
+}
+
+// record saves the outcome of an attempt, unless the lease ran out and
+// another dispatcher claimed the delivery since, which then owns its state
+func (d *Dispatcher) record(ctx context.Context, delivery *models.WebhookDelivery, lease time.Time) error {
+	result := d.db.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND next_attempt_at = ?", delivery.ID, lease).
+		Updates(map[string]interface{}{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"last_attempt_at": delivery.LastAttemptAt,
+			"response_status": delivery.ResponseStatus,
+			"last_error":      delivery.LastError,
+			"delivered_at":    delivery.DeliveredAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		slog.Warn("webhook delivery lease ran out, outcome not recorded", "delivery_id", delivery.ID, "status", delivery.Status)
+	}
+	return nil
+}
+
+// attempt posts a delivery and records the outcome on it
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	status, err := d.post(ctx, delivery)
+	now := d.now()
+	switch {
+	case err == nil:
+		delivery.Succeeded(status, now)
+		metrics.WebhookAttempts.WithLabelValues("delivered").Inc()
+	default:
+		delivery.Failed(status, err.Error(), now, d.config.MaxAttempts, Backoff(delivery.Attempts+1))
+		outcome := "failed"
+		if delivery.Status == models.DeliveryDead {
+			outcome = "dead"
+		}
+		metrics.WebhookAttempts.WithLabelValues(outcome).Inc()
+		slog.Warn("webhook delivery failed", "delivery_id", delivery.ID, "subscription_id", delivery.SubscriptionID,
+			"attempts", delivery.Attempts, "status", delivery.Status, "error", err)
+	}
+	// The associations were only loaded for the request
+	delivery.Event, delivery.Subscription = nil, nil
+}
+
+// post sends a delivery as a structured CloudEvents request. Any 2xx
+// response counts as delivered.
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	if delivery.Event == nil || delivery.Subscription == nil {
+		return 0, fmt.Errorf("delivery %d has no event or subscription", delivery.ID)
+	}
+	body, err := json.Marshal(delivery.Event.CloudEvent())
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(DeliveryHeader, fmt.Sprint(delivery.ID))
+	req.Header.Set(SignatureHeader, Sign(delivery.Subscription.Secret, d.now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
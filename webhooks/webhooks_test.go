@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"connection_to_pg/db"
+	"connection_to_pg/models"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	header := Sign("whsec_test", now, body)
+
+	assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, header)
+	assert.NoError(t, Verify("whsec_test", header, body, now.Add(time.Minute), 5*time.Minute))
+	assert.ErrorIs(t, Verify("whsec_other", header, body, now, 5*time.Minute), ErrBadSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, []byte(`{"id":"2"}`), now, 5*time.Minute), ErrBadSignature)
+	assert.ErrorIs(t, Verify("whsec_test", header, body, now.Add(time.Hour), 5*time.Minute), ErrStale)
+	assert.ErrorIs(t, Verify("whsec_test", "v1=abc", body, now, 5*time.Minute), ErrBadSignature)
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 3: 2 * time.Minute, 20: maxBackoff} {
+		for range 20 {
+			d := Backoff(attempts)
+			assert.GreaterOrEqual(t, d, want/2, "attempt %d", attempts)
+			assert.LessOrEqual(t, d, want, "attempt %d", attempts)
+		}
+	}
+}
+
+func testDelivery(url string) *models.WebhookDelivery {
+	event, _ := models.NewBookEvent(models.EventBookCreated, &models.Book{ID: 3, Name: "Emma"})
+	return &models.WebhookDelivery{
+		ID:            9,
+		Status:        models.DeliveryPending,
+		Event:         &event,
+		Subscription:  &models.WebhookSubscription{URL: url, Secret: "whsec_test", Active: true},
+		NextAttemptAt: time.Now(),
+	}
+}
+
+func TestDispatcher_Attempt(t *testing.T) {
+	var received http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(nil, models.WebhookConfig{Timeout: time.Second, MaxAttempts: 3})
+	delivery := testDelivery(server.URL)
+	eventID := delivery.Event.EventID
+	d.attempt(context.Background(), delivery)
+
+	assert.Equal(t, models.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 204, delivery.ResponseStatus)
+	assert.Nil(t, delivery.Event)
+	assert.Equal(t, "application/cloudevents+json", received.Get("Content-Type"))
+	assert.Equal(t, "9", received.Get(DeliveryHeader))
+	assert.NoError(t, Verify("whsec_test", received.Get(SignatureHeader), body, time.Now(), time.Minute))
+
+	var event models.CloudEvent
+	require.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, eventID, event.ID)
+	assert.Equal(t, models.EventBookCreated, event.Type)
+}
+
+func TestDispatcher_AttemptFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(nil, models.WebhookConfig{Timeout: time.Second, MaxAttempts: 2})
+	delivery := testDelivery(server.URL)
+	d.attempt(context.Background(), delivery)
+
+	assert.Equal(t, models.DeliveryPending, delivery.Status)
+	assert.Equal(t, 500, delivery.ResponseStatus)
+	assert.Contains(t, delivery.LastError, "500")
+	assert.True(t, delivery.NextAttemptAt.After(time.Now().Add(10*time.Second)))
+
+	delivery = testDelivery(server.URL)
+	delivery.Attempts = 1
+	d.attempt(context.Background(), delivery)
+	assert.Equal(t, models.DeliveryDead, delivery.Status)
+}
+
+// updateConn records the statements executed on it, each affecting
+// affected rows
+type updateConn struct {
+	affected   int64
+	statements []string
+	args       [][]driver.NamedValue
+}
+
+func (c *updateConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *updateConn) Driver() driver.Driver                        { return nil }
+func (c *updateConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *updateConn) Close() error                                 { return nil }
+func (c *updateConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (c *updateConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.statements = append(c.statements, query)
+	c.args = append(c.args, args)
+	return driver.RowsAffected(c.affected), nil
+}
+
+func TestDispatcher_Lease(t *testing.T) {
+	d := NewDispatcher(nil, models.WebhookConfig{Timeout: 10 * time.Second})
+	now := time.Date(2025, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	assert.Equal(t, time.Date(2025, 3, 1, 12, 16, 50, 123456000, time.UTC), d.lease(now, 100))
+}
+
+func TestDispatcher_RecordHoldsLease(t *testing.T) {
+	for _, affected := range []int64{1, 0} {
+		conn := &updateConn{affected: affected}
+		database, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{
+			DisableAutomaticPing:   true,
+			SkipDefaultTransaction: true,
+			Logger:                 logger.Discard,
+		})
+		require.NoError(t, err)
+		d := NewDispatcher(&db.DatabaseImpl{DB: database}, models.WebhookConfig{Timeout: time.Second})
+		lease := time.Date(2025, 3, 1, 12, 0, 20, 0, time.UTC)
+		delivery := &models.WebhookDelivery{ID: 9, Status: models.DeliveryDelivered, Attempts: 1}
+
+		// Once another dispatcher took the delivery over, nothing matches
+		// and the outcome is dropped rather than overwriting its state
+		assert.NoError(t, d.record(context.Background(), delivery, lease))
+		require.Len(t, conn.statements, 1)
+		assert.Contains(t, conn.statements[0], `UPDATE "webhook_deliveries" SET`)
+		assert.Contains(t, conn.statements[0], "WHERE id = $8 AND next_attempt_at = $9")
+		assert.Equal(t, lease, conn.args[0][8].Value)
+	}
+}